@@ -2,17 +2,23 @@ package main
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/spf13/cobra"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/checksumcache"
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/executor"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/journal"
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/logger"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planfile"
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
 )
@@ -27,54 +33,85 @@ var (
 var (
 	dryRun         bool
 	deleteFlag     bool
-	excludes       []string
-	includes       []string
+	filters        []planner.Filter
 	quiet          bool
 	concurrency    int
+	maxAttempts    int
 	profile        string
 	region         string
 	planJSONFile   string
+	planOutFile    string
 	resultJSONFile string
+	journalFile    string
+	gracePeriod    time.Duration
+	resumeFile     string
+	checksumCache  string
+
+	checksumAlgorithm string
+	backfillChecksums bool
+	sourceMetadata    bool
+	hashConcurrency   int
+	headConcurrency   int
+	keepGoing         bool
+	sizeOnly          bool
+	exactTimestamps   bool
+	endpointURL       string
+	forcePathStyle    bool
+	onMissingChecksum string
 )
 
-// PlanResult represents the planned operations before execution
-type PlanResult struct {
-	Files   []PlanFile  `json:"files"`
-	Summary PlanSummary `json:"summary"`
-}
-
-type PlanFile struct {
-	Action string `json:"action"` // "skip", "create", "update", "delete"
-	Source string `json:"source,omitempty"`
-	Target string `json:"target"`
-	Reason string `json:"reason"`
-}
-
-type PlanSummary struct {
-	Skip   int `json:"skip"`
-	Create int `json:"create"`
-	Update int `json:"update"`
-	Delete int `json:"delete"`
-}
+// Values of --on-missing-checksum
+const (
+	missingChecksumError    = "error"
+	missingChecksumSizeOnly = "size-only"
+)
 
 // SyncResult represents the actual execution results
 type SyncResult struct {
-	Files   []ResultFile  `json:"files"`
-	Errors  []ErrorFile   `json:"errors"`
-	Summary ResultSummary `json:"summary"`
+	Status string       `json:"status"` // "completed", "failed", "interrupted"
+	Files  []ResultFile `json:"files"`
+	Errors []ErrorFile  `json:"errors"`
+	// NotStarted lists the planned operations an interrupt stopped from
+	// starting
+	NotStarted []PendingFile `json:"not_started"`
+	Summary    ResultSummary `json:"summary"`
 }
 
+// Values of SyncResult.Status
+const (
+	statusCompleted   = "completed"
+	statusFailed      = "failed"
+	statusInterrupted = "interrupted"
+)
+
 type ResultFile struct {
-	Result string `json:"result"` // "skipped", "created", "updated", "deleted"
-	Source string `json:"source,omitempty"`
-	Target string `json:"target"`
+	Result   string `json:"result"` // "skipped", "created", "updated", "deleted", "backfilled"
+	Source   string `json:"source,omitempty"`
+	Target   string `json:"target"`
+	Attempts int    `json:"attempts,omitempty"`
 }
 
 type ErrorFile struct {
-	Action string `json:"action"` // "create", "update", "delete"
+	Action   string `json:"action"` // "create", "update", "delete", "backfill", "compare"
+	Source   string `json:"source,omitempty"`
+	Target   string `json:"target"`
+	Error    string `json:"error"`
+	Code     string `json:"code,omitempty"` // "checksum_mismatch"
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+type PendingFile struct {
+	Action string `json:"action"` // "create", "update", "delete", "backfill"
 	Source string `json:"source,omitempty"`
 	Target string `json:"target"`
-	Error  string `json:"error"`
+}
+
+// errorCode classifies errors that need attention beyond a retry.
+func errorCode(err error) string {
+	if errors.Is(err, s3client.ErrChecksumMismatch) {
+		return "checksum_mismatch"
+	}
+	return ""
 }
 
 type ResultSummary struct {
@@ -83,11 +120,15 @@ type ResultSummary struct {
 	Updated int `json:"updated"`
 	Deleted int `json:"deleted"`
 	Failed  int `json:"failed"`
+	// Backfilled counts objects whose checksum S3 calculated and that
+	// matched their local file
+	Backfilled int `json:"backfilled"`
+	NotStarted int `json:"not_started"`
 }
 
 func main() {
 	rootCmd := &cobra.Command{
-		Use:   "strict-s3-sync <LocalPath> <S3Uri>",
+		Use:   "strict-s3-sync <LocalPath> <S3Uri> or <S3Uri> <LocalPath> or <S3Uri> <S3Uri>",
 		Short: "Strict S3 synchronization tool using CRC64NVME checksums",
 		Long: `strict-s3-sync is a reliable S3 sync tool that uses CRC64NVME checksums
 for accurate file comparison, ensuring data integrity.`,
@@ -95,33 +136,352 @@ for accurate file comparison, ensuring data integrity.`,
 		Args:    cobra.ExactArgs(2),
 		RunE:    run,
 	}
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
 
 	rootCmd.Flags().BoolVar(&dryRun, "dryrun", false, "Shows operations without executing")
-	rootCmd.Flags().BoolVar(&deleteFlag, "delete", false, "Delete dest files not in source")
-	rootCmd.Flags().StringSliceVar(&excludes, "exclude", nil, "Exclude patterns (multiple allowed)")
-	rootCmd.Flags().StringSliceVar(&includes, "include", nil, "Include patterns (multiple allowed)")
-	rootCmd.Flags().BoolVar(&quiet, "quiet", false, "Suppress non-error output")
-	rootCmd.Flags().IntVar(&concurrency, "concurrency", 32, "Number of concurrent operations")
-	rootCmd.Flags().StringVar(&profile, "profile", "", "AWS profile to use")
-	rootCmd.Flags().StringVar(&region, "region", "", "AWS region (uses default if not specified)")
+	addPlanFlags(rootCmd)
+	addExecuteFlags(rootCmd)
+	addCommonFlags(rootCmd)
 	rootCmd.Flags().StringVar(&planJSONFile, "plan-json-file", "", "Path to output plan as JSON file")
-	rootCmd.Flags().StringVar(&resultJSONFile, "result-json-file", "", "Path to output result as JSON file")
+	rootCmd.Flags().StringVar(&resumeFile, "resume", "", "Skip operations completed in the given journal file and keep appending to it")
+
+	planCmd := &cobra.Command{
+		Use:   "plan <LocalPath> <S3Uri> or <S3Uri> <LocalPath> or <S3Uri> <S3Uri>",
+		Short: "Write the operations a sync would perform to a plan file for apply",
+		Long: `plan compares source and destination like a sync, but only writes the
+resulting operations, including sizes and checksums, to a plan file.
+Nothing is transferred or deleted.`,
+		Args: cobra.ExactArgs(2),
+		RunE: runPlan,
+	}
+	addPlanFlags(planCmd)
+	addCommonFlags(planCmd)
+	planCmd.Flags().StringVarP(&planOutFile, "out", "o", "", "Path to write the plan to")
+	_ = planCmd.MarkFlagRequired("out")
+
+	applyCmd := &cobra.Command{
+		Use:   "apply <PlanFile>",
+		Short: "Execute exactly the operations of a plan file",
+		Long: `apply executes the operations of a plan file written by plan or
+--plan-json-file, without comparing source and destination again.
+It refuses to run if a local file to upload no longer matches its
+planned checksum.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runApply,
+	}
+	addExecuteFlags(applyCmd)
+	addCommonFlags(applyCmd)
+
+	rootCmd.AddCommand(planCmd, applyCmd)
 
 	if err := rootCmd.Execute(); err != nil {
+		if isInterrupted(err) {
+			os.Exit(exitInterrupted)
+		}
 		os.Exit(1)
 	}
 }
 
+// addPlanFlags adds the flags that control how source and destination are compared.
+func addPlanFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.BoolVar(&deleteFlag, "delete", false, "Delete dest files not in source")
+	flags.BoolVar(&sizeOnly, "size-only", false, "Treat files of the same size as unchanged without comparing checksums")
+	flags.BoolVar(&exactTimestamps, "exact-timestamps", false, "Treat files of the same size and modification time as unchanged, and compare checksums of the others")
+	flags.Var(&filterFlag{filters: &filters, filterType: planner.FilterExclude}, "exclude", "Exclude patterns (multiple allowed)")
+	flags.Var(&filterFlag{filters: &filters, filterType: planner.FilterInclude}, "include", "Include patterns (multiple allowed)")
+	flags.StringVar(&onMissingChecksum, "on-missing-checksum", missingChecksumError, "What to do when --endpoint-url does not return checksums in --checksum-algorithm: error or size-only")
+	flags.StringVar(&checksumCache, "checksum-cache", "", "Cache local file checksums in the given file between runs")
+	flags.StringVar(&checksumAlgorithm, "checksum-algorithm", s3client.ChecksumAlgorithmCRC64NVME, "Checksum algorithm to upload with and compare by: CRC64NVME, CRC32C, CRC32, SHA1 or SHA256")
+	flags.BoolVar(&backfillChecksums, "backfill-checksums", false, "Let S3 calculate missing checksums of same-size objects by copying them onto themselves, and only upload those that differ")
+	flags.BoolVar(&sourceMetadata, "source-metadata", false, sourceMetadataUsage)
+	flags.IntVar(&hashConcurrency, "hash-concurrency", 0, "Number of local files hashed at a time while comparing (default: number of CPUs)")
+	flags.IntVar(&headConcurrency, "head-concurrency", planner.DefaultHeadConcurrency, "Number of HeadObject requests sent at a time while comparing")
+	flags.BoolVar(&keepGoing, "keep-going", false, "Plan the files that can be compared when others can't, and report all that can't")
+}
+
+const sourceMetadataUsage = "Store the CRC64NVME checksum, size and mtime of uploaded files in object metadata, and trust them when comparing"
+
+// addExecuteFlags adds the flags that control how a plan is executed.
+func addExecuteFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.IntVar(&concurrency, "concurrency", 32, "Number of concurrent operations")
+	flags.IntVar(&maxAttempts, "max-attempts", executor.DefaultRetryPolicy().MaxAttempts, "Maximum attempts per operation on throttling or transient errors (1 disables retries)")
+	flags.StringVar(&resultJSONFile, "result-json-file", "", "Path to output result as JSON file")
+	flags.StringVar(&journalFile, "journal", "", "Append completed operations to a journal file for --resume")
+	flags.DurationVar(&gracePeriod, "grace-period", 30*time.Second, "How long running operations may take to finish after SIGINT or SIGTERM before they are aborted")
+}
+
+// addCommonFlags adds the flags shared by all commands.
+func addCommonFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.BoolVar(&quiet, "quiet", false, "Suppress non-error output")
+	flags.StringVar(&profile, "profile", "", "AWS profile to use")
+	flags.StringVar(&region, "region", "", "AWS region (uses default if not specified)")
+	flags.StringVar(&endpointURL, "endpoint-url", "", "Override the S3 endpoint URL (e.g. for MinIO or Ceph RGW)")
+	flags.BoolVar(&forcePathStyle, "force-path-style", false, "Use path-style addressing (endpoint/bucket/key)")
+}
+
 func run(cmd *cobra.Command, args []string) error {
-	localPath := args[0]
-	s3URI := args[1]
+	sourcePath := args[0]
+	destPath := args[1]
+
+	sourceType, destType, err := parseDirection(sourcePath, destPath)
+	if err != nil {
+		return err
+	}
+	if err := validatePlanFlags(sourceType, destType); err != nil {
+		return err
+	}
+	if journalFile != "" && resumeFile != "" {
+		return fmt.Errorf("--journal and --resume can't be used together, --resume also appends to its journal")
+	}
+
+	// Planning stops on an interrupt, running operations finish
+	ctx, schedule, stop := handleSignals(gracePeriod)
+	defer stop()
+
+	s3Client, err := newS3Client(schedule)
+	if err != nil {
+		return err
+	}
+
+	// Create unified logger
+	syncLogger := &logger.SyncLogger{
+		IsDryRun: dryRun,
+		IsQuiet:  quiet,
+	}
+
+	// A plan written out can be applied later, which needs the checksums
+	opts := planner.Options{
+		DeleteEnabled:     deleteFlag,
+		Filters:           filters,
+		Logger:            syncLogger,
+		PlanChecksums:     planJSONFile != "",
+		ChecksumAlgorithm: checksumAlgorithm,
+		BackfillChecksums: backfillChecksums,
+		HashConcurrency:   hashConcurrency,
+		HeadConcurrency:   headConcurrency,
+		KeepGoing:         keepGoing,
+	}
+	opts.Comparator = newComparator(destType, sizeOnly)
+
+	// A dry run has nothing to journal, but may still preview a resume
+	var jrnl *journal.Journal
+	switch {
+	case dryRun && resumeFile != "":
+		resumed, err := journal.Load(resumeFile)
+		if err != nil {
+			return err
+		}
+		opts.Journal = resumed
+	case resumeFile != "" || (journalFile != "" && !dryRun):
+		path := resumeFile
+		if path == "" {
+			path = journalFile
+		}
+		jrnl, err = journal.Open(path)
+		if err != nil {
+			return err
+		}
+		defer jrnl.Close()
+
+		if resumeFile != "" {
+			opts.Journal = jrnl
+		}
+	}
+
+	// Without a plan to write out or preview, items are executed as soon as
+	// they are planned
+	if planJSONFile == "" && !dryRun {
+		exec := newExecutor(s3Client, syncLogger, schedule.Done())
+		if jrnl != nil {
+			exec.Journal = jrnl
+		}
+		return withPlanner(schedule, s3Client, syncLogger, sourcePath, sourceType, destPath, destType, opts, false,
+			func(plnr planner.StreamPlanner, source planner.Source, dest planner.Destination, opts planner.Options) error {
+				return executeStream(ctx, exec, newResultCollector(sourcePath, destPath, exec.Interrupt), func(items chan<- planner.Item) error {
+					return plnr.PlanStream(schedule, source, dest, opts, items)
+				})
+			})
+	}
+
+	// With --keep-going, the files that could be compared are still synced
+	items, itemErrs, err := makePlan(schedule, s3Client, syncLogger, sourcePath, sourceType, destPath, destType, opts, dryRun)
+	if err != nil {
+		if schedule.Err() != nil && !dryRun {
+			// Nothing was executed, which the result JSON still reports
+			return newResultCollector(sourcePath, destPath, schedule.Done()).finish(nil)
+		}
+		return err
+	}
+
+	// Output plan if requested
+	if planJSONFile != "" {
+		plan := planfile.New(sourcePath, destPath, items)
+		plan.AddErrors(itemErrs)
+		if err := planfile.Write(planJSONFile, plan); err != nil {
+			return fmt.Errorf("failed to write plan JSON: %w", err)
+		}
+	}
+
+	if dryRun {
+		// In dry-run mode, just log the operations
+		logItems(syncLogger, items)
+		return planError(itemErrs)
+	}
+
+	exec := newExecutor(s3Client, syncLogger, schedule.Done())
+	if jrnl != nil {
+		exec.Journal = jrnl
+	}
+	return execute(ctx, exec, items, newResultCollector(sourcePath, destPath, exec.Interrupt), planError(itemErrs))
+}
+
+func runPlan(cmd *cobra.Command, args []string) error {
+	sourcePath := args[0]
+	destPath := args[1]
+
+	sourceType, destType, err := parseDirection(sourcePath, destPath)
+	if err != nil {
+		return err
+	}
+	if err := validatePlanFlags(sourceType, destType); err != nil {
+		return err
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	s3Client, err := newS3Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	syncLogger := &logger.SyncLogger{
+		IsDryRun: true,
+		IsQuiet:  quiet,
+	}
+
+	opts := planner.Options{
+		DeleteEnabled:     deleteFlag,
+		Filters:           filters,
+		Logger:            syncLogger,
+		PlanChecksums:     true,
+		ChecksumAlgorithm: checksumAlgorithm,
+		BackfillChecksums: backfillChecksums,
+		HashConcurrency:   hashConcurrency,
+		HeadConcurrency:   headConcurrency,
+		KeepGoing:         keepGoing,
+	}
+	opts.Comparator = newComparator(destType, sizeOnly)
+
+	// Like a dry run, planning writes nothing
+	items, itemErrs, err := makePlan(ctx, s3Client, syncLogger, sourcePath, sourceType, destPath, destType, opts, true)
+	if err != nil {
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+		return err
+	}
+
+	plan := planfile.New(sourcePath, destPath, items)
+	plan.AddErrors(itemErrs)
+	if err := planfile.Write(planOutFile, plan); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	logItems(syncLogger, items)
+
+	return planError(itemErrs)
+}
+
+func runApply(cmd *cobra.Command, args []string) error {
+	plan, err := planfile.Read(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read plan %s: %w", args[0], err)
+	}
+	items, err := plan.Items()
+	if err != nil {
+		return fmt.Errorf("invalid plan %s: %w", args[0], err)
+	}
+
+	ctx, schedule, stop := handleSignals(gracePeriod)
+	defer stop()
+
+	s3Client, err := newS3Client(schedule)
+	if err != nil {
+		return err
+	}
+
+	syncLogger := &logger.SyncLogger{
+		IsQuiet: quiet,
+	}
+
+	exec := newExecutor(s3Client, syncLogger, schedule.Done())
+
+	// Only upload exactly the content that was reviewed
+	if err := exec.Verify(schedule, items); err != nil {
+		if schedule.Err() != nil {
+			// None of the items was started
+			collector := newResultCollector(plan.Source, plan.Destination, schedule.Done())
+			collector.addNotStarted(items)
+			return collector.finish(nil)
+		}
+		return fmt.Errorf("refusing to apply %s: %w", args[0], err)
+	}
+
+	if journalFile != "" {
+		jrnl, err := journal.Open(journalFile)
+		if err != nil {
+			return err
+		}
+		defer jrnl.Close()
+		exec.Journal = jrnl
+	}
+
+	return execute(ctx, exec, items, newResultCollector(plan.Source, plan.Destination, exec.Interrupt), nil)
+}
+
+// parseDirection returns the types of the source and destination arguments.
+func parseDirection(sourcePath, destPath string) (planner.SourceType, planner.DestType, error) {
+	switch {
+	case !isS3URI(sourcePath) && isS3URI(destPath):
+		return planner.SourceTypeFileSystem, planner.DestTypeS3, nil
+	case isS3URI(sourcePath) && !isS3URI(destPath):
+		return planner.SourceTypeS3, planner.DestTypeFileSystem, nil
+	case isS3URI(sourcePath) && isS3URI(destPath):
+		return planner.SourceTypeS3, planner.DestTypeS3, nil
+	default:
+		return "", "", fmt.Errorf("at least one argument must be an S3 URI (s3://bucket/prefix)")
+	}
+}
+
+func validatePlanFlags(sourceType planner.SourceType, destType planner.DestType) error {
+	if onMissingChecksum != missingChecksumError && onMissingChecksum != missingChecksumSizeOnly {
+		return fmt.Errorf("invalid --on-missing-checksum %q: must be %s or %s", onMissingChecksum, missingChecksumError, missingChecksumSizeOnly)
+	}
 
-	if !strings.HasPrefix(s3URI, "s3://") {
-		return fmt.Errorf("second argument must be an S3 URI (s3://bucket/prefix)")
+	algorithm, err := s3client.ParseChecksumAlgorithm(checksumAlgorithm)
+	if err != nil {
+		return fmt.Errorf("invalid --checksum-algorithm: %w", err)
 	}
+	checksumAlgorithm = algorithm
 
-	ctx := context.Background()
+	if sizeOnly && exactTimestamps {
+		return fmt.Errorf("--size-only and --exact-timestamps can't be used together")
+	}
 
+	if backfillChecksums && (sourceType != planner.SourceTypeFileSystem || destType != planner.DestTypeS3) {
+		return fmt.Errorf("--backfill-checksums only applies to syncs from a local directory to S3")
+	}
+	if sourceMetadata && (sourceType != planner.SourceTypeFileSystem || destType != planner.DestTypeS3) {
+		return fmt.Errorf("--source-metadata only applies to syncs from a local directory to S3")
+	}
+
+	return nil
+}
+
+func newS3Client(ctx context.Context) (*s3client.AWSClient, error) {
 	// Build config options
 	var configOpts []func(*config.LoadOptions) error
 	if profile != "" {
@@ -133,208 +493,389 @@ func run(cmd *cobra.Command, args []string) error {
 
 	cfg, err := config.LoadDefaultConfig(ctx, configOpts...)
 	if err != nil {
-		return fmt.Errorf("failed to load AWS config: %w", err)
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	s3Client := s3client.NewAWSClient(cfg)
+	return s3client.NewAWSClient(cfg, s3client.Options{
+		EndpointURL:    endpointURL,
+		ForcePathStyle: forcePathStyle,
+	}), nil
+}
 
-	// Create unified logger
-	syncLogger := &logger.SyncLogger{
-		IsDryRun: dryRun,
-		IsQuiet:  quiet,
+// makePlan plans the sync from sourcePath to destPath. With --keep-going,
+// the files that could not be compared are returned along with the plan of
+// the others. readOnly is passed on to withPlanner.
+func makePlan(ctx context.Context, s3Client *s3client.AWSClient, syncLogger logger.Logger, sourcePath string, sourceType planner.SourceType, destPath string, destType planner.DestType, opts planner.Options, readOnly bool) ([]planner.Item, planner.ItemErrors, error) {
+	var items []planner.Item
+	err := withPlanner(ctx, s3Client, syncLogger, sourcePath, sourceType, destPath, destType, opts, readOnly,
+		func(plnr planner.StreamPlanner, source planner.Source, dest planner.Destination, opts planner.Options) error {
+			var err error
+			items, err = plnr.Plan(ctx, source, dest, opts)
+			if err != nil {
+				return fmt.Errorf("failed to generate plan: %w", err)
+			}
+			return nil
+		})
+
+	var itemErrs planner.ItemErrors
+	if errors.As(err, &itemErrs) {
+		return items, itemErrs, nil
 	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, nil, nil
+}
 
-	plnr := planner.NewFSToS3Planner(s3Client, syncLogger)
+// newComparator returns the Comparator selected by the flags, comparing by
+// size only with sizeOnly. Objects can't keep the modification time of
+// their source, so with --exact-timestamps they match sources not modified
+// after they were written, while downloaded files match objects modified
+// in the same second.
+func newComparator(destType planner.DestType, sizeOnly bool) planner.Comparator {
+	var c planner.Comparator = planner.StrictComparator{}
+	if sourceMetadata {
+		c = planner.SourceMetadataComparator{}
+	}
+	if sizeOnly {
+		c = planner.SizeOnlyComparator{}
+	}
+	if exactTimestamps {
+		match := planner.NotOlder
+		if destType == planner.DestTypeFileSystem {
+			match = planner.SameTimestamp
+		}
+		c = planner.TimestampComparator{Match: match, Comparator: c}
+	}
+	return c
+}
 
-	source := planner.Source{
-		Type: planner.SourceTypeFileSystem,
-		Path: localPath,
+// planError reports the files that could not be compared, if any.
+func planError(itemErrs planner.ItemErrors) error {
+	if len(itemErrs) == 0 {
+		return nil
 	}
+	return fmt.Errorf("failed to generate plan: %w", itemErrs)
+}
 
-	dest := planner.Destination{
-		Type: planner.DestTypeS3,
-		Path: s3URI,
+// withPlanner sets up the planner for the sync from sourcePath to destPath
+// and calls plan with it. With readOnly, e.g. for dry runs and plan, nothing
+// is written while setting up.
+func withPlanner(ctx context.Context, s3Client *s3client.AWSClient, syncLogger logger.Logger, sourcePath string, sourceType planner.SourceType, destPath string, destType planner.DestType, opts planner.Options, readOnly bool,
+	plan func(plnr planner.StreamPlanner, source planner.Source, dest planner.Destination, opts planner.Options) error) (err error) {
+	var plnr planner.StreamPlanner
+	switch {
+	case sourceType == planner.SourceTypeS3 && destType == planner.DestTypeS3:
+		plnr = planner.NewS3ToS3Planner(s3Client, syncLogger)
+	case sourceType == planner.SourceTypeS3:
+		plnr = planner.NewS3ToFSPlanner(s3Client, syncLogger)
+	default:
+		plnr = planner.NewFSToS3Planner(s3Client, syncLogger)
 	}
 
-	opts := planner.Options{
-		DeleteEnabled: deleteFlag,
-		Excludes:      excludes,
-		Logger:        syncLogger,
+	source := planner.Source{
+		Type: sourceType,
+		Path: sourcePath,
 	}
 
-	items, err := plnr.Plan(ctx, source, dest, opts)
-	if err != nil {
-		return fmt.Errorf("failed to generate plan: %w", err)
+	dest := planner.Destination{
+		Type: destType,
+		Path: destPath,
 	}
 
-	// Output plan if requested
-	if planJSONFile != "" {
-		if err := writePlanResult(planJSONFile, items); err != nil {
-			return fmt.Errorf("failed to write plan JSON: %w", err)
+	// AWS S3 always returns checksums, S3-compatible stores may not.
+	// Comparing by size only doesn't need them. Downloads are verified
+	// against the checksums of the source.
+	if endpointURL != "" && !sizeOnly {
+		probeURI := destPath
+		if destType != planner.DestTypeS3 {
+			probeURI = sourcePath
+		}
+		unsupported, err := probeChecksumSupport(ctx, s3Client, probeURI, readOnly || destType != planner.DestTypeS3)
+		if err != nil {
+			return err
+		}
+		if unsupported {
+			opts.Comparator = newComparator(destType, true)
 		}
 	}
 
-	if len(items) == 0 {
-		if resultJSONFile != "" && !dryRun {
-			// Write empty result for actual execution
-			result := SyncResult{
-				Files:   []ResultFile{},
-				Errors:  []ErrorFile{},
-				Summary: ResultSummary{},
+	if checksumCache != "" {
+		cache, err := checksumcache.Open(checksumCache)
+		if err != nil {
+			return err
+		}
+		opts.ChecksumCache = cache
+
+		// Checksums calculated before a failure are still worth keeping.
+		// Files that were not looked at are only known to be gone once
+		// every file has been compared.
+		defer func() {
+			var itemErrs planner.ItemErrors
+			if err == nil || errors.As(err, &itemErrs) {
+				cache.Prune()
 			}
-			if err := writeSyncResult(resultJSONFile, result); err != nil {
-				return fmt.Errorf("failed to write result JSON: %w", err)
+			if err := cache.Save(); err != nil {
+				log.Printf("Warning: %v", err)
 			}
-		}
-		return nil
+		}()
 	}
 
-	if dryRun {
-		// In dry-run mode, just log the operations
-		for _, item := range items {
-			switch item.Action {
-			case planner.ActionUpload:
-				syncLogger.Upload(item.LocalPath, fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key))
-			case planner.ActionDelete:
-				syncLogger.Delete(fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key))
-			}
+	return plan(plnr, source, dest, opts)
+}
+
+// logItems logs the operations of items without executing them.
+func logItems(syncLogger logger.Logger, items []planner.Item) {
+	for _, item := range items {
+		switch item.Action {
+		case planner.ActionUpload:
+			syncLogger.Upload(item.LocalPath, fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key))
+		case planner.ActionDownload:
+			syncLogger.Download(fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key), item.LocalPath)
+		case planner.ActionCopy:
+			syncLogger.Copy(fmt.Sprintf("s3://%s/%s", item.SourceBucket, item.SourceKey), fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key))
+		case planner.ActionBackfill:
+			s3Path := fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key)
+			syncLogger.Copy(s3Path, s3Path)
+		case planner.ActionDelete:
+			syncLogger.Delete(fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key))
+		case planner.ActionDeleteLocal:
+			syncLogger.Delete(item.LocalPath)
 		}
-		return nil
 	}
+}
 
-	// Execute the plan
+// newExecutor returns an executor that stops starting items once interrupt
+// is closed.
+func newExecutor(s3Client *s3client.AWSClient, syncLogger logger.Logger, interrupt <-chan struct{}) *executor.Executor {
 	exec := executor.NewExecutor(s3Client, syncLogger, concurrency)
-	results := exec.Execute(ctx, items)
+	exec.Interrupt = interrupt
+	exec.RetryPolicy.MaxAttempts = maxAttempts
+	return exec
+}
 
-	// Process results
-	syncResult := SyncResult{
-		Files:  []ResultFile{},
-		Errors: []ErrorFile{},
+// execute runs items and writes the result JSON collected by collector if
+// requested. planErr is the error planning items returned, if any.
+func execute(ctx context.Context, exec *executor.Executor, items []planner.Item, collector *resultCollector, planErr error) error {
+	for _, result := range exec.Execute(ctx, items) {
+		collector.add(result)
 	}
-	var failed int
+	return collector.finish(planErr)
+}
 
-	for _, result := range results {
-		if result.Error != nil {
-			failed++
-			log.Printf("Error: %s/%s: %v", result.Item.Bucket, result.Item.Key, result.Error)
+// executeStream runs the items plan sends as soon as they are planned and
+// writes the result JSON if requested. plan must close items when done.
+// Items planned before a planning error are still executed and reported,
+// and so are all the others with --keep-going.
+func executeStream(ctx context.Context, exec *executor.Executor, collector *resultCollector, plan func(items chan<- planner.Item) error) error {
+	items := make(chan planner.Item)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- plan(items)
+	}()
+
+	exec.ExecuteStream(ctx, items, collector.add)
+
+	planErr := <-errCh
+	switch {
+	case errors.Is(planErr, context.Canceled) && collector.interrupted():
+		// Planning stopped on the interrupt, which finish reports
+		planErr = nil
+	case planErr != nil:
+		planErr = fmt.Errorf("failed to generate plan: %w", planErr)
+	}
+	return collector.finish(planErr)
+}
 
-			// Add to errors array
-			action := getActionName(result.Item.Action)
-			if result.Item.Action == planner.ActionUpload {
-				action = getUploadActionName(result.Item.Reason)
-			}
+// resultCollector builds the result of the sync from source to destination
+// from the results of its items in the order they complete.
+type resultCollector struct {
+	source      string
+	destination string
+	sourceType  planner.SourceType
+	// interrupt is closed when the sync is interrupted
+	interrupt <-chan struct{}
+	result    SyncResult
+}
 
-			errorFile := ErrorFile{
-				Action: action,
-				Target: formatS3Path(result.Item.Bucket, result.Item.Key),
-				Error:  result.Error.Error(),
-			}
-			if result.Item.Action == planner.ActionUpload {
-				errorFile.Source = getAbsolutePath(result.Item.LocalPath)
-			}
-			syncResult.Errors = append(syncResult.Errors, errorFile)
-			syncResult.Summary.Failed++
-		} else {
-			// Successful operations
-			switch result.Item.Action {
-			case planner.ActionUpload:
-				action := getUploadActionName(result.Item.Reason)
-				var actionPast string
-				if action == "create" {
-					actionPast = "created"
-					syncResult.Summary.Created++
-				} else {
-					actionPast = "updated"
-					syncResult.Summary.Updated++
-				}
-				file := ResultFile{
-					Result: actionPast,
-					Source: getAbsolutePath(result.Item.LocalPath),
-					Target: formatS3Path(result.Item.Bucket, result.Item.Key),
-				}
-				syncResult.Files = append(syncResult.Files, file)
-			case planner.ActionDelete:
-				file := ResultFile{
-					Result: "deleted",
-					Target: formatS3Path(result.Item.Bucket, result.Item.Key),
-				}
-				syncResult.Files = append(syncResult.Files, file)
-				syncResult.Summary.Deleted++
-			case planner.ActionSkip:
-				file := ResultFile{
-					Result: "skipped",
-					Source: getAbsolutePath(result.Item.LocalPath),
-					Target: formatS3Path(result.Item.Bucket, result.Item.Key),
-				}
-				syncResult.Files = append(syncResult.Files, file)
-				syncResult.Summary.Skipped++
-			}
+func newResultCollector(source, destination string, interrupt <-chan struct{}) *resultCollector {
+	sourceType := planner.SourceTypeFileSystem
+	if isS3URI(source) {
+		sourceType = planner.SourceTypeS3
+	}
+	return &resultCollector{
+		source:      source,
+		destination: destination,
+		sourceType:  sourceType,
+		interrupt:   interrupt,
+		result: SyncResult{
+			Files:      []ResultFile{},
+			Errors:     []ErrorFile{},
+			NotStarted: []PendingFile{},
+		},
+	}
+}
+
+// add records the result of an item.
+func (c *resultCollector) add(result executor.Result) {
+	source, target := planfile.Describe(result.Item, c.sourceType)
+	action := planfile.ActionName(result.Item)
+
+	if errors.Is(result.Error, executor.ErrNotStarted) {
+		c.result.NotStarted = append(c.result.NotStarted, PendingFile{
+			Action: action,
+			Source: source,
+			Target: target,
+		})
+		c.result.Summary.NotStarted++
+		return
+	}
+
+	if result.Error != nil {
+		log.Printf("Error: %s: %v", target, result.Error)
+
+		// Add to errors array
+		c.result.Errors = append(c.result.Errors, ErrorFile{
+			Action:   action,
+			Source:   source,
+			Target:   target,
+			Error:    result.Error.Error(),
+			Code:     errorCode(result.Error),
+			Attempts: result.Attempts,
+		})
+		c.result.Summary.Failed++
+		return
+	}
+
+	// Successful operations
+	// A backfilled object that differed was uploaded again
+	if result.Reuploaded {
+		action = "update"
+	}
+	var actionPast string
+	switch action {
+	case "create":
+		actionPast = "created"
+		c.result.Summary.Created++
+	case "update":
+		actionPast = "updated"
+		c.result.Summary.Updated++
+	case "delete":
+		actionPast = "deleted"
+		c.result.Summary.Deleted++
+	case "skip":
+		actionPast = "skipped"
+		c.result.Summary.Skipped++
+	case "backfill":
+		actionPast = "backfilled"
+		c.result.Summary.Backfilled++
+	default:
+		return
+	}
+	c.result.Files = append(c.result.Files, ResultFile{
+		Result:   actionPast,
+		Source:   source,
+		Target:   target,
+		Attempts: result.Attempts,
+	})
+}
+
+// addNotStarted records items as never started.
+func (c *resultCollector) addNotStarted(items []planner.Item) {
+	for _, item := range items {
+		c.add(executor.Result{Item: item, Error: executor.ErrNotStarted})
+	}
+}
+
+// interrupted reports whether the sync was interrupted.
+func (c *resultCollector) interrupted() bool {
+	select {
+	case <-c.interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// finish writes the result JSON if requested and reports planErr, the error
+// planning the items returned, along with failed items and interrupts. The
+// files that could not be compared with --keep-going are listed with the
+// errors as "compare".
+func (c *resultCollector) finish(planErr error) error {
+	var itemErrs planner.ItemErrors
+	if errors.As(planErr, &itemErrs) {
+		for _, e := range planfile.Errors(c.source, c.destination, itemErrs) {
+			c.result.Errors = append(c.result.Errors, ErrorFile{
+				Action: "compare",
+				Source: e.Source,
+				Target: e.Target,
+				Error:  e.Error,
+			})
 		}
 	}
 
+	switch {
+	case c.interrupted():
+		c.result.Status = statusInterrupted
+	case c.result.Summary.Failed > 0 || planErr != nil:
+		c.result.Status = statusFailed
+	default:
+		c.result.Status = statusCompleted
+	}
+
 	if resultJSONFile != "" {
-		if err := writeSyncResult(resultJSONFile, syncResult); err != nil {
+		if err := writeSyncResult(resultJSONFile, c.result); err != nil {
 			return fmt.Errorf("failed to write result JSON: %w", err)
 		}
 	}
 
-	if failed > 0 {
-		return fmt.Errorf("%d operations failed", failed)
+	if c.interrupted() {
+		return errors.Join(planErr, fmt.Errorf("%w: %d operations failed, %d not started", errInterrupted, c.result.Summary.Failed, c.result.Summary.NotStarted))
+	}
+	if c.result.Summary.Failed > 0 {
+		return errors.Join(planErr, fmt.Errorf("%d operations failed", c.result.Summary.Failed))
 	}
 
-	return nil
+	return planErr
 }
 
-func writePlanResult(path string, items []planner.Item) error {
-	var plan PlanResult
+// filterFlag appends --exclude and --include patterns to a shared list
+// so that the order of the flags on the command line is preserved.
+// Like the string slice flags they replaced, a value may hold several
+// comma-separated patterns.
+type filterFlag struct {
+	filters    *[]planner.Filter
+	filterType planner.FilterType
+}
 
-	for _, item := range items {
-		var file PlanFile
-		switch item.Action {
-		case planner.ActionUpload:
-			action := getUploadActionName(item.Reason)
-			file = PlanFile{
-				Action: action,
-				Source: getAbsolutePath(item.LocalPath),
-				Target: formatS3Path(item.Bucket, item.Key),
-				Reason: item.Reason,
-			}
-			if action == "create" {
-				plan.Summary.Create++
-			} else {
-				plan.Summary.Update++
-			}
-		case planner.ActionDelete:
-			file = PlanFile{
-				Action: "delete",
-				Target: formatS3Path(item.Bucket, item.Key),
-				Reason: item.Reason,
-			}
-			plan.Summary.Delete++
-		case planner.ActionSkip:
-			file = PlanFile{
-				Action: "skip",
-				Source: getAbsolutePath(item.LocalPath),
-				Target: formatS3Path(item.Bucket, item.Key),
-				Reason: item.Reason,
-			}
-			plan.Summary.Skip++
+func (f *filterFlag) String() string {
+	var patterns []string
+	for _, filter := range *f.filters {
+		if filter.Type == f.filterType {
+			patterns = append(patterns, filter.Pattern)
 		}
-		plan.Files = append(plan.Files, file)
 	}
+	return strings.Join(patterns, ",")
+}
 
-	data, err := json.MarshalIndent(plan, "", "  ")
+func (f *filterFlag) Set(value string) error {
+	if value == "" {
+		return nil
+	}
+	patterns, err := csv.NewReader(strings.NewReader(value)).Read()
 	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %w", err)
+		return err
 	}
-
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	for _, pattern := range patterns {
+		*f.filters = append(*f.filters, planner.Filter{Type: f.filterType, Pattern: pattern})
 	}
-
 	return nil
 }
 
+func (f *filterFlag) Type() string {
+	return "stringSlice"
+}
+
 func writeSyncResult(path string, result SyncResult) error {
 	data, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
@@ -348,34 +889,38 @@ func writeSyncResult(path string, result SyncResult) error {
 	return nil
 }
 
-func getActionName(action planner.Action) string {
-	switch action {
-	case planner.ActionUpload:
-		return "create" // Use getUploadActionName for accurate create/update distinction
-	case planner.ActionDelete:
-		return "delete"
-	case planner.ActionSkip:
-		return "skip"
-	default:
-		return "unknown"
-	}
-}
-
-func getUploadActionName(reason string) string {
-	if reason == "new file" {
-		return "create"
+// probeChecksumSupport checks whether the endpoint of s3URI stores checksums
+// in --checksum-algorithm and reports whether to fall back to size-only
+// comparison. With readOnly, nothing is written to s3URI: the checksum of
+// an object already stored there is looked at instead, and if that can't
+// tell, only a warning is logged.
+func probeChecksumSupport(ctx context.Context, client *s3client.AWSClient, s3URI string, readOnly bool) (bool, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(s3URI, "s3://"), "/")
+
+	var err error
+	if readOnly {
+		err = client.ProbeListedChecksum(ctx, bucket, prefix, checksumAlgorithm)
+	} else {
+		key := path.Join(strings.TrimSuffix(prefix, "/"), fmt.Sprintf(".strict-s3-sync-probe-%d", time.Now().UnixNano()))
+		err = client.ProbeChecksum(ctx, bucket, key, checksumAlgorithm)
 	}
-	return "update"
-}
-
-func getAbsolutePath(path string) string {
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		return path // fallback to original path
+	switch {
+	case err == nil:
+		return false, nil
+	case errors.Is(err, s3client.ErrProbeInconclusive):
+		log.Printf("Warning: can't check %s support of %s without writing to it: %v", checksumAlgorithm, endpointURL, err)
+		return false, nil
+	case errors.Is(err, s3client.ErrChecksumUnsupported) && onMissingChecksum == missingChecksumSizeOnly:
+		log.Printf("Warning: %s does not return %s checksums, comparing by size only", endpointURL, checksumAlgorithm)
+		return true, nil
+	case errors.Is(err, s3client.ErrChecksumUnsupported):
+		return false, fmt.Errorf("%s does not return %s checksums, so files can't be compared strictly "+
+			"(use --on-missing-checksum=%s to compare by size only)", endpointURL, checksumAlgorithm, missingChecksumSizeOnly)
+	default:
+		return false, fmt.Errorf("failed to probe %s support of %s: %w", checksumAlgorithm, endpointURL, err)
 	}
-	return absPath
 }
 
-func formatS3Path(bucket, key string) string {
-	return fmt.Sprintf("s3://%s/%s", bucket, key)
+func isS3URI(path string) bool {
+	return strings.HasPrefix(path, "s3://")
 }
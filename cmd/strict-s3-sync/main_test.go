@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
+)
+
+func TestFilterFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []planner.Filter
+	}{
+		{
+			name: "order of the flags is preserved",
+			args: []string{"--exclude", "*", "--include", "*.html", "--exclude", "tmp/*"},
+			want: []planner.Filter{
+				{Type: planner.FilterExclude, Pattern: "*"},
+				{Type: planner.FilterInclude, Pattern: "*.html"},
+				{Type: planner.FilterExclude, Pattern: "tmp/*"},
+			},
+		},
+		{
+			name: "comma-separated patterns",
+			args: []string{"--exclude", "*.tmp,*.log", "--include", "keep.log"},
+			want: []planner.Filter{
+				{Type: planner.FilterExclude, Pattern: "*.tmp"},
+				{Type: planner.FilterExclude, Pattern: "*.log"},
+				{Type: planner.FilterInclude, Pattern: "keep.log"},
+			},
+		},
+		{
+			name: "quoted pattern with a comma",
+			args: []string{"--exclude", `"a,b",c`},
+			want: []planner.Filter{
+				{Type: planner.FilterExclude, Pattern: "a,b"},
+				{Type: planner.FilterExclude, Pattern: "c"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters = nil
+			t.Cleanup(func() { filters = nil })
+
+			cmd := &cobra.Command{}
+			addPlanFlags(cmd)
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatalf("ParseFlags() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(filters, tt.want) {
+				t.Errorf("filters = %v, want %v", filters, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsInterrupted(t *testing.T) {
+	t.Cleanup(func() { interrupted.Store(false) })
+
+	tests := []struct {
+		name        string
+		interrupted bool
+		err         error
+		want        bool
+	}{
+		{name: "interrupted sync", err: fmt.Errorf("%w: 1 operations failed, 2 not started", errInterrupted), want: true},
+		{name: "plan stopped by the interrupt", interrupted: true, err: fmt.Errorf("failed to collect checksums: %w", context.Canceled), want: true},
+		{name: "cancelled without an interrupt", err: fmt.Errorf("failed to collect checksums: %w", context.Canceled)},
+		{name: "other error after an interrupt", interrupted: true, err: errors.New("access denied")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interrupted.Store(tt.interrupted)
+			if got := isInterrupted(tt.err); got != tt.want {
+				t.Errorf("isInterrupted(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultCollectorFinish(t *testing.T) {
+	resultJSONFile = filepath.Join(t.TempDir(), "result.json")
+	defer func() { resultJSONFile = "" }()
+
+	itemErrs := planner.ItemErrors{{Path: "a.txt", Err: errors.New("access denied")}}
+	tests := []struct {
+		name       string
+		planErr    error
+		wantStatus string
+		wantErrors []ErrorFile
+	}{
+		{name: "completed", wantStatus: statusCompleted, wantErrors: []ErrorFile{}},
+		{name: "planning failed", planErr: errors.New("failed to generate plan: AccessDenied"), wantStatus: statusFailed, wantErrors: []ErrorFile{}},
+		{
+			name:       "files not compared",
+			planErr:    fmt.Errorf("failed to generate plan: %w", itemErrs),
+			wantStatus: statusFailed,
+			wantErrors: []ErrorFile{{Action: "compare", Source: "s3://src/p/a.txt", Target: "s3://dst/q/a.txt", Error: "access denied"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newResultCollector("s3://src/p", "s3://dst/q", make(chan struct{})).finish(tt.planErr)
+			if !errors.Is(err, tt.planErr) {
+				t.Errorf("finish() error = %v, want %v", err, tt.planErr)
+			}
+
+			data, err := os.ReadFile(resultJSONFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result SyncResult
+			if err := json.Unmarshal(data, &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", result.Status, tt.wantStatus)
+			}
+			if !reflect.DeepEqual(result.Errors, tt.wantErrors) {
+				t.Errorf("errors = %+v, want %+v", result.Errors, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestResultCollectorNotStarted(t *testing.T) {
+	resultJSONFile = filepath.Join(t.TempDir(), "result.json")
+	defer func() { resultJSONFile = "" }()
+
+	interrupt := make(chan struct{})
+	close(interrupt)
+	collector := newResultCollector("s3://src", "s3://dst", interrupt)
+	collector.addNotStarted([]planner.Item{
+		{Action: planner.ActionCopy, SourceBucket: "src", SourceKey: "a.txt", Bucket: "dst", Key: "a.txt"},
+		{Action: planner.ActionDelete, Bucket: "dst", Key: "b.txt"},
+	})
+	if err := collector.finish(nil); !errors.Is(err, errInterrupted) {
+		t.Errorf("finish() error = %v, want errInterrupted", err)
+	}
+
+	data, err := os.ReadFile(resultJSONFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result SyncResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != statusInterrupted || result.Summary.NotStarted != 2 || len(result.NotStarted) != 2 {
+		t.Errorf("result = %s with %d not started, want interrupted with 2", result.Status, len(result.NotStarted))
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// errInterrupted is returned by commands stopped by SIGINT or SIGTERM.
+var errInterrupted = errors.New("interrupted")
+
+// exitInterrupted is the exit status of an interrupted command, the one of
+// a shell command killed by SIGINT.
+const exitInterrupted = 130
+
+// interrupted is set once a command receives SIGINT or SIGTERM.
+var interrupted atomic.Bool
+
+// isInterrupted reports whether err is the error of an interrupted command:
+// errInterrupted, or the context.Canceled of whatever the interrupt stopped,
+// e.g. a dry run while it was planning.
+func isInterrupted(err error) bool {
+	return errors.Is(err, errInterrupted) || (interrupted.Load() && errors.Is(err, context.Canceled))
+}
+
+// handleSignals returns the contexts a command runs with. schedule is
+// cancelled on the first SIGINT or SIGTERM, so that no new work is started.
+// The work already running goes on with ctx, which is cancelled gracePeriod
+// later. A second signal exits immediately. stop must be called when the
+// command is done.
+func handleSignals(gracePeriod time.Duration) (ctx context.Context, schedule context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	schedule, stopScheduling := context.WithCancel(ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("Received %v, waiting up to %v for running operations to finish (send it again to exit immediately)", sig, gracePeriod)
+			interrupted.Store(true)
+			stopScheduling()
+		case <-done:
+			return
+		}
+
+		grace := time.NewTimer(gracePeriod)
+		defer grace.Stop()
+		for {
+			select {
+			case <-grace.C:
+				log.Printf("Grace period of %v expired, aborting running operations", gracePeriod)
+				cancel()
+			case sig := <-signals:
+				log.Printf("Received %v while waiting, exiting immediately", sig)
+				os.Exit(exitInterrupted)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	stop = func() {
+		signal.Stop(signals)
+		close(done)
+		stopScheduling()
+		cancel()
+	}
+	return ctx, schedule, stop
+}
+
+// interruptContext returns a context cancelled on the first SIGINT or
+// SIGTERM, for commands with nothing to finish gracefully. A second signal
+// kills the process as usual.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+			interrupted.Store(true)
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+		cancel()
+	}()
+
+	return ctx, cancel
+}
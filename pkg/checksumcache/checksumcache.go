@@ -0,0 +1,237 @@
+// Package checksumcache keeps the checksums of local files between runs, so
+// that unchanged files don't have to be read again.
+//
+// A cached checksum is only used while the file keeps the size, mtime and
+// inode it had when the checksum was calculated. Any write to the file, or
+// replacing it with another file, changes at least one of them.
+//
+// The cache also keeps the checksums and user metadata looked up of objects
+// with --source-metadata, which are only used while the object is listed
+// with the same ETag.
+package checksumcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
+)
+
+// version is the version of the cache file format. Files of other versions
+// are ignored.
+const version = 1
+
+// racyWindow is how long after its last modification a file is not cached.
+// A write within the same mtime tick as the one before the file was read
+// would not change the mtime, so the cached checksum could go stale unnoticed.
+const racyWindow = 2 * time.Second
+
+type entry struct {
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"mtime_ns"`
+	Inode    uint64 `json:"inode,omitempty"`
+	Checksum string `json:"checksum"`
+	// Algorithm is the algorithm of Checksum, empty for CRC64NVME.
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+type objectEntry struct {
+	ETag string `json:"etag"`
+	// Checksum is the CRC64NVME checksum of the object, empty if it has none.
+	Checksum string            `json:"checksum,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type cacheFile struct {
+	Version int                    `json:"version"`
+	Entries map[string]entry       `json:"entries"`
+	Objects map[string]objectEntry `json:"objects,omitempty"`
+}
+
+// Cache is a checksum cache backed by a JSON file.
+// It implements planner.ChecksumCache and planner.ObjectCache.
+type Cache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]entry
+	objects map[string]objectEntry
+	// used and usedObjects hold the paths looked up or cached since Open
+	used        map[string]bool
+	usedObjects map[string]bool
+	dirty       bool
+}
+
+var (
+	_ planner.ChecksumCache = (*Cache)(nil)
+	_ planner.ObjectCache   = (*Cache)(nil)
+)
+
+// Open loads the cache at path. A missing or unreadable cache file results
+// in an empty cache; the file is only written by Save.
+func Open(path string) (*Cache, error) {
+	c := &Cache{
+		path:        path,
+		entries:     make(map[string]entry),
+		objects:     make(map[string]objectEntry),
+		used:        make(map[string]bool),
+		usedObjects: make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checksum cache: %w", err)
+	}
+
+	// A corrupted cache only costs reading the files again
+	var file cacheFile
+	if err := json.Unmarshal(data, &file); err == nil && file.Version == version && file.Entries != nil {
+		c.entries = file.Entries
+		if file.Objects != nil {
+			c.objects = file.Objects
+		}
+	}
+
+	return c, nil
+}
+
+// Len returns the number of cached checksums.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Get returns the cached checksum of path in algorithm if info still
+// matches the file the checksum was calculated from.
+func (c *Cache) Get(path string, algorithm string, info os.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.used[path] = true
+	e, ok := c.entries[path]
+	if !ok || e != newEntry(info, algorithm, e.Checksum) {
+		return "", false
+	}
+	return e.Checksum, true
+}
+
+// Put caches the checksum of path in algorithm, which was calculated from
+// the file described by info. Only one checksum is kept per path.
+func (c *Cache) Put(path string, algorithm string, info os.FileInfo, checksum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.used[path] = true
+	if time.Since(info.ModTime()) < racyWindow {
+		// Forget the old checksum, which is stale by now
+		if _, ok := c.entries[path]; ok {
+			delete(c.entries, path)
+			c.dirty = true
+		}
+		return
+	}
+
+	c.entries[path] = newEntry(info, algorithm, checksum)
+	c.dirty = true
+}
+
+// GetObject returns the cached checksum and user metadata of the object at
+// path if it still has etag.
+func (c *Cache) GetObject(path string, etag string) (string, map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.usedObjects[path] = true
+	e, ok := c.objects[path]
+	if !ok || e.ETag != etag {
+		return "", nil, false
+	}
+	return e.Checksum, e.Metadata, true
+}
+
+// PutObject caches the checksum and user metadata of the object at path,
+// which has etag.
+func (c *Cache) PutObject(path string, etag string, checksum string, metadata map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.usedObjects[path] = true
+	c.objects[path] = objectEntry{ETag: etag, Checksum: checksum, Metadata: metadata}
+	c.dirty = true
+}
+
+// Prune drops the checksums of the paths that were neither looked up nor
+// cached since Open, e.g. of files deleted or renamed since, so that the
+// cache doesn't grow with every file that ever existed. It is meant to be
+// called before Save once every file has been compared.
+func (c *Cache) Prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for path := range c.entries {
+		if !c.used[path] {
+			delete(c.entries, path)
+			c.dirty = true
+		}
+	}
+	for path := range c.objects {
+		if !c.usedObjects[path] {
+			delete(c.objects, path)
+			c.dirty = true
+		}
+	}
+}
+
+// Save writes the cache back to its file if it has changed. The file is
+// replaced atomically, so an interrupted save leaves the old cache intact.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(cacheFile{Version: version, Entries: c.entries, Objects: c.objects})
+	if err != nil {
+		return fmt.Errorf("failed to encode checksum cache: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), "."+filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write checksum cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checksum cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write checksum cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to write checksum cache: %w", err)
+	}
+
+	c.dirty = false
+	return nil
+}
+
+func newEntry(info os.FileInfo, algorithm string, checksum string) entry {
+	return entry{
+		Size:      info.Size(),
+		ModTime:   info.ModTime().UnixNano(),
+		Inode:     inode(info),
+		Checksum:  checksum,
+		Algorithm: algorithm,
+	}
+}
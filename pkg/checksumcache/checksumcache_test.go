@@ -0,0 +1,244 @@
+package checksumcache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string, modTime time.Time) os.FileInfo {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	info := writeFile(t, path, "content", modTime)
+
+	c, err := Open(filepath.Join(dir, "cache.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("file.txt", "", info, "checksum")
+
+	if got, ok := c.Get("file.txt", "", info); !ok || got != "checksum" {
+		t.Fatalf("Get() = %q, %v, want checksum, true", got, ok)
+	}
+	if _, ok := c.Get("other.txt", "", info); ok {
+		t.Error("Get() hit for a different path")
+	}
+	if _, ok := c.Get("file.txt", "SHA256", info); ok {
+		t.Error("Get() hit for a different algorithm")
+	}
+
+	tests := []struct {
+		name   string
+		change func() os.FileInfo
+	}{
+		{
+			name: "size",
+			change: func() os.FileInfo {
+				return writeFile(t, path, "content!", modTime)
+			},
+		},
+		{
+			name: "mtime",
+			change: func() os.FileInfo {
+				return writeFile(t, path, "CONTENT", modTime.Add(time.Second))
+			},
+		},
+		{
+			name: "inode",
+			change: func() os.FileInfo {
+				// Replaced by another file with the same size and mtime
+				tmp := filepath.Join(dir, "tmp.txt")
+				writeFile(t, tmp, "CONTENT", modTime)
+				if err := os.Rename(tmp, path); err != nil {
+					t.Fatal(err)
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if inode(info) == 0 {
+					t.Skip("inodes are not available on this platform")
+				}
+				return info
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := writeFile(t, path, "content", modTime)
+			c.Put("file.txt", "", info, "checksum")
+
+			changed := tt.change()
+			if got, ok := c.Get("file.txt", "", changed); ok {
+				t.Errorf("Get() = %q after the %s changed, want a miss", got, tt.name)
+			}
+		})
+	}
+}
+
+func TestCacheSkipsRecentlyModifiedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+
+	c, err := Open(filepath.Join(dir, "cache.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := writeFile(t, path, "content", time.Now().Add(-time.Hour))
+	c.Put("file.txt", "", old, "old")
+
+	recent := writeFile(t, path, "CONTENT", time.Now())
+	c.Put("file.txt", "", recent, "recent")
+	if got, ok := c.Get("file.txt", "", recent); ok {
+		t.Errorf("Get() = %q for a file modified just now, want a miss", got)
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d, want the stale entry removed", c.Len())
+	}
+}
+
+func TestCacheSaveAndOpen(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "cache.json")
+	info := writeFile(t, filepath.Join(dir, "file.txt"), "content", time.Now().Add(-time.Hour))
+
+	c, err := Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing to save yet
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Errorf("Save() of an unchanged cache wrote %s", cachePath)
+	}
+
+	c.Put("file.txt", "", info, "checksum")
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	c, err = Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c.Get("file.txt", "", info); !ok || got != "checksum" {
+		t.Errorf("Get() after reopening = %q, %v, want checksum, true", got, ok)
+	}
+
+	// A corrupted cache is discarded
+	if err := os.WriteFile(cachePath, []byte(`{"version":1,"entries":{"file.txt":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = Open(cachePath)
+	if err != nil {
+		t.Fatalf("Open() of a corrupted cache error = %v", err)
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d for a corrupted cache, want 0", c.Len())
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "cache.json")
+	kept := writeFile(t, filepath.Join(dir, "kept.txt"), "kept", time.Now().Add(-time.Hour))
+	deleted := writeFile(t, filepath.Join(dir, "deleted.txt"), "deleted", time.Now().Add(-time.Hour))
+
+	c, err := Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("kept.txt", "", kept, "kept")
+	c.Put("deleted.txt", "", deleted, "deleted")
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only kept.txt is looked at in the next run
+	c, err = Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("kept.txt", "", kept); !ok {
+		t.Fatal("Get() missed kept.txt")
+	}
+	c.Prune()
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len() = %d after pruning, want 1", c.Len())
+	}
+	if _, ok := c.Get("deleted.txt", "", deleted); ok {
+		t.Error("Get() hit deleted.txt, want it pruned")
+	}
+}
+
+func TestCacheObjects(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "cache.json")
+	metadata := map[string]string{"source-crc64nvme": "SoXXbx67KpE="}
+
+	c, err := Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.PutObject("kept.txt", `"etag"`, "SoXXbx67KpE=", metadata)
+	c.PutObject("deleted.txt", `"etag"`, "", nil)
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum, got, ok := c.GetObject("kept.txt", `"etag"`)
+	if !ok || checksum != "SoXXbx67KpE=" || !reflect.DeepEqual(got, metadata) {
+		t.Errorf("GetObject() after reopening = %q, %v, %v, want the stored object", checksum, got, ok)
+	}
+	// An object written since has another ETag
+	if _, _, ok := c.GetObject("kept.txt", `"other"`); ok {
+		t.Error("GetObject() hit an object with another ETag")
+	}
+	c.Prune()
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := c.GetObject("deleted.txt", `"etag"`); ok {
+		t.Error("GetObject() hit deleted.txt, want it pruned")
+	}
+	if _, _, ok := c.GetObject("kept.txt", `"etag"`); !ok {
+		t.Error("GetObject() missed kept.txt after pruning")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package checksumcache
+
+import "os"
+
+// inode is not available from os.FileInfo on this platform, so cached
+// checksums are only validated by size and mtime.
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
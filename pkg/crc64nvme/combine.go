@@ -0,0 +1,50 @@
+package crc64nvme
+
+// x2n[k] is x^(2^k) modulo the polynomial, enough for the x^(8*n) of any
+// int64 length n.
+var x2n = makeX2N()
+
+func makeX2N() *[67]uint64 {
+	t := new([67]uint64)
+	p := uint64(1) << 62 // x^1
+	for k := range t {
+		t[k] = p
+		p = multModP(p, p)
+	}
+	return t
+}
+
+// multModP returns a*b modulo the polynomial, with the reflected bit order
+// of the checksums, where x^0 is the top bit.
+func multModP(a, b uint64) uint64 {
+	var p uint64
+	for m := uint64(1) << 63; m != 0; m >>= 1 {
+		if a&m != 0 {
+			p ^= b
+		}
+		if b&1 != 0 {
+			b = b>>1 ^ Polynomial
+		} else {
+			b >>= 1
+		}
+	}
+	return p
+}
+
+// Combine returns the checksum of A followed by B, given the checksum crcA
+// of A, and crcB and length lenB of B. Only the length of B is needed, so
+// chunks of a file can be hashed independently and merged in order.
+func Combine(crcA, crcB uint64, lenB int64) uint64 {
+	if lenB <= 0 {
+		return crcA
+	}
+	// Appending lenB zero bytes multiplies crcA by x^(8*lenB); the pre- and
+	// post-inversion of both checksums cancel out.
+	p := uint64(1) << 63 // x^0
+	for k := 3; lenB != 0; k, lenB = k+1, lenB>>1 {
+		if lenB&1 != 0 {
+			p = multModP(x2n[k], p)
+		}
+	}
+	return multModP(p, crcA) ^ crcB
+}
@@ -0,0 +1,220 @@
+package crc64nvme
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc64"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var reference = crc64.MakeTable(Polynomial)
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestChecksum(t *testing.T) {
+	// ChecksumCRC64NVME values returned by S3 for these objects
+	tests := []struct {
+		data string
+		want string
+	}{
+		{data: "", want: "AAAAAAAAAAA="},
+		{data: "Hello, World!\n", want: "SoXXbx67KpE="},
+		{data: "The quick brown fox jumps over the lazy dog\n", want: "2yX60sjqiYo="},
+	}
+
+	for _, tt := range tests {
+		if got := Encode(Checksum([]byte(tt.data))); got != tt.want {
+			t.Errorf("Checksum(%q) = %s, want %s", tt.data, got, tt.want)
+		}
+	}
+
+	// The check value of the CRC-64/NVME catalogue entry
+	if got := Checksum([]byte("123456789")); got != 0xae8b14860a799888 {
+		t.Errorf("Checksum(123456789) = %#x, want 0xae8b14860a799888", got)
+	}
+}
+
+func TestChecksumMatchesHashCRC64(t *testing.T) {
+	data := testData(4096)
+	for n := 0; n <= len(data); n += 1 + n/8 {
+		if got, want := Checksum(data[:n]), crc64.Checksum(data[:n], reference); got != want {
+			t.Fatalf("Checksum() of %d bytes = %#x, want %#x", n, got, want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	data := testData(1000)
+	h := New()
+	// Writes of any size continue the checksum
+	for _, n := range []int{1, 15, 16, 17, 300, 651} {
+		h.Write(data[:n])
+		data = data[n:]
+	}
+
+	want := crc64.New(reference)
+	want.Write(testData(1000))
+	if !bytes.Equal(h.Sum(nil), want.Sum(nil)) || h.Sum64() != want.Sum64() {
+		t.Errorf("Sum() = %x, want %x", h.Sum(nil), want.Sum(nil))
+	}
+
+	h.Reset()
+	if h.Sum64() != 0 {
+		t.Errorf("Sum64() after Reset() = %#x, want 0", h.Sum64())
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	crc, err := Decode("SoXXbx67KpE=")
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if Encode(crc) != "SoXXbx67KpE=" {
+		t.Errorf("Encode(Decode()) = %s, want SoXXbx67KpE=", Encode(crc))
+	}
+
+	for _, invalid := range []string{"not base64!", "AAAA"} {
+		if _, err := Decode(invalid); err == nil {
+			t.Errorf("Decode(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestCombine(t *testing.T) {
+	data := testData(10000)
+	for _, split := range []int{0, 1, 7, 16, 4095, 9999, 10000} {
+		a, b := data[:split], data[split:]
+		got := Combine(Checksum(a), Checksum(b), int64(len(b)))
+		if want := Checksum(data); got != want {
+			t.Errorf("Combine() split at %d = %#x, want %#x", split, got, want)
+		}
+	}
+}
+
+func TestReaderAt(t *testing.T) {
+	data := testData(2*ChunkSize + 12345)
+	want := Checksum(data)
+
+	for _, concurrency := range []int{1, 2, 8} {
+		got, err := ReaderAt(bytes.NewReader(data), int64(len(data)), concurrency)
+		if err != nil {
+			t.Fatalf("ReaderAt() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("ReaderAt() with concurrency %d = %#x, want %#x", concurrency, got, want)
+		}
+	}
+
+	// Shorter than expected, e.g. a file truncated while it is hashed
+	if _, err := ReaderAt(bytes.NewReader(data[:ChunkSize]), int64(len(data)), 8); err == nil {
+		t.Error("ReaderAt() of truncated input succeeded")
+	}
+}
+
+func TestParts(t *testing.T) {
+	data := testData(2500)
+	got, err := Parts(bytes.NewReader(data), int64(len(data)), 1000, 2)
+	if err != nil {
+		t.Fatalf("Parts() error = %v", err)
+	}
+	want := []uint64{Checksum(data[:1000]), Checksum(data[1000:2000]), Checksum(data[2000:])}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parts() = %#x, want %#x", got, want)
+	}
+}
+
+func TestComposite(t *testing.T) {
+	// The checksum of the part checksums' bytes, not of the data
+	parts := []uint64{Checksum([]byte("Hello, ")), Checksum([]byte("World!\n"))}
+	var b []byte
+	for _, crc := range parts {
+		b = binary.BigEndian.AppendUint64(b, crc)
+	}
+	want := Encode(Checksum(b)) + "-2"
+
+	if got := Composite(parts); got != want {
+		t.Errorf("Composite() = %s, want %s", got, want)
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("Hello, World!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := File(path)
+	if err != nil {
+		t.Fatalf("File() error = %v", err)
+	}
+	if got != "SoXXbx67KpE=" {
+		t.Errorf("File() = %s, want SoXXbx67KpE=", got)
+	}
+
+	// Hashed in chunks or as a whole, large files have the same checksum
+	large := filepath.Join(t.TempDir(), "large")
+	if err := os.WriteFile(large, make([]byte, 2*ChunkSize+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parallel, err := FileWithConcurrency(large, 4)
+	if err != nil {
+		t.Fatalf("FileWithConcurrency() error = %v", err)
+	}
+	if serial, err := FileWithConcurrency(large, 1); err != nil || serial != parallel {
+		t.Errorf("FileWithConcurrency(1) = %s, %v, want %s", serial, err, parallel)
+	}
+
+	if _, err := File(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("File() of missing file succeeded")
+	}
+}
+
+func BenchmarkUpdate(b *testing.B) {
+	data := testData(1024 * 1024)
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		Update(0, data)
+	}
+}
+
+func BenchmarkUpdateSmall(b *testing.B) {
+	data := testData(1024)
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		Update(0, data)
+	}
+}
+
+func BenchmarkHashCRC64(b *testing.B) {
+	data := testData(1024 * 1024)
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		crc64.Update(0, reference, data)
+	}
+}
+
+func BenchmarkHashCRC64Small(b *testing.B) {
+	data := testData(1024)
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		crc64.Update(0, reference, data)
+	}
+}
+
+func BenchmarkReaderAt(b *testing.B) {
+	data := testData(4 * ChunkSize)
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		if _, err := ReaderAt(bytes.NewReader(data), int64(len(data)), 4); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package crc64nvme
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// ChunkSize is the size of the chunks ReaderAt hashes in parallel.
+const ChunkSize = 16 * 1024 * 1024
+
+// bufferSize is the size of the reads of a chunk.
+const bufferSize = 1024 * 1024
+
+// ReaderAt returns the checksum of the first size bytes of r. Inputs larger
+// than ChunkSize are split into chunks which up to concurrency goroutines
+// hash at the same time, and whose checksums are merged with Combine.
+func ReaderAt(r io.ReaderAt, size int64, concurrency int) (uint64, error) {
+	if size <= ChunkSize || concurrency <= 1 {
+		return hashSection(r, 0, size)
+	}
+
+	crcs, err := Parts(r, size, ChunkSize, concurrency)
+	if err != nil {
+		return 0, err
+	}
+
+	var crc uint64
+	for i, part := range crcs {
+		off := int64(i) * ChunkSize
+		crc = Combine(crc, part, min(ChunkSize, size-off))
+	}
+	return crc, nil
+}
+
+// Parts splits the first size bytes of r into parts of partSize bytes, the
+// last one possibly shorter, and returns the checksum of every part. Up to
+// concurrency goroutines hash parts at the same time.
+func Parts(r io.ReaderAt, size, partSize int64, concurrency int) ([]uint64, error) {
+	parts := int((size + partSize - 1) / partSize)
+	crcs := make([]uint64, parts)
+	errs := make([]error, parts)
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range max(1, min(concurrency, parts)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				off := int64(i) * partSize
+				crcs[i], errs[i] = hashSection(r, off, min(partSize, size-off))
+			}
+		}()
+	}
+	for i := range parts {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return crcs, nil
+}
+
+func hashSection(r io.ReaderAt, off, n int64) (uint64, error) {
+	d := &digest{}
+	written, err := io.CopyBuffer(d, io.NewSectionReader(r, off, n), make([]byte, max(1, min(n, bufferSize))))
+	if err != nil {
+		return 0, err
+	}
+	if written != n {
+		return 0, fmt.Errorf("unexpected EOF after %d of %d bytes", off+written, off+n)
+	}
+	return d.crc, nil
+}
+
+// File returns the encoded checksum of the file at path. Large files are
+// hashed in parallel by up to GOMAXPROCS goroutines.
+func File(path string) (string, error) {
+	return FileWithConcurrency(path, runtime.GOMAXPROCS(0))
+}
+
+// FileWithConcurrency is like File, but hashes large files by up to
+// concurrency goroutines, e.g. 1 when many files are hashed at a time.
+func FileWithConcurrency(path string, concurrency int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	crc, err := ReaderAt(f, info.Size(), concurrency)
+	if err != nil {
+		return "", err
+	}
+	return Encode(crc), nil
+}
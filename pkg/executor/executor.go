@@ -2,16 +2,43 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"maps"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/logger"
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
 )
 
+// Journal records completed items so that an interrupted sync can be resumed.
+type Journal interface {
+	// Record is called once per successfully executed item. modTime is the
+	// modification time of the item's local file, if any.
+	Record(item planner.Item, modTime time.Time) error
+}
+
+// ErrNotStarted is the error of items that were not started because the
+// executor was interrupted or its context was done.
+var ErrNotStarted = errors.New("not started")
+
 type Executor struct {
+	// RetryPolicy is applied to every S3 operation. NewExecutor sets it to
+	// DefaultRetryPolicy.
+	RetryPolicy RetryPolicy
+	// Journal, if set, receives every completed item.
+	Journal Journal
+	// Interrupt, if set, is closed to stop starting items, e.g. on SIGINT.
+	// The items not started by then are reported with ErrNotStarted, while
+	// the running ones go on until the context they run with is done.
+	Interrupt <-chan struct{}
+
 	client      s3client.Client
 	logger      logger.Logger
 	concurrency int
@@ -22,6 +49,7 @@ func NewExecutor(client s3client.Client, logger logger.Logger, concurrency int)
 		concurrency = 32
 	}
 	return &Executor{
+		RetryPolicy: DefaultRetryPolicy(),
 		client:      client,
 		logger:      logger,
 		concurrency: concurrency,
@@ -31,84 +59,445 @@ func NewExecutor(client s3client.Client, logger logger.Logger, concurrency int)
 type Result struct {
 	Item  planner.Item
 	Error error
+	// Attempts is how many times the operation was tried, zero for skipped items.
+	Attempts int
+	// Reuploaded reports that a backfilled object turned out to differ
+	// from its local file, which was then uploaded.
+	Reuploaded bool
 }
 
+// Execute runs items and returns their results in the same order.
 func (e *Executor) Execute(ctx context.Context, items []planner.Item) []Result {
 	results := make([]Result, len(items))
 
-	sem := make(chan struct{}, e.concurrency)
-	var wg sync.WaitGroup
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range items {
+			jobs <- i
+		}
+	}()
+
+	runWorkers(e.concurrency, jobs, func(i int) {
+		results[i] = e.executeOne(ctx, items[i])
+	})
+
+	return results
+}
+
+// ExecuteStream runs the items received from items until it is closed, and
+// calls fn with the result of each as soon as it completes. fn is never
+// called concurrently. Unlike Execute, memory use doesn't grow with the
+// number of items.
+func (e *Executor) ExecuteStream(ctx context.Context, items <-chan planner.Item, fn func(Result)) {
+	var mu sync.Mutex
+	runWorkers(e.concurrency, items, func(item planner.Item) {
+		result := e.executeOne(ctx, item)
+
+		mu.Lock()
+		defer mu.Unlock()
+		fn(result)
+	})
+}
 
-	for i, item := range items {
+// runWorkers calls fn for every job received from jobs on n goroutines, and
+// returns once jobs is closed and fn has returned for all of them.
+func runWorkers[T any](n int, jobs <-chan T, fn func(T)) {
+	var wg sync.WaitGroup
+	for range n {
 		wg.Add(1)
-		go func(idx int, itm planner.Item) {
+		go func() {
 			defer wg.Done()
+			for job := range jobs {
+				fn(job)
+			}
+		}()
+	}
+	wg.Wait()
+}
 
-			sem <- struct{}{}
-			defer func() { <-sem }()
+// executeOne runs a single item, logging and journaling it.
+func (e *Executor) executeOne(ctx context.Context, item planner.Item) Result {
+	select {
+	case <-e.Interrupt:
+		return Result{Item: item, Error: ErrNotStarted}
+	default:
+	}
+	if ctx.Err() != nil {
+		return Result{Item: item, Error: ErrNotStarted}
+	}
 
-			// Log the start of the operation
-			switch itm.Action {
-			case planner.ActionUpload:
-				e.logger.Upload(itm.LocalPath, fmt.Sprintf("s3://%s/%s", itm.Bucket, itm.Key))
-			case planner.ActionDelete:
-				e.logger.Delete(fmt.Sprintf("s3://%s/%s", itm.Bucket, itm.Key))
-			}
+	// Log the start of the operation
+	switch item.Action {
+	case planner.ActionUpload:
+		e.logger.Upload(item.LocalPath, fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key))
+	case planner.ActionDownload:
+		e.logger.Download(fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key), item.LocalPath)
+	case planner.ActionCopy:
+		e.logger.Copy(fmt.Sprintf("s3://%s/%s", item.SourceBucket, item.SourceKey), fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key))
+	case planner.ActionBackfill:
+		s3Path := fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key)
+		e.logger.Copy(s3Path, s3Path)
+	case planner.ActionDelete:
+		e.logger.Delete(fmt.Sprintf("s3://%s/%s", item.Bucket, item.Key))
+	case planner.ActionDeleteLocal:
+		e.logger.Delete(item.LocalPath)
+	}
 
-			err := e.executeItem(ctx, itm)
-
-			// Log errors
-			if err != nil {
-				var operation string
-				switch itm.Action {
-				case planner.ActionUpload:
-					operation = "upload"
-				case planner.ActionDelete:
-					operation = "delete"
-				}
-				e.logger.Error(operation, fmt.Sprintf("%s/%s", itm.Bucket, itm.Key), err)
-			}
+	// Uploads are journaled with the mtime from before the upload, so
+	// that a file modified while uploading is verified again on resume
+	var modTime time.Time
+	if e.Journal != nil && (item.Action == planner.ActionUpload || item.Action == planner.ActionBackfill) {
+		if info, err := os.Stat(item.LocalPath); err == nil {
+			modTime = info.ModTime()
+		}
+	}
 
-			results[idx] = Result{
-				Item:  itm,
-				Error: err,
-			}
-		}(i, item)
+	var reuploaded bool
+	var attempts int
+	var err error
+	if item.Action == planner.ActionBackfill {
+		attempts, reuploaded, err = e.backfill(ctx, item)
+	} else {
+		attempts, err = e.executeItem(ctx, item)
+	}
+	if err == nil && e.Journal != nil && item.Action != planner.ActionSkip {
+		e.record(item, modTime)
 	}
 
-	wg.Wait()
-	return results
+	// Log errors
+	if err != nil {
+		var operation string
+		target := fmt.Sprintf("%s/%s", item.Bucket, item.Key)
+		switch item.Action {
+		case planner.ActionUpload:
+			operation = "upload"
+		case planner.ActionDownload:
+			operation = "download"
+		case planner.ActionCopy:
+			operation = "copy"
+		case planner.ActionBackfill:
+			operation = "backfill"
+		case planner.ActionDelete:
+			operation = "delete"
+		case planner.ActionDeleteLocal:
+			operation = "delete"
+			target = item.LocalPath
+		}
+		e.logger.Error(operation, target, err)
+	}
+
+	return Result{
+		Item:       item,
+		Error:      err,
+		Attempts:   attempts,
+		Reuploaded: reuploaded,
+	}
+}
+
+// record journals a completed item. A journal that can't be written only
+// makes a resume do more work, so it doesn't fail the item.
+func (e *Executor) record(item planner.Item, modTime time.Time) {
+	if item.Action == planner.ActionDownload {
+		info, err := os.Stat(item.LocalPath)
+		if err != nil {
+			e.logger.Error("journal", item.LocalPath, err)
+			return
+		}
+		modTime = info.ModTime()
+	}
+	// Backfilled objects match their local file just like uploaded ones,
+	// and a resume looks them up as uploads
+	if item.Action == planner.ActionBackfill {
+		item.Action = planner.ActionUpload
+	}
+
+	if err := e.Journal.Record(item, modTime); err != nil {
+		e.logger.Error("journal", fmt.Sprintf("%s/%s", item.Bucket, item.Key), err)
+	}
 }
 
-func (e *Executor) executeItem(ctx context.Context, item planner.Item) error {
+// executeItem performs the item's action and returns how many attempts it took.
+func (e *Executor) executeItem(ctx context.Context, item planner.Item) (int, error) {
 	switch item.Action {
 	case planner.ActionUpload:
 		return e.uploadFile(ctx, item)
+	case planner.ActionDownload:
+		return e.retry(ctx, item, func() error { return e.downloadFile(ctx, item) })
+	case planner.ActionCopy:
+		return e.retry(ctx, item, func() error { return e.copyObject(ctx, item) })
 	case planner.ActionDelete:
-		return e.deleteObject(ctx, item)
+		return e.retry(ctx, item, func() error { return e.deleteObject(ctx, item) })
+	case planner.ActionDeleteLocal:
+		return 1, e.deleteLocalFile(item)
 	default:
-		return nil
+		return 0, nil
 	}
 }
 
-func (e *Executor) uploadFile(ctx context.Context, item planner.Item) error {
+// retry runs op with the executor's RetryPolicy.
+func (e *Executor) retry(ctx context.Context, item planner.Item, op func() error) (int, error) {
+	attempt := 0
+	return e.RetryPolicy.do(ctx, func() error {
+		attempt++
+		if attempt > 1 {
+			e.logger.Debug(fmt.Sprintf("retrying %s of s3://%s/%s (attempt %d/%d)", item.Action, item.Bucket, item.Key, attempt, e.RetryPolicy.MaxAttempts))
+		}
+		return op()
+	})
+}
+
+func (e *Executor) uploadFile(ctx context.Context, item planner.Item) (int, error) {
 	file, err := os.Open(item.LocalPath)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		return 1, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
+	checksum := item.Checksum
+	if stored, ok := item.Metadata[planner.MetadataChecksum]; ok {
+		if err := checkSourceMetadata(file, item.Metadata); err != nil {
+			return 1, err
+		}
+		// Pin the content the metadata describes
+		if checksum == "" && isCRC64NVME(item.ChecksumAlgorithm) {
+			checksum = stored
+		}
+	}
+
 	contentType := guessContentType(item.LocalPath)
-	err = e.client.PutObject(ctx, &s3client.PutObjectRequest{
-		Bucket:      item.Bucket,
-		Key:         item.Key,
-		Body:        file,
-		Size:        item.Size,
-		Checksum:    item.Checksum,
-		ContentType: contentType,
+	return e.retry(ctx, item, func() error {
+		// A failed attempt may have consumed part of the file
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind file: %w", err)
+		}
+
+		err := e.client.PutObject(ctx, &s3client.PutObjectRequest{
+			Bucket:            item.Bucket,
+			Key:               item.Key,
+			Body:              file,
+			Size:              item.Size,
+			Checksum:          checksum,
+			ChecksumAlgorithm: item.ChecksumAlgorithm,
+			ContentType:       contentType,
+			Metadata:          item.Metadata,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload: %w", err)
+		}
+		return nil
 	})
+}
+
+// checkSourceMetadata fails if file no longer has the size and mtime its
+// planned source metadata records, as the recorded checksum may be stale.
+func checkSourceMetadata(file *os.File, metadata map[string]string) error {
+	info, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to upload: %w", err)
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !maps.Equal(planner.SourceMetadata(metadata[planner.MetadataChecksum], info), metadata) {
+		return fmt.Errorf("file was modified after it was planned")
+	}
+	return nil
+}
+
+func isCRC64NVME(algorithm string) bool {
+	return algorithm == "" || algorithm == s3client.ChecksumAlgorithmCRC64NVME
+}
+
+// downloadFile writes the object to a temporary file next to the destination,
+// verifies its checksum and then renames it into place, so that the
+// destination is never left with partial or corrupted content.
+func (e *Executor) downloadFile(ctx context.Context, item planner.Item) error {
+	obj, err := e.client.GetObject(ctx, &s3client.GetObjectRequest{
+		Bucket: item.Bucket,
+		Key:    item.Key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+	defer obj.Body.Close()
+
+	algorithm, expected := obj.PreferredChecksum()
+	if expected == "" {
+		algorithm, expected = item.ChecksumAlgorithm, item.Checksum
+	}
+	if expected == "" {
+		return fmt.Errorf("object has no checksum to verify against")
+	}
+
+	// Objects uploaded in parts by other tools may have a composite
+	// checksum, which is calculated over the same parts
+	var h hash.Hash
+	var composite *s3client.CompositeHash
+	if obj.ChecksumType == s3client.ChecksumTypeComposite || s3client.IsCompositeChecksum(expected) {
+		partSize, err := e.partSize(ctx, item)
+		if err != nil {
+			return err
+		}
+		if composite, err = s3client.NewCompositeHash(algorithm, partSize); err != nil {
+			return err
+		}
+		h = composite
+	} else if h, err = s3client.NewChecksumHash(algorithm); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(item.LocalPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(item.LocalPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := io.Copy(io.MultiWriter(tmp, h), obj.Body); err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+
+	actual := s3client.EncodeChecksum(h)
+	if composite != nil {
+		actual = composite.Checksum()
+	}
+	if actual != expected {
+		return &s3client.ChecksumMismatchError{Expected: expected, Actual: actual}
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	// Keeping the modification time of the object lets later syncs compare
+	// timestamps
+	if !obj.ModTime.IsZero() {
+		if err := os.Chtimes(tmp.Name(), obj.ModTime, obj.ModTime); err != nil {
+			return fmt.Errorf("failed to set modification time: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), item.LocalPath); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+	committed = true
+
+	return nil
+}
+
+// partSize returns the size of the parts the object was uploaded in.
+func (e *Executor) partSize(ctx context.Context, item planner.Item) (int64, error) {
+	info, err := e.client.HeadObject(ctx, &s3client.HeadObjectRequest{
+		Bucket: item.Bucket,
+		Key:    item.Key,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get parts of object: %w", err)
+	}
+	if info.PartSize <= 0 {
+		return 0, fmt.Errorf("object has a composite checksum, but its part size is unknown")
+	}
+	return info.PartSize, nil
+}
+
+// copyObject copies the source object in the checksum algorithm it has, so
+// that the next sync finds both objects with a checksum in common. Objects
+// without a checksum are copied with CRC64NVME. Objects with a composite
+// checksum are copied in the same parts, so that the copy gets the same
+// composite checksum.
+func (e *Executor) copyObject(ctx context.Context, item planner.Item) error {
+	source, err := e.client.HeadObject(ctx, &s3client.HeadObjectRequest{
+		Bucket: item.SourceBucket,
+		Key:    item.SourceKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to head source object: %w", err)
+	}
+	algorithm, _ := source.PreferredChecksum()
+	req := &s3client.CopyObjectRequest{
+		SourceBucket:      item.SourceBucket,
+		SourceKey:         item.SourceKey,
+		Bucket:            item.Bucket,
+		Key:               item.Key,
+		Size:              item.Size,
+		ChecksumAlgorithm: algorithm,
+	}
+	// Without the part size, e.g. without permission for
+	// GetObjectAttributes, the copy gets a full object checksum instead
+	if source.ChecksumType == s3client.ChecksumTypeComposite {
+		req.PartSize = source.PartSize
+	}
+
+	err = e.client.CopyObject(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to copy: %w", err)
+	}
+
+	return nil
+}
+
+// backfill copies the object onto itself so that S3 calculates its checksum
+// in the planned algorithm, and compares it with the checksum of the local
+// file. An object that turns out to differ is replaced by the local file.
+func (e *Executor) backfill(ctx context.Context, item planner.Item) (int, bool, error) {
+	attempts, err := e.retry(ctx, item, func() error {
+		err := e.client.CopyObject(ctx, &s3client.CopyObjectRequest{
+			SourceBucket:      item.Bucket,
+			SourceKey:         item.Key,
+			Bucket:            item.Bucket,
+			Key:               item.Key,
+			Size:              item.Size,
+			ChecksumAlgorithm: item.ChecksumAlgorithm,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to copy object onto itself: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return attempts, false, err
+	}
+
+	var info *s3client.ObjectInfo
+	n, err := e.retry(ctx, item, func() error {
+		info, err = e.client.HeadObject(ctx, &s3client.HeadObjectRequest{
+			Bucket: item.Bucket,
+			Key:    item.Key,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to head object: %w", err)
+		}
+		return nil
+	})
+	attempts += n
+	if err != nil {
+		return attempts, false, err
+	}
+
+	algorithm := item.ChecksumAlgorithm
+	if algorithm == "" {
+		algorithm = s3client.ChecksumAlgorithmCRC64NVME
+	}
+	if checksum := info.ChecksumIn(algorithm); checksum != "" && checksum == item.Checksum {
+		return attempts, false, nil
+	}
+
+	n, err = e.uploadFile(ctx, item)
+	return attempts + n, true, err
+}
+
+func (e *Executor) deleteLocalFile(item planner.Item) error {
+	if err := os.Remove(item.LocalPath); err != nil {
+		return fmt.Errorf("failed to delete: %w", err)
 	}
 
 	return nil
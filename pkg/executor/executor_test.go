@@ -0,0 +1,425 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client/memory"
+)
+
+func TestExecuteDownload(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		checksum    string
+		partSize    int64
+		existing    string
+		wantErr     bool
+		wantContent string
+	}{
+		{
+			name:        "verified download",
+			content:     "Hello, World!\n",
+			checksum:    "SoXXbx67KpE=",
+			wantErr:     false,
+			wantContent: "Hello, World!\n",
+		},
+		{
+			name:        "verified download replaces existing file",
+			content:     "Hello, World!\n",
+			checksum:    "SoXXbx67KpE=",
+			existing:    "old content",
+			wantErr:     false,
+			wantContent: "Hello, World!\n",
+		},
+		{
+			name:        "checksum mismatch keeps existing file",
+			content:     "Hello, World?\n",
+			checksum:    "SoXXbx67KpE=",
+			existing:    "old content",
+			wantErr:     true,
+			wantContent: "old content",
+		},
+		{
+			// "Hello, World!\n" uploaded in parts of 7 bytes
+			name:        "verified download with composite checksum",
+			content:     "Hello, World!\n",
+			checksum:    "xre5xYZyUZ4=-2",
+			partSize:    7,
+			wantContent: "Hello, World!\n",
+		},
+		{
+			name:     "composite checksum mismatch",
+			content:  "Hello, World?\n",
+			checksum: "xre5xYZyUZ4=-2",
+			partSize: 7,
+			wantErr:  true,
+		},
+		{
+			name:     "composite checksum of other parts",
+			content:  "Hello, World!\n",
+			checksum: "xre5xYZyUZ4=-2",
+			partSize: 5,
+			wantErr:  true,
+		},
+		{
+			name:     "missing checksum",
+			content:  "Hello, World!\n",
+			checksum: "",
+			wantErr:  true,
+		},
+	}
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			localPath := filepath.Join(dir, "nested", "hello.txt")
+			if tt.existing != "" {
+				if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(localPath, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client := &mockS3Client{
+				getObjectFunc: func(ctx context.Context, req *s3client.GetObjectRequest) (*s3client.Object, error) {
+					return &s3client.Object{
+						ObjectInfo: s3client.ObjectInfo{Size: int64(len(tt.content)), ModTime: modTime, Checksum: tt.checksum},
+						Body:       io.NopCloser(strings.NewReader(tt.content)),
+					}, nil
+				},
+				headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+					return &s3client.ObjectInfo{
+						Size:         int64(len(tt.content)),
+						Checksum:     tt.checksum,
+						ChecksumType: s3client.ChecksumTypeComposite,
+						PartCount:    2,
+						PartSize:     tt.partSize,
+					}, nil
+				},
+			}
+
+			exec := NewExecutor(client, nopLogger{}, 1)
+			results := exec.Execute(context.Background(), []planner.Item{
+				{Action: planner.ActionDownload, LocalPath: localPath, Bucket: "test-bucket", Key: "hello.txt"},
+			})
+			if (results[0].Error != nil) != tt.wantErr {
+				t.Fatalf("Execute() error = %v, wantErr %v", results[0].Error, tt.wantErr)
+			}
+
+			got, err := os.ReadFile(localPath)
+			if tt.wantContent == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("expected no file at %s, got err = %v", localPath, err)
+				}
+			} else if string(got) != tt.wantContent {
+				t.Errorf("file content = %q, want %q", got, tt.wantContent)
+			}
+			if !tt.wantErr {
+				if info, err := os.Stat(localPath); err != nil || !info.ModTime().Equal(modTime) {
+					t.Errorf("downloaded file modified at %v, want the object's %v", info.ModTime(), modTime)
+				}
+			}
+
+			// No temporary files must be left behind
+			entries, err := os.ReadDir(filepath.Dir(localPath))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if entry.Name() != "hello.txt" {
+					t.Errorf("unexpected file left behind: %s", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteDownloadOtherAlgorithms(t *testing.T) {
+	// Objects written by other tools, e.g. uploaded in parts of 7 bytes
+	objects := []memory.Object{
+		{Key: "sha256.txt", ChecksumAlgorithm: s3client.ChecksumAlgorithmSHA256, Checksum: "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE="},
+		{Key: "crc32-composite.txt", ChecksumAlgorithm: s3client.ChecksumAlgorithmCRC32, Checksum: "hES3bA==-2", ChecksumType: s3client.ChecksumTypeComposite, PartCount: 2, PartSize: 7},
+		{Key: "sha256-composite.txt", ChecksumAlgorithm: s3client.ChecksumAlgorithmSHA256, Checksum: "SLw7YoQ00ibf1YH3/vKSTC2uMtO/kHuSbh2Ucrjj6jA=-2", ChecksumType: s3client.ChecksumTypeComposite, PartCount: 2, PartSize: 7},
+	}
+
+	for _, obj := range objects {
+		t.Run(obj.Key, func(t *testing.T) {
+			for _, content := range []string{"Hello, World!\n", "Hello, World?\n"} {
+				client := memory.New()
+				obj.Data = []byte(content)
+				client.SetObject("test-bucket", obj)
+
+				localPath := filepath.Join(t.TempDir(), obj.Key)
+				exec := NewExecutor(client, nopLogger{}, 1)
+				results := exec.Execute(context.Background(), []planner.Item{
+					{Action: planner.ActionDownload, LocalPath: localPath, Bucket: "test-bucket", Key: obj.Key},
+				})
+
+				wantErr := content != "Hello, World!\n"
+				if err := results[0].Error; wantErr != errors.Is(err, s3client.ErrChecksumMismatch) || !wantErr && err != nil {
+					t.Errorf("Execute() of %q error = %v, wantErr %v", content, err, wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteBackfill(t *testing.T) {
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "hello.txt")
+	if err := os.WriteFile(localPath, []byte("Hello, World!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		remote         string
+		checksum       string
+		algorithm      string
+		wantReuploaded bool
+	}{
+		{
+			name:     "same content",
+			remote:   "Hello, World!\n",
+			checksum: "SoXXbx67KpE=",
+		},
+		{
+			name:      "same content in SHA256",
+			remote:    "Hello, World!\n",
+			checksum:  "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE=",
+			algorithm: s3client.ChecksumAlgorithmSHA256,
+		},
+		{
+			name:           "different content",
+			remote:         "Hello, World?\n",
+			checksum:       "SoXXbx67KpE=",
+			wantReuploaded: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := memory.New()
+			client.SetObject("test-bucket", memory.Object{Key: "hello.txt", Data: []byte(tt.remote)})
+
+			exec := NewExecutor(client, nopLogger{}, 1)
+			results := exec.Execute(context.Background(), []planner.Item{
+				{Action: planner.ActionBackfill, LocalPath: localPath, Bucket: "test-bucket", Key: "hello.txt", Size: 14, Checksum: tt.checksum, ChecksumAlgorithm: tt.algorithm},
+			})
+
+			if results[0].Error != nil {
+				t.Fatalf("Execute() error = %v", results[0].Error)
+			}
+			if results[0].Reuploaded != tt.wantReuploaded {
+				t.Errorf("Reuploaded = %v, want %v", results[0].Reuploaded, tt.wantReuploaded)
+			}
+			if got := client.Calls(memory.OpPutObject) > 0; got != tt.wantReuploaded {
+				t.Errorf("uploaded = %v, want %v", got, tt.wantReuploaded)
+			}
+			obj, _ := client.Object("test-bucket", "hello.txt")
+			if string(obj.Data) != "Hello, World!\n" || obj.Checksum != tt.checksum {
+				t.Errorf("object = %q with checksum %q, want the local content with %q", obj.Data, obj.Checksum, tt.checksum)
+			}
+		})
+	}
+}
+
+func TestExecuteUploadChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "hello.txt")
+	if err := os.WriteFile(localPath, []byte("Hello, World!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		checksum string
+		fault    memory.Fault
+	}{
+		{
+			// The file changed after it was planned
+			name:     "rejected content",
+			checksum: "2yX60sjqiYo=",
+		},
+		{
+			name:     "stored checksum differs",
+			checksum: "SoXXbx67KpE=",
+			fault:    memory.Fault{Op: memory.OpPutObject, Checksum: "AAAAAAAAAAA="},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := memory.New()
+			client.AddFault(tt.fault)
+
+			exec := NewExecutor(client, nopLogger{}, 1)
+			results := exec.Execute(context.Background(), []planner.Item{
+				{Action: planner.ActionUpload, LocalPath: localPath, Bucket: "test-bucket", Key: "hello.txt", Size: 14, Checksum: tt.checksum},
+			})
+
+			if !errors.Is(results[0].Error, s3client.ErrChecksumMismatch) {
+				t.Fatalf("Execute() error = %v, want ErrChecksumMismatch", results[0].Error)
+			}
+			// Sending the same content again can't fix it
+			if results[0].Attempts != 1 {
+				t.Errorf("Attempts = %d, want 1", results[0].Attempts)
+			}
+		})
+	}
+}
+
+func TestExecuteUploadSourceMetadata(t *testing.T) {
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "hello.txt")
+	if err := os.WriteFile(localPath, []byte("Hello, World!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	if err := os.Chtimes(localPath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := planner.SourceMetadata("SoXXbx67KpE=", info)
+
+	tests := []struct {
+		name      string
+		checksum  string
+		algorithm string
+		metadata  map[string]string
+		wantErr   bool
+	}{
+		{
+			name:     "pinned by the metadata",
+			metadata: metadata,
+		},
+		{
+			name:     "planned checksum",
+			checksum: "SoXXbx67KpE=",
+			metadata: metadata,
+		},
+		{
+			name:      "other algorithm",
+			checksum:  "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE=",
+			algorithm: s3client.ChecksumAlgorithmSHA256,
+			metadata:  metadata,
+		},
+		{
+			name:     "modified after planning",
+			metadata: map[string]string{planner.MetadataChecksum: "SoXXbx67KpE=", planner.MetadataSize: "14", planner.MetadataModTime: "0"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := memory.New()
+
+			exec := NewExecutor(client, nopLogger{}, 1)
+			results := exec.Execute(context.Background(), []planner.Item{
+				{Action: planner.ActionUpload, LocalPath: localPath, Bucket: "test-bucket", Key: "hello.txt", Size: 14, Checksum: tt.checksum, ChecksumAlgorithm: tt.algorithm, Metadata: tt.metadata},
+			})
+			if tt.wantErr {
+				if results[0].Error == nil {
+					t.Error("Execute() error = nil, want an error for the modified file")
+				}
+				if _, ok := client.Object("test-bucket", "hello.txt"); ok {
+					t.Error("object was uploaded with stale metadata")
+				}
+				return
+			}
+			if results[0].Error != nil {
+				t.Fatalf("Execute() error = %v", results[0].Error)
+			}
+
+			obj, _ := client.Object("test-bucket", "hello.txt")
+			if !reflect.DeepEqual(obj.Metadata, metadata) {
+				t.Errorf("metadata = %v, want %v", obj.Metadata, metadata)
+			}
+			// The file is only read while it is uploaded
+			if tt.algorithm == "" && obj.Checksum != "SoXXbx67KpE=" {
+				t.Errorf("checksum = %q, want %q", obj.Checksum, "SoXXbx67KpE=")
+			}
+		})
+	}
+}
+
+func TestExecuteStream(t *testing.T) {
+	dir := t.TempDir()
+	client := memory.New()
+
+	items := make(chan planner.Item)
+	go func() {
+		defer close(items)
+		for i := range 10 {
+			name := fmt.Sprintf("file%d.txt", i)
+			localPath := filepath.Join(dir, name)
+			if err := os.WriteFile(localPath, []byte(name), 0644); err != nil {
+				t.Error(err)
+				return
+			}
+			items <- planner.Item{Action: planner.ActionUpload, LocalPath: localPath, Bucket: "test-bucket", Key: name, Size: int64(len(name))}
+		}
+	}()
+
+	exec := NewExecutor(client, nopLogger{}, 3)
+	// fn is never called concurrently, so got needs no lock
+	var got []string
+	exec.ExecuteStream(context.Background(), items, func(result Result) {
+		if result.Error != nil {
+			t.Errorf("ExecuteStream() error = %v", result.Error)
+		}
+		got = append(got, result.Item.Key)
+	})
+
+	if len(got) != 10 {
+		t.Fatalf("ExecuteStream() reported %d results, want 10", len(got))
+	}
+	for _, key := range got {
+		if _, ok := client.Object("test-bucket", key); !ok {
+			t.Errorf("object %s was not uploaded", key)
+		}
+	}
+}
+
+func TestExecuteInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "hello.txt")
+	if err := os.WriteFile(localPath, []byte("Hello, World!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := memory.New()
+	interrupt := make(chan struct{})
+	close(interrupt)
+
+	exec := NewExecutor(client, nopLogger{}, 1)
+	exec.Interrupt = interrupt
+	results := exec.Execute(context.Background(), []planner.Item{
+		{Action: planner.ActionUpload, LocalPath: localPath, Bucket: "test-bucket", Key: "hello.txt", Size: 14},
+	})
+	if !errors.Is(results[0].Error, ErrNotStarted) {
+		t.Errorf("Execute() error = %v, want ErrNotStarted", results[0].Error)
+	}
+	if _, ok := client.Object("test-bucket", "hello.txt"); ok {
+		t.Error("object was uploaded after the interrupt")
+	}
+}
@@ -0,0 +1,70 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+// mockS3Client is a mock implementation of s3client.Client for testing
+type mockS3Client struct {
+	headObjectFunc   func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error)
+	getObjectFunc    func(ctx context.Context, req *s3client.GetObjectRequest) (*s3client.Object, error)
+	putObjectFunc    func(ctx context.Context, req *s3client.PutObjectRequest) error
+	copyObjectFunc   func(ctx context.Context, req *s3client.CopyObjectRequest) error
+	deleteObjectFunc func(ctx context.Context, req *s3client.DeleteObjectRequest) error
+}
+
+func (m *mockS3Client) ListObjects(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+	return nil, fmt.Errorf("ListObjects not implemented")
+}
+
+func (m *mockS3Client) ListObjectsPages(ctx context.Context, req *s3client.ListObjectsRequest, fn func(page []s3client.ItemMetadata) error) error {
+	return fmt.Errorf("ListObjectsPages not implemented")
+}
+
+func (m *mockS3Client) HeadObject(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+	if m.headObjectFunc != nil {
+		return m.headObjectFunc(ctx, req)
+	}
+	return nil, fmt.Errorf("HeadObject not implemented")
+}
+
+func (m *mockS3Client) GetObject(ctx context.Context, req *s3client.GetObjectRequest) (*s3client.Object, error) {
+	if m.getObjectFunc != nil {
+		return m.getObjectFunc(ctx, req)
+	}
+	return nil, fmt.Errorf("GetObject not implemented")
+}
+
+func (m *mockS3Client) PutObject(ctx context.Context, req *s3client.PutObjectRequest) error {
+	if m.putObjectFunc != nil {
+		return m.putObjectFunc(ctx, req)
+	}
+	return fmt.Errorf("PutObject not implemented")
+}
+
+func (m *mockS3Client) CopyObject(ctx context.Context, req *s3client.CopyObjectRequest) error {
+	if m.copyObjectFunc != nil {
+		return m.copyObjectFunc(ctx, req)
+	}
+	return fmt.Errorf("CopyObject not implemented")
+}
+
+func (m *mockS3Client) DeleteObject(ctx context.Context, req *s3client.DeleteObjectRequest) error {
+	if m.deleteObjectFunc != nil {
+		return m.deleteObjectFunc(ctx, req)
+	}
+	return fmt.Errorf("DeleteObject not implemented")
+}
+
+// nopLogger is a logger.Logger that discards everything
+type nopLogger struct{}
+
+func (nopLogger) Upload(localPath, s3Path string)         {}
+func (nopLogger) Download(s3Path, localPath string)       {}
+func (nopLogger) Copy(sourceS3Path, destS3Path string)    {}
+func (nopLogger) Delete(s3Path string)                    {}
+func (nopLogger) Error(operation, path string, err error) {}
+func (nopLogger) Debug(message string)                    {}
@@ -0,0 +1,119 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how often and how fast failed operations are retried.
+// The AWS SDK retries individual requests on its own; this policy retries the
+// whole operation on top of that, e.g. when S3 keeps throttling a large sync.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one.
+	// Values below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the upper bound of the first backoff. It doubles with
+	// every further retry up to MaxDelay, and the actual delay is a random
+	// duration below that bound (full jitter).
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Retryable decides whether an error is worth retrying. Defaults to IsRetryable.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy returns the policy used by NewExecutor.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    20 * time.Second,
+		Retryable:   IsRetryable,
+	}
+}
+
+// backoff returns the delay before the given retry (1 for the first retry).
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	limit := p.BaseDelay
+	for i := 1; i < retry && limit < p.MaxDelay; i++ {
+		limit *= 2
+	}
+	if p.MaxDelay > 0 && limit > p.MaxDelay {
+		limit = p.MaxDelay
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit)
+}
+
+// do runs op until it succeeds, fails with a non-retryable error, runs out
+// of attempts or ctx is done. It returns the number of attempts made.
+func (p RetryPolicy) do(ctx context.Context, op func() error) (int, error) {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= p.MaxAttempts || !retryable(err) {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		}
+	}
+}
+
+// retryableCodes are S3 error codes for throttling and transient server failures.
+var retryableCodes = map[string]bool{
+	"SlowDown":                 true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestLimitExceeded":     true,
+	"TooManyRequestsException": true,
+	"RequestTimeout":           true,
+	"RequestTimeoutException":  true,
+	"InternalError":            true,
+	"ServiceUnavailable":       true,
+}
+
+// IsRetryable reports whether err is a transient failure: S3 throttling,
+// 5xx responses, timeouts and dropped connections. Canceled contexts and
+// client errors such as AccessDenied or a missing local file are not.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// Implemented by smithy.APIError
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) && retryableCodes[apiErr.ErrorCode()] {
+		return true
+	}
+
+	// Implemented by the SDK's HTTP response errors
+	var httpErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &httpErr) {
+		status := httpErr.HTTPStatusCode()
+		return status == 429 || status >= 500
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,203 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client/memory"
+)
+
+// apiError mimics smithy.APIError returned by the AWS SDK.
+type apiError struct {
+	code string
+}
+
+func (e *apiError) Error() string     { return "api error " + e.code }
+func (e *apiError) ErrorCode() string { return e.code }
+
+// statusError mimics the SDK's HTTP response error.
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string       { return fmt.Sprintf("http status %d", e.status) }
+func (e *statusError) HTTPStatusCode() int { return e.status }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "SlowDown", err: fmt.Errorf("failed to upload: %w", &apiError{code: "SlowDown"}), want: true},
+		{name: "InternalError", err: &apiError{code: "InternalError"}, want: true},
+		{name: "AccessDenied", err: &apiError{code: "AccessDenied"}, want: false},
+		{name: "503", err: &statusError{status: 503}, want: true},
+		{name: "429", err: &statusError{status: 429}, want: true},
+		{name: "404", err: &statusError{status: 404}, want: false},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, want: true},
+		{name: "unexpected EOF", err: fmt.Errorf("failed to download: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "timeout", err: &net.DNSError{IsTimeout: true}, want: true},
+		{name: "canceled", err: fmt.Errorf("failed to upload: %w", context.Canceled), want: false},
+		{name: "missing file", err: fmt.Errorf("failed to open file: %w", os.ErrNotExist), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for retry, limit := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		10: time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(retry); d < 0 || d >= limit {
+				t.Fatalf("backoff(%d) = %v, want [0, %v)", retry, d, limit)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	errSlowDown := &apiError{code: "SlowDown"}
+
+	tests := []struct {
+		name         string
+		maxAttempts  int
+		failures     int
+		err          error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "success", maxAttempts: 3, failures: 0, err: errSlowDown, wantAttempts: 1},
+		{name: "success after retries", maxAttempts: 3, failures: 2, err: errSlowDown, wantAttempts: 3},
+		{name: "attempts exhausted", maxAttempts: 3, failures: 5, err: errSlowDown, wantAttempts: 3, wantErr: true},
+		{name: "retries disabled", maxAttempts: 1, failures: 1, err: errSlowDown, wantAttempts: 1, wantErr: true},
+		{name: "not retryable", maxAttempts: 3, failures: 1, err: &apiError{code: "AccessDenied"}, wantAttempts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := RetryPolicy{MaxAttempts: tt.maxAttempts, BaseDelay: time.Millisecond}
+			calls := 0
+			attempts, err := p.do(context.Background(), func() error {
+				calls++
+				if calls <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts || calls != tt.wantAttempts {
+				t.Errorf("do() attempts = %d (calls %d), want %d", attempts, calls, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+
+	attempts, err := p.do(ctx, func() error {
+		cancel()
+		return &apiError{code: "SlowDown"}
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("do() = %d, %v, want 1 attempt and an error", attempts, err)
+	}
+}
+
+func TestExecuteUploadRetriesAndRewinds(t *testing.T) {
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "hello.txt")
+	content := "Hello, World!\n"
+	if err := os.WriteFile(localPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var uploaded []string
+	client := &mockS3Client{
+		putObjectFunc: func(ctx context.Context, req *s3client.PutObjectRequest) error {
+			// Consume part of the body before failing like a dropped connection
+			if len(uploaded) == 0 {
+				buf := make([]byte, 5)
+				io.ReadFull(req.Body, buf)
+				uploaded = append(uploaded, string(buf))
+				return fmt.Errorf("connection dropped: %w", syscall.ECONNRESET)
+			}
+			data, err := io.ReadAll(req.Body)
+			if err != nil {
+				return err
+			}
+			uploaded = append(uploaded, string(data))
+			return nil
+		},
+	}
+
+	exec := NewExecutor(client, nopLogger{}, 1)
+	exec.RetryPolicy.BaseDelay = time.Millisecond
+	results := exec.Execute(context.Background(), []planner.Item{
+		{Action: planner.ActionUpload, LocalPath: localPath, Bucket: "test-bucket", Key: "hello.txt", Size: int64(len(content))},
+	})
+
+	if results[0].Error != nil {
+		t.Fatalf("Execute() error = %v", results[0].Error)
+	}
+	if results[0].Attempts != 2 {
+		t.Errorf("Attempts = %d, want 2", results[0].Attempts)
+	}
+	if len(uploaded) != 2 || uploaded[1] != content {
+		t.Errorf("uploaded = %q, want the whole file on the second attempt", uploaded)
+	}
+}
+
+func TestExecuteRetriesThrottling(t *testing.T) {
+	client := memory.New()
+	client.PutBytes("test-bucket", "old.txt", []byte("old"))
+	client.PutBytes("test-bucket", "source.txt", []byte("source"))
+	client.AddFault(memory.Fault{Op: memory.OpDeleteObject, Err: &apiError{code: "SlowDown"}, Times: 2})
+	client.AddFault(memory.Fault{Op: memory.OpCopyObject, Err: &apiError{code: "AccessDenied"}})
+
+	exec := NewExecutor(client, nopLogger{}, 1)
+	exec.RetryPolicy.BaseDelay = time.Millisecond
+	results := exec.Execute(context.Background(), []planner.Item{
+		{Action: planner.ActionDelete, Bucket: "test-bucket", Key: "old.txt"},
+		{Action: planner.ActionCopy, SourceBucket: "test-bucket", SourceKey: "source.txt", Bucket: "test-bucket", Key: "new.txt"},
+		{Action: planner.ActionSkip, Bucket: "test-bucket", Key: "unchanged.txt"},
+	})
+
+	if results[0].Error != nil || results[0].Attempts != 3 {
+		t.Errorf("delete = %d attempts, error %v, want 3 attempts and success", results[0].Attempts, results[0].Error)
+	}
+	var apiErr *apiError
+	if !errors.As(results[1].Error, &apiErr) || results[1].Attempts != 1 {
+		t.Errorf("copy = %d attempts, error %v, want 1 attempt and failure", results[1].Attempts, results[1].Error)
+	}
+	if results[2].Attempts != 0 {
+		t.Errorf("skip = %d attempts, want 0", results[2].Attempts)
+	}
+}
@@ -0,0 +1,149 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client/memory"
+)
+
+// TestSyncRoundTrip plans and executes against the in-memory client and
+// expects the second plan to be all skips.
+func TestSyncRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	files := map[string]string{
+		"hello.txt":      "Hello, World!\n",
+		"sub/nested.txt": "nested\n",
+		"changed.txt":    "new content",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := memory.New()
+	client.PutBytes("bucket", "prefix/changed.txt", []byte("old content"))
+	client.PutBytes("bucket", "prefix/stale.txt", []byte("stale"))
+
+	p := planner.NewFSToS3Planner(client, nopLogger{})
+	source := planner.Source{Type: planner.SourceTypeFileSystem, Path: dir}
+	dest := planner.Destination{Type: planner.DestTypeS3, Path: "s3://bucket/prefix"}
+	opts := planner.Options{DeleteEnabled: true}
+
+	items, err := p.Plan(ctx, source, dest, opts)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	exec := NewExecutor(client, nopLogger{}, 4)
+	for _, result := range exec.Execute(ctx, items) {
+		if result.Error != nil {
+			t.Fatalf("Execute() %s %s error = %v", result.Item.Action, result.Item.Key, result.Error)
+		}
+	}
+
+	for name, content := range files {
+		obj, ok := client.Object("bucket", "prefix/"+name)
+		if !ok {
+			t.Errorf("object prefix/%s not uploaded", name)
+			continue
+		}
+		if string(obj.Data) != content {
+			t.Errorf("object prefix/%s = %q, want %q", name, obj.Data, content)
+		}
+	}
+	if _, ok := client.Object("bucket", "prefix/stale.txt"); ok {
+		t.Error("prefix/stale.txt should have been deleted")
+	}
+
+	items, err = p.Plan(ctx, source, dest, opts)
+	if err != nil {
+		t.Fatalf("second Plan() error = %v", err)
+	}
+	for _, item := range items {
+		if item.Action != planner.ActionSkip {
+			t.Errorf("second Plan() item %s = %s (%s), want skip", item.Key, item.Action, item.Reason)
+		}
+	}
+}
+
+// TestS3ToS3SyncRoundTrip copies objects that only have a checksum in
+// another algorithm than CRC64NVME and expects the second plan to be all
+// skips.
+func TestS3ToS3SyncRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	client := memory.New()
+	client.PutBytes("source", "crc64.txt", []byte("crc64"))
+	for _, algorithm := range []string{s3client.ChecksumAlgorithmCRC32, s3client.ChecksumAlgorithmSHA256} {
+		data := []byte(algorithm)
+		h, err := s3client.NewChecksumHash(algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Write(data)
+		client.SetObject("source", memory.Object{
+			Key:               algorithm + ".txt",
+			Data:              data,
+			Checksum:          s3client.EncodeChecksum(h),
+			ChecksumAlgorithm: algorithm,
+		})
+		// Copied by an earlier version, which always copied with CRC64NVME
+		client.PutBytes("dest", algorithm+".txt", data)
+	}
+	// Uploaded in parts, which a plain copy would give a full object
+	// checksum that never matches the composite checksum of the source
+	composite := []byte("uploaded in parts")
+	h, err := s3client.NewCompositeHash(s3client.ChecksumAlgorithmSHA256, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Write(composite)
+	client.SetObject("source", memory.Object{
+		Key:               "composite.txt",
+		Data:              composite,
+		Checksum:          h.Checksum(),
+		ChecksumAlgorithm: s3client.ChecksumAlgorithmSHA256,
+		ChecksumType:      s3client.ChecksumTypeComposite,
+		PartCount:         4,
+		PartSize:          5,
+	})
+
+	p := planner.NewS3ToS3Planner(client, nopLogger{})
+	source := planner.Source{Type: planner.SourceTypeS3, Path: "s3://source"}
+	dest := planner.Destination{Type: planner.DestTypeS3, Path: "s3://dest"}
+
+	items, err := p.Plan(ctx, source, dest, planner.Options{})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	exec := NewExecutor(client, nopLogger{}, 4)
+	for _, result := range exec.Execute(ctx, items) {
+		if result.Error != nil {
+			t.Fatalf("Execute() %s %s error = %v", result.Item.Action, result.Item.Key, result.Error)
+		}
+	}
+
+	items, err = p.Plan(ctx, source, dest, planner.Options{})
+	if err != nil {
+		t.Fatalf("second Plan() error = %v", err)
+	}
+	if len(items) != 4 {
+		t.Fatalf("second Plan() = %d items, want 4", len(items))
+	}
+	for _, item := range items {
+		if item.Action != planner.ActionSkip {
+			t.Errorf("second Plan() item %s = %s (%s), want skip", item.Key, item.Action, item.Reason)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/crc64nvme"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+// ErrChecksumDrift is returned by Verify when local files no longer match
+// the checksums they were planned with.
+var ErrChecksumDrift = errors.New("local files changed since the plan was made")
+
+// Verify recomputes the checksum of the local file of every upload and
+// backfill in items, in the algorithm it was planned with, and fails with
+// ErrChecksumDrift, listing the changed files, if any of them differs from
+// the planned checksum. Uploads without a planned checksum can't be verified
+// and count as changed.
+func (e *Executor) Verify(ctx context.Context, items []planner.Item) error {
+	var mu sync.Mutex
+	var drifted []string
+	var errs []error
+
+	uploads := make(chan planner.Item)
+	go func() {
+		defer close(uploads)
+		for _, item := range items {
+			if item.Action == planner.ActionUpload || item.Action == planner.ActionBackfill {
+				uploads <- item
+			}
+		}
+	}()
+
+	runWorkers(e.concurrency, uploads, func(item planner.Item) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		checksum, err := fileChecksum(item.LocalPath, item.ChecksumAlgorithm)
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("failed to verify %s: %w", item.LocalPath, err))
+		case item.Checksum == "" || checksum != item.Checksum:
+			drifted = append(drifted, item.LocalPath)
+		}
+	})
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if len(drifted) > 0 {
+		sort.Strings(drifted)
+		return fmt.Errorf("%w: %s", ErrChecksumDrift, strings.Join(drifted, ", "))
+	}
+
+	return nil
+}
+
+// fileChecksum returns the checksum of the file at path in algorithm,
+// CRC64NVME if empty.
+func fileChecksum(path string, algorithm string) (string, error) {
+	if algorithm == "" || algorithm == s3client.ChecksumAlgorithmCRC64NVME {
+		return crc64nvme.File(path)
+	}
+
+	h, err := s3client.NewChecksumHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return s3client.EncodeChecksum(h), nil
+}
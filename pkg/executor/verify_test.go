@@ -0,0 +1,58 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client/memory"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	unchanged := filepath.Join(dir, "unchanged.txt")
+	changed := filepath.Join(dir, "changed.txt")
+	for _, path := range []string{unchanged, changed} {
+		if err := os.WriteFile(path, []byte("Hello, World!\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items := []planner.Item{
+		{Action: planner.ActionUpload, LocalPath: unchanged, Bucket: "bucket", Key: "unchanged.txt", Checksum: "SoXXbx67KpE="},
+		{Action: planner.ActionUpload, LocalPath: changed, Bucket: "bucket", Key: "changed.txt", Checksum: "SoXXbx67KpE="},
+		// Verified in the algorithm it was planned with
+		{Action: planner.ActionUpload, LocalPath: unchanged, Bucket: "bucket", Key: "sha.txt", Checksum: "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE=", ChecksumAlgorithm: "SHA256"},
+		// Only uploads are verified
+		{Action: planner.ActionDelete, Bucket: "bucket", Key: "deleted.txt"},
+		{Action: planner.ActionSkip, LocalPath: filepath.Join(dir, "missing.txt"), Bucket: "bucket", Key: "missing.txt"},
+	}
+
+	exec := NewExecutor(memory.New(), nopLogger{}, 2)
+	if err := exec.Verify(context.Background(), items); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if err := os.WriteFile(changed, []byte("Hello, World?\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := exec.Verify(context.Background(), items)
+	if !errors.Is(err, ErrChecksumDrift) {
+		t.Fatalf("Verify() error = %v, want ErrChecksumDrift", err)
+	}
+	if !strings.Contains(err.Error(), changed) || strings.Contains(err.Error(), unchanged) {
+		t.Errorf("Verify() error = %v, want only %s listed", err, changed)
+	}
+
+	if err := os.Remove(changed); err != nil {
+		t.Fatal(err)
+	}
+	err = exec.Verify(context.Background(), items)
+	if err == nil || errors.Is(err, ErrChecksumDrift) {
+		t.Errorf("Verify() error = %v, want an error for the missing file", err)
+	}
+}
@@ -0,0 +1,204 @@
+// Package journal records the items completed by the executor so that an
+// interrupted sync can be resumed without verifying them again.
+//
+// The journal is a JSON Lines file with one Entry per completed item. Entries
+// are only ever appended, so a crash can at worst leave a truncated last line,
+// which is ignored when the journal is loaded.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/executor"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
+)
+
+// Entry is a completed item.
+type Entry struct {
+	Action       planner.Action `json:"action"`
+	LocalPath    string         `json:"local_path,omitempty"`
+	Bucket       string         `json:"bucket,omitempty"`
+	Key          string         `json:"key,omitempty"`
+	SourceBucket string         `json:"source_bucket,omitempty"`
+	SourceKey    string         `json:"source_key,omitempty"`
+	Size         int64          `json:"size"`
+	Checksum     string         `json:"checksum,omitempty"`
+	// ModTime is the modification time of LocalPath: before the upload for
+	// uploads and after the download for downloads.
+	ModTime time.Time `json:"mtime,omitzero"`
+}
+
+// Journal is an append-only record of completed items.
+// It implements both planner.Journal and executor.Journal.
+type Journal struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string]Entry
+}
+
+// Open loads the journal at path, creating it if it doesn't exist, and
+// appends newly recorded items to it.
+func Open(path string) (*Journal, error) {
+	entries, truncated, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	// Don't glue the next entry onto a line cut off by a crash
+	if truncated {
+		if _, err := file.Write([]byte{'\n'}); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write journal: %w", err)
+		}
+	}
+
+	return &Journal{
+		file:    file,
+		entries: entries,
+	}, nil
+}
+
+// Load loads the journal at path read-only, e.g. to preview a resume. Its
+// Completed reports the loaded items, but nothing can be recorded, and a
+// missing journal is not created.
+func Load(path string) (*Journal, error) {
+	entries, _, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{entries: entries}, nil
+}
+
+// load reads the entries at path and reports whether the last line is
+// missing its newline.
+func load(path string) (map[string]Entry, bool, error) {
+	entries := make(map[string]Entry)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return entries, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Most likely the last line was cut off by a crash
+			continue
+		}
+		entries[entryKey(entry.Action, entry.Bucket, entry.Key, entry.LocalPath)] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to stat journal: %w", err)
+	}
+	if info.Size() == 0 {
+		return entries, false, nil
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return nil, false, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	return entries, last[0] != '\n', nil
+}
+
+// Len returns the number of items in the journal.
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.entries)
+}
+
+// Record appends item to the journal. modTime is the modification time of
+// the item's local file, if any.
+func (j *Journal) Record(item planner.Item, modTime time.Time) error {
+	entry := Entry{
+		Action:       item.Action,
+		LocalPath:    item.LocalPath,
+		Bucket:       item.Bucket,
+		Key:          item.Key,
+		SourceBucket: item.SourceBucket,
+		SourceKey:    item.SourceKey,
+		Size:         item.Size,
+		Checksum:     item.Checksum,
+		ModTime:      modTime,
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return fmt.Errorf("failed to write journal: loaded read-only")
+	}
+	// A single write per entry keeps lines intact with O_APPEND
+	if _, err := j.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	j.entries[entryKey(entry.Action, entry.Bucket, entry.Key, entry.LocalPath)] = entry
+
+	return nil
+}
+
+// Completed reports whether item was journaled with the same size and
+// source, and its local file still has the journaled size and mtime.
+func (j *Journal) Completed(item planner.Item) bool {
+	j.mu.Lock()
+	entry, ok := j.entries[entryKey(item.Action, item.Bucket, item.Key, item.LocalPath)]
+	j.mu.Unlock()
+
+	if !ok || entry.Size != item.Size || entry.SourceBucket != item.SourceBucket || entry.SourceKey != item.SourceKey {
+		return false
+	}
+
+	if item.LocalPath == "" {
+		return true
+	}
+	info, err := os.Stat(item.LocalPath)
+	if err != nil {
+		return false
+	}
+	return info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime)
+}
+
+var (
+	_ planner.Journal  = (*Journal)(nil)
+	_ executor.Journal = (*Journal)(nil)
+)
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+func entryKey(action planner.Action, bucket, key, localPath string) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%s", action, bucket, key, localPath)
+}
@@ -0,0 +1,255 @@
+package journal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/executor"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client/memory"
+)
+
+func writeFile(t *testing.T, path, content string) time.Time {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.ModTime()
+}
+
+func TestJournalCompleted(t *testing.T) {
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "file.txt")
+	modTime := writeFile(t, localPath, "content")
+
+	upload := planner.Item{Action: planner.ActionUpload, LocalPath: localPath, Bucket: "bucket", Key: "file.txt", Size: 7}
+	copyItem := planner.Item{Action: planner.ActionCopy, SourceBucket: "src", SourceKey: "file.txt", Bucket: "bucket", Key: "copied.txt", Size: 7}
+
+	journalPath := filepath.Join(dir, "journal.jsonl")
+	j, err := Open(journalPath)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := j.Record(upload, modTime); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if err := j.Record(copyItem, time.Time{}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reload from disk like --resume does
+	j, err = Open(journalPath)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer j.Close()
+	if j.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", j.Len())
+	}
+
+	otherSource := copyItem
+	otherSource.SourceKey = "other.txt"
+	otherSize := upload
+	otherSize.Size = 8
+	download := upload
+	download.Action = planner.ActionDownload
+
+	tests := []struct {
+		name string
+		item planner.Item
+		want bool
+	}{
+		{name: "journaled upload", item: upload, want: true},
+		{name: "journaled copy", item: copyItem, want: true},
+		{name: "different size", item: otherSize, want: false},
+		{name: "different source", item: otherSource, want: false},
+		{name: "different action", item: download, want: false},
+		{name: "not journaled", item: planner.Item{Action: planner.ActionUpload, Bucket: "bucket", Key: "new.txt"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := j.Completed(tt.item); got != tt.want {
+				t.Errorf("Completed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Touching the local file requires verification again
+	if err := os.Chtimes(localPath, time.Now(), modTime.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if j.Completed(upload) {
+		t.Error("Completed() = true after the local file's mtime changed")
+	}
+}
+
+func TestJournalTruncatedLine(t *testing.T) {
+	dir := t.TempDir()
+	journalPath := filepath.Join(dir, "journal.jsonl")
+	content := `{"action":"copy","bucket":"bucket","key":"a.txt","source_bucket":"src","source_key":"a.txt","size":1}` + "\n" +
+		`{"action":"copy","bucket":"buck`
+	if err := os.WriteFile(journalPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := Open(journalPath)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if j.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", j.Len())
+	}
+	item := planner.Item{Action: planner.ActionCopy, SourceBucket: "src", SourceKey: "b.txt", Bucket: "bucket", Key: "b.txt", Size: 1}
+	if err := j.Record(item, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j, err = Open(journalPath)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer j.Close()
+	if !j.Completed(item) {
+		t.Error("entry recorded after a truncated line was lost")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	journalPath := filepath.Join(dir, "journal.jsonl")
+
+	// A dry run must not create the journal it resumes from
+	j, err := Load(journalPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if j.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", j.Len())
+	}
+	if _, err := os.Stat(journalPath); !os.IsNotExist(err) {
+		t.Errorf("Load() created the journal: %v", err)
+	}
+
+	item := planner.Item{Action: planner.ActionCopy, SourceBucket: "src", SourceKey: "a.txt", Bucket: "bucket", Key: "a.txt", Size: 1}
+	j, err = Open(journalPath)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := j.Record(item, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	content, err := os.ReadFile(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "mtime") {
+		t.Errorf("journal = %s, want no mtime for an item without a local file", content)
+	}
+
+	j, err = Load(journalPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !j.Completed(item) {
+		t.Error("Completed() = false for a journaled item")
+	}
+	if err := j.Record(item, time.Time{}); err == nil {
+		t.Error("Record() on a loaded journal expected error")
+	}
+}
+
+// TestResume interrupts a sync halfway and expects the resumed plan to skip
+// the journaled items without heading them again.
+func TestResume(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	srcDir := filepath.Join(dir, "src")
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeFile(t, filepath.Join(srcDir, name), "content of "+name)
+	}
+
+	client := memory.New()
+	p := planner.NewFSToS3Planner(client, nopLogger{})
+	source := planner.Source{Type: planner.SourceTypeFileSystem, Path: srcDir}
+	dest := planner.Destination{Type: planner.DestTypeS3, Path: "s3://bucket/prefix"}
+
+	items, err := p.Plan(ctx, source, dest, planner.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	journalPath := filepath.Join(dir, "journal.jsonl")
+	j, err := Open(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first run dies after two of three uploads
+	exec := executor.NewExecutor(client, nopLogger{}, 1)
+	exec.Journal = j
+	for _, result := range exec.Execute(ctx, items[:2]) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	j.Close()
+
+	// a.txt changes after it was uploaded but keeps its size
+	writeFile(t, filepath.Join(srcDir, "a.txt"), "CONTENT of a.txt")
+
+	j, err = Open(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	heads := client.Calls(memory.OpHeadObject)
+	items, err = p.Plan(ctx, source, dest, planner.Options{Journal: j})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, item := range items {
+		got[item.Key] = item.Reason
+	}
+	want := map[string]string{
+		"prefix/a.txt": "checksum differs",
+		"prefix/b.txt": "completed in previous run",
+		"prefix/c.txt": "new file",
+	}
+	for key, reason := range want {
+		if got[key] != reason {
+			t.Errorf("%s reason = %q, want %q", key, got[key], reason)
+		}
+	}
+	if n := client.Calls(memory.OpHeadObject) - heads; n != 1 {
+		t.Errorf("resumed plan made %d HeadObject calls, want 1 for the modified file", n)
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Upload(localPath, s3Path string)         {}
+func (nopLogger) Download(s3Path, localPath string)       {}
+func (nopLogger) Copy(sourceS3Path, destS3Path string)    {}
+func (nopLogger) Delete(s3Path string)                    {}
+func (nopLogger) Error(operation, path string, err error) {}
+func (nopLogger) Debug(message string)                    {}
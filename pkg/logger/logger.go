@@ -9,6 +9,8 @@ import (
 type Logger interface {
 	// User-facing operation logs
 	Upload(localPath, s3Path string)
+	Download(s3Path, localPath string)
+	Copy(sourceS3Path, destS3Path string)
 	Delete(s3Path string)
 	Error(operation, path string, err error)
 
@@ -34,6 +36,30 @@ func (l *SyncLogger) Upload(localPath, s3Path string) {
 	}
 }
 
+func (l *SyncLogger) Download(s3Path, localPath string) {
+	if l.IsQuiet {
+		return
+	}
+
+	if l.IsDryRun {
+		fmt.Printf("(dryrun) download: %s to %s\n", s3Path, localPath)
+	} else {
+		fmt.Printf("download: %s to %s\n", s3Path, localPath)
+	}
+}
+
+func (l *SyncLogger) Copy(sourceS3Path, destS3Path string) {
+	if l.IsQuiet {
+		return
+	}
+
+	if l.IsDryRun {
+		fmt.Printf("(dryrun) copy: %s to %s\n", sourceS3Path, destS3Path)
+	} else {
+		fmt.Printf("copy: %s to %s\n", sourceS3Path, destS3Path)
+	}
+}
+
 func (l *SyncLogger) Delete(s3Path string) {
 	if l.IsQuiet {
 		return
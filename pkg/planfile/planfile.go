@@ -0,0 +1,318 @@
+// Package planfile reads and writes the plan JSON.
+//
+// The plan JSON is meant to be reviewed by humans, so every file is described
+// by its source and target paths. It also keeps the planner action, size and
+// checksum of every item, which is enough to turn it back into the
+// []planner.Item it was written from and apply it later.
+package planfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
+)
+
+// Version is the version of the plan format written by this package.
+// Plans of other versions are rejected by Read.
+const Version = 1
+
+// Plan represents the planned operations before execution
+type Plan struct {
+	Version     int    `json:"version"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Files       []File `json:"files"`
+	// Errors lists the files that could not be compared with --keep-going,
+	// which are left out of Files
+	Errors  []Error `json:"errors,omitempty"`
+	Summary Summary `json:"summary"`
+}
+
+type File struct {
+	Action string `json:"action"` // "skip", "create", "update", "delete", "backfill"
+	Source string `json:"source,omitempty"`
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+	// Operation is the planner action the item is executed with
+	Operation planner.Action `json:"operation"`
+	Size      int64          `json:"size"`
+	Checksum  string         `json:"checksum,omitempty"`
+	// ChecksumAlgorithm is the algorithm of Checksum, omitted for CRC64NVME
+	ChecksumAlgorithm string `json:"checksum_algorithm,omitempty"`
+	// Metadata is the user metadata stored with an uploaded object
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Error is a file that could not be compared.
+type Error struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Error  string `json:"error"`
+}
+
+type Summary struct {
+	Skip   int `json:"skip"`
+	Create int `json:"create"`
+	Update int `json:"update"`
+	Delete int `json:"delete"`
+	// Backfill counts the objects S3 is asked to calculate a checksum of
+	Backfill int `json:"backfill"`
+}
+
+// New describes items planned from source to dest.
+func New(source, dest string, items []planner.Item) Plan {
+	plan := Plan{
+		Version:     Version,
+		Source:      source,
+		Destination: dest,
+		Files:       []File{},
+	}
+	sourceType := plan.SourceType()
+
+	for _, item := range items {
+		src, target := Describe(item, sourceType)
+		action := ActionName(item)
+		switch action {
+		case "create":
+			plan.Summary.Create++
+		case "update":
+			plan.Summary.Update++
+		case "delete":
+			plan.Summary.Delete++
+		case "skip":
+			plan.Summary.Skip++
+		case "backfill":
+			plan.Summary.Backfill++
+		}
+		plan.Files = append(plan.Files, File{
+			Action:            action,
+			Source:            src,
+			Target:            target,
+			Reason:            item.Reason,
+			Operation:         item.Action,
+			Size:              item.Size,
+			Checksum:          item.Checksum,
+			ChecksumAlgorithm: item.ChecksumAlgorithm,
+			Metadata:          item.Metadata,
+		})
+	}
+
+	return plan
+}
+
+// AddErrors records the files of errs, which could not be compared.
+func (p *Plan) AddErrors(errs planner.ItemErrors) {
+	p.Errors = append(p.Errors, Errors(p.Source, p.Destination, errs)...)
+}
+
+// Errors returns the files of errs, which could not be compared in the sync
+// from source to destination.
+func Errors(source, destination string, errs planner.ItemErrors) []Error {
+	var result []Error
+	for _, err := range errs {
+		result = append(result, Error{
+			Source: joinPath(source, err.Path),
+			Target: joinPath(destination, err.Path),
+			Error:  err.Error(),
+		})
+	}
+	return result
+}
+
+// SourceType returns the type of the plan's source.
+func (p Plan) SourceType() planner.SourceType {
+	if isS3URI(p.Source) {
+		return planner.SourceTypeS3
+	}
+	return planner.SourceTypeFileSystem
+}
+
+// Items turns the plan back into the items it was written from.
+func (p Plan) Items() ([]planner.Item, error) {
+	items := make([]planner.Item, 0, len(p.Files))
+
+	for _, file := range p.Files {
+		item := planner.Item{
+			Action:            file.Operation,
+			Size:              file.Size,
+			Reason:            file.Reason,
+			Checksum:          file.Checksum,
+			ChecksumAlgorithm: file.ChecksumAlgorithm,
+			Metadata:          file.Metadata,
+		}
+
+		switch file.Operation {
+		case planner.ActionUpload, planner.ActionDownload, planner.ActionCopy, planner.ActionBackfill, planner.ActionSkip:
+			if file.Source == "" {
+				return nil, fmt.Errorf("%s %s has no source", file.Operation, file.Target)
+			}
+		case planner.ActionDelete, planner.ActionDeleteLocal:
+			if file.Source != "" {
+				return nil, fmt.Errorf("%s %s must not have a source", file.Operation, file.Target)
+			}
+		default:
+			return nil, fmt.Errorf("unknown operation %q for %s", file.Operation, file.Target)
+		}
+
+		// Exactly one side is local unless both are S3 objects,
+		// see Describe
+		if isS3URI(file.Source) && isS3URI(file.Target) {
+			item.SourceBucket, item.SourceKey = parseS3Path(file.Source)
+			item.Bucket, item.Key = parseS3Path(file.Target)
+		} else {
+			for _, loc := range []string{file.Source, file.Target} {
+				switch {
+				case loc == "":
+				case isS3URI(loc):
+					item.Bucket, item.Key = parseS3Path(loc)
+				default:
+					item.LocalPath = loc
+				}
+			}
+		}
+
+		if err := validateItem(item); err != nil {
+			return nil, fmt.Errorf("invalid %s of %s: %w", file.Operation, file.Target, err)
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// validateItem checks that item has the locations its action needs.
+func validateItem(item planner.Item) error {
+	needS3 := item.Action != planner.ActionDeleteLocal
+	needLocal := item.Action == planner.ActionUpload || item.Action == planner.ActionDownload || item.Action == planner.ActionBackfill || item.Action == planner.ActionDeleteLocal
+	needSource := item.Action == planner.ActionCopy
+	needChecksum := item.Action == planner.ActionUpload || item.Action == planner.ActionBackfill
+
+	switch {
+	case needS3 && (item.Bucket == "" || item.Key == ""):
+		return fmt.Errorf("S3 object is missing")
+	case needLocal && item.LocalPath == "":
+		return fmt.Errorf("local path is missing")
+	case needSource && (item.SourceBucket == "" || item.SourceKey == ""):
+		return fmt.Errorf("source object is missing")
+	case needChecksum && item.Checksum == "":
+		// apply re-verifies the local file against it
+		return fmt.Errorf("checksum is missing")
+	}
+	return nil
+}
+
+// Read loads the plan at path.
+func Read(path string) (Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return Plan{}, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	if plan.Version != Version {
+		return Plan{}, fmt.Errorf("unsupported plan version %d, expected %d (plans written by older versions can't be applied)", plan.Version, Version)
+	}
+
+	return plan, nil
+}
+
+// Write saves plan to path.
+func Write(path string, plan Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return nil
+}
+
+// ActionName returns the action of an item as shown in the JSON outputs:
+// create, update, delete, skip or backfill.
+func ActionName(item planner.Item) string {
+	switch item.Action {
+	case planner.ActionUpload, planner.ActionDownload, planner.ActionCopy:
+		return getTransferActionName(item.Reason)
+	case planner.ActionDelete, planner.ActionDeleteLocal:
+		return "delete"
+	case planner.ActionSkip:
+		return "skip"
+	case planner.ActionBackfill:
+		return "backfill"
+	default:
+		return "unknown"
+	}
+}
+
+func getTransferActionName(reason string) string {
+	if reason == "new file" {
+		return "create"
+	}
+	return "update"
+}
+
+// Describe returns the source and target of an item as shown in the JSON outputs.
+// Deletions have no source.
+func Describe(item planner.Item, sourceType planner.SourceType) (source, target string) {
+	s3Path := formatS3Path(item.Bucket, item.Key)
+	localPath := getAbsolutePath(item.LocalPath)
+
+	switch item.Action {
+	case planner.ActionDelete:
+		return "", s3Path
+	case planner.ActionDeleteLocal:
+		return "", localPath
+	}
+
+	switch {
+	case item.SourceBucket != "":
+		return formatS3Path(item.SourceBucket, item.SourceKey), s3Path
+	case sourceType == planner.SourceTypeS3:
+		return s3Path, localPath
+	default:
+		return localPath, s3Path
+	}
+}
+
+func isS3URI(path string) bool {
+	return strings.HasPrefix(path, "s3://")
+}
+
+func parseS3Path(s3Path string) (bucket, key string) {
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(s3Path, "s3://"), "/")
+	return bucket, key
+}
+
+// joinPath returns the location of relPath under base, an S3 URI or a
+// local directory.
+func joinPath(base, relPath string) string {
+	if isS3URI(base) {
+		bucket, prefix := parseS3Path(base)
+		return formatS3Path(bucket, path.Join(prefix, relPath))
+	}
+	return filepath.Join(getAbsolutePath(base), filepath.FromSlash(relPath))
+}
+
+func getAbsolutePath(path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path // fallback to original path
+	}
+	return absPath
+}
+
+func formatS3Path(bucket, key string) string {
+	return fmt.Sprintf("s3://%s/%s", bucket, key)
+}
@@ -0,0 +1,170 @@
+package planfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/planner"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		dest   string
+		items  []planner.Item
+	}{
+		{
+			name:   "filesystem to s3",
+			source: "/src",
+			dest:   "s3://bucket/prefix",
+			items: []planner.Item{
+				{Action: planner.ActionUpload, LocalPath: "/src/new.txt", Bucket: "bucket", Key: "prefix/new.txt", Size: 3, Reason: "new file", Checksum: "SoXXbx67KpE=", Metadata: map[string]string{planner.MetadataChecksum: "SoXXbx67KpE=", planner.MetadataSize: "3", planner.MetadataModTime: "1700000000000000000"}},
+				{Action: planner.ActionUpload, LocalPath: "/src/sha.txt", Bucket: "bucket", Key: "prefix/sha.txt", Size: 14, Reason: "checksum differs", Checksum: "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE=", ChecksumAlgorithm: "SHA256"},
+				{Action: planner.ActionBackfill, LocalPath: "/src/legacy.txt", Bucket: "bucket", Key: "prefix/legacy.txt", Size: 14, Reason: "missing checksum", Checksum: "SoXXbx67KpE="},
+				{Action: planner.ActionSkip, LocalPath: "/src/same.txt", Bucket: "bucket", Key: "prefix/same.txt", Size: 4, Reason: "unchanged"},
+				{Action: planner.ActionDelete, Bucket: "bucket", Key: "prefix/old.txt", Size: 5, Reason: "deleted locally"},
+			},
+		},
+		{
+			name:   "s3 to filesystem",
+			source: "s3://bucket/prefix/",
+			dest:   "/dst",
+			items: []planner.Item{
+				{Action: planner.ActionDownload, LocalPath: "/dst/dir/file.txt", Bucket: "bucket", Key: "prefix/dir/file.txt", Size: 3, Reason: "size differs"},
+				{Action: planner.ActionSkip, LocalPath: "/dst/same.txt", Bucket: "bucket", Key: "prefix/same.txt", Size: 4, Reason: "unchanged"},
+				{Action: planner.ActionDeleteLocal, LocalPath: "/dst/old.txt", Size: 5, Reason: "deleted in source"},
+			},
+		},
+		{
+			name:   "s3 to s3",
+			source: "s3://src/a",
+			dest:   "s3://dst/b",
+			items: []planner.Item{
+				{Action: planner.ActionCopy, SourceBucket: "src", SourceKey: "a/file.txt", Bucket: "dst", Key: "b/file.txt", Size: 3, Reason: "checksum differs"},
+				{Action: planner.ActionSkip, SourceBucket: "src", SourceKey: "a/same.txt", Bucket: "dst", Key: "b/same.txt", Size: 4, Reason: "unchanged"},
+				{Action: planner.ActionDelete, Bucket: "dst", Key: "b/old.txt", Size: 5, Reason: "deleted in source"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "plan.json")
+			if err := Write(path, New(tt.source, tt.dest, tt.items)); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			plan, err := Read(path)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if plan.Source != tt.source || plan.Destination != tt.dest {
+				t.Errorf("Read() source, destination = %q, %q, want %q, %q", plan.Source, plan.Destination, tt.source, tt.dest)
+			}
+
+			got, err := plan.Items()
+			if err != nil {
+				t.Fatalf("Items() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.items) {
+				t.Errorf("Items() = %+v, want %+v", got, tt.items)
+			}
+		})
+	}
+}
+
+func TestNewSummary(t *testing.T) {
+	plan := New("/src", "s3://bucket", []planner.Item{
+		{Action: planner.ActionUpload, LocalPath: "/src/a", Bucket: "bucket", Key: "a", Reason: "new file"},
+		{Action: planner.ActionUpload, LocalPath: "/src/b", Bucket: "bucket", Key: "b", Reason: "checksum differs"},
+		{Action: planner.ActionSkip, LocalPath: "/src/c", Bucket: "bucket", Key: "c", Reason: "unchanged"},
+		{Action: planner.ActionDelete, Bucket: "bucket", Key: "d", Reason: "deleted locally"},
+		{Action: planner.ActionBackfill, LocalPath: "/src/e", Bucket: "bucket", Key: "e", Reason: "missing checksum"},
+	})
+
+	want := Summary{Skip: 1, Create: 1, Update: 1, Delete: 1, Backfill: 1}
+	if plan.Summary != want {
+		t.Errorf("Summary = %+v, want %+v", plan.Summary, want)
+	}
+	if plan.Files[3].Source != "" {
+		t.Errorf("delete has source %q", plan.Files[3].Source)
+	}
+}
+
+func TestAddErrors(t *testing.T) {
+	plan := New("/src", "s3://bucket/prefix", nil)
+	plan.AddErrors(planner.ItemErrors{
+		{Path: "dir/a", Err: os.ErrPermission},
+	})
+
+	want := []Error{{Source: "/src/dir/a", Target: "s3://bucket/prefix/dir/a", Error: "permission denied"}}
+	if !reflect.DeepEqual(plan.Errors, want) {
+		t.Errorf("Errors = %+v, want %+v", plan.Errors, want)
+	}
+}
+
+func TestItemsInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    File
+		wantErr string
+	}{
+		{
+			name:    "unknown operation",
+			file:    File{Operation: "move", Source: "/src/a", Target: "s3://bucket/a"},
+			wantErr: "unknown operation",
+		},
+		{
+			name:    "upload without checksum",
+			file:    File{Operation: planner.ActionUpload, Source: "/src/a", Target: "s3://bucket/a"},
+			wantErr: "checksum is missing",
+		},
+		{
+			name:    "backfill without checksum",
+			file:    File{Operation: planner.ActionBackfill, Source: "/src/a", Target: "s3://bucket/a"},
+			wantErr: "checksum is missing",
+		},
+		{
+			name:    "upload to local path",
+			file:    File{Operation: planner.ActionUpload, Source: "/src/a", Target: "/dst/a", Checksum: "AAAAAAAAAAA="},
+			wantErr: "S3 object is missing",
+		},
+		{
+			name:    "copy from local path",
+			file:    File{Operation: planner.ActionCopy, Source: "/src/a", Target: "s3://bucket/a"},
+			wantErr: "source object is missing",
+		},
+		{
+			name:    "delete with source",
+			file:    File{Operation: planner.ActionDelete, Source: "/src/a", Target: "s3://bucket/a"},
+			wantErr: "must not have a source",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := Plan{Version: Version, Files: []File{tt.file}}
+			_, err := plan.Items()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Items() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadRejectsOtherVersions(t *testing.T) {
+	// Plans written before the plan format was versioned
+	path := filepath.Join(t.TempDir(), "plan.json")
+	data := `{"files":[{"action":"create","source":"/src/a","target":"s3://bucket/a","reason":"new file"}],"summary":{"create":1}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Read(path); err == nil || !strings.Contains(err.Error(), "unsupported plan version 0") {
+		t.Errorf("Read() error = %v, want unsupported plan version", err)
+	}
+}
@@ -0,0 +1,37 @@
+package planner
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// localChecksum returns the checksum of the file at relPath under localBase
+// in algorithm, CRC64NVME if empty. With a cache, unchanged files are not
+// read again. concurrency is passed on to fileChecksum.
+func localChecksum(cache ChecksumCache, localBase string, relPath string, algorithm string, concurrency int) (string, error) {
+	localPath := filepath.Join(localBase, relPath)
+	if cache == nil {
+		return fileChecksum(localPath, algorithm, 0, concurrency)
+	}
+
+	before, err := os.Stat(localPath)
+	if err != nil {
+		return "", err
+	}
+	if checksum, ok := cache.Get(relPath, algorithm, before); ok {
+		return checksum, nil
+	}
+
+	checksum, err := fileChecksum(localPath, algorithm, 0, concurrency)
+	if err != nil {
+		return "", err
+	}
+
+	// A file written to while it was read may not match the checksum
+	after, err := os.Stat(localPath)
+	if err == nil && after.Size() == before.Size() && after.ModTime().Equal(before.ModTime()) {
+		cache.Put(relPath, algorithm, before, checksum)
+	}
+
+	return checksum, nil
+}
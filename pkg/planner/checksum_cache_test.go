@@ -0,0 +1,72 @@
+package planner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mapCache is a ChecksumCache that ignores file info. Checksums in other
+// algorithms than CRC64NVME are stored under "<algorithm>:<path>".
+type mapCache map[string]string
+
+func (c mapCache) Get(path string, algorithm string, info os.FileInfo) (string, bool) {
+	checksum, ok := c[mapCacheKey(path, algorithm)]
+	return checksum, ok
+}
+
+func (c mapCache) Put(path string, algorithm string, info os.FileInfo, checksum string) {
+	c[mapCacheKey(path, algorithm)] = checksum
+}
+
+func mapCacheKey(path string, algorithm string) string {
+	if algorithm == "" {
+		return path
+	}
+	return algorithm + ":" + path
+}
+
+func TestLocalChecksum(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "hello.txt"), []byte("Hello, World!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := localChecksum(nil, dir, "sub/hello.txt", "", 1)
+	if err != nil || got != "SoXXbx67KpE=" {
+		t.Errorf("localChecksum() without cache = %q, %v, want SoXXbx67KpE=", got, err)
+	}
+
+	// Misses are calculated and stored under the relative path
+	cache := mapCache{}
+	got, err = localChecksum(cache, dir, "sub/hello.txt", "", 1)
+	if err != nil || got != "SoXXbx67KpE=" {
+		t.Errorf("localChecksum() on miss = %q, %v, want SoXXbx67KpE=", got, err)
+	}
+	if cache["sub/hello.txt"] != "SoXXbx67KpE=" {
+		t.Errorf("cache = %v, want the calculated checksum stored", cache)
+	}
+
+	// Hits don't read the file
+	cache["sub/hello.txt"] = "cached"
+	got, err = localChecksum(cache, dir, "sub/hello.txt", "", 1)
+	if err != nil || got != "cached" {
+		t.Errorf("localChecksum() on hit = %q, %v, want cached", got, err)
+	}
+
+	// Other algorithms are cached separately
+	got, err = localChecksum(cache, dir, "sub/hello.txt", "SHA256", 1)
+	if err != nil || got != "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE=" {
+		t.Errorf("localChecksum() in SHA256 = %q, %v, want yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE=", got, err)
+	}
+	if cache["SHA256:sub/hello.txt"] != got {
+		t.Errorf("cache = %v, want the SHA256 checksum stored", cache)
+	}
+
+	if _, err := localChecksum(cache, dir, "missing.txt", "", 1); err == nil {
+		t.Error("localChecksum() of a missing file succeeded")
+	}
+}
@@ -0,0 +1,129 @@
+package planner
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/crc64nvme"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+// comparableChecksum returns the checksum obj is compared by, along with the
+// checksum of the local file at relPath under localBase calculated the same
+// way: in the same algorithm, and over the same parts if obj has a composite
+// checksum. The checksum in preferred is used if obj has one. Without a
+// checksum of obj, the local checksum in preferred is returned. Like in
+// ChecksumData, algorithms are empty for CRC64NVME.
+func comparableChecksum(cache ChecksumCache, localBase string, relPath string, obj *s3client.ObjectInfo, preferred string, concurrency int) (algorithm, local, remote string, err error) {
+	algorithm, remote = remoteChecksum(obj, preferred)
+
+	if obj.ChecksumType != s3client.ChecksumTypeComposite {
+		local, err = localChecksum(cache, localBase, relPath, algorithm, concurrency)
+		return algorithm, local, remote, err
+	}
+
+	// An empty checksum never matches
+	if obj.PartSize <= 0 {
+		return algorithm, "", remote, nil
+	}
+	local, err = fileChecksum(filepath.Join(localBase, relPath), algorithm, obj.PartSize, concurrency)
+	return algorithm, local, remote, err
+}
+
+// remoteChecksum returns the checksum obj is compared by and its algorithm,
+// preferring the checksum in preferred. Without a checksum of obj, the
+// algorithm is preferred.
+func remoteChecksum(obj *s3client.ObjectInfo, preferred string) (algorithm, remote string) {
+	algorithm, remote = obj.PreferredChecksum()
+	if checksum := obj.ChecksumIn(s3ChecksumAlgorithm(preferred)); checksum != "" {
+		algorithm, remote = preferred, checksum
+	}
+	if remote == "" {
+		algorithm = preferred
+	}
+	return checksumAlgorithm(algorithm), remote
+}
+
+// checksumAlgorithm returns algorithm named like in ChecksumData, empty for
+// CRC64NVME.
+func checksumAlgorithm(algorithm string) string {
+	if algorithm == s3client.ChecksumAlgorithmCRC64NVME {
+		return ""
+	}
+	return algorithm
+}
+
+// s3ChecksumAlgorithm returns the name S3 reports algorithm by.
+func s3ChecksumAlgorithm(algorithm string) string {
+	if algorithm == "" {
+		return s3client.ChecksumAlgorithmCRC64NVME
+	}
+	return algorithm
+}
+
+// commonChecksums returns the checksums of a and b in an algorithm both of
+// them have, preferring the one a is compared by. If there is none, the
+// checksum of b is empty, which never matches. Like in ChecksumData,
+// algorithm is empty for CRC64NVME.
+func commonChecksums(a, b *s3client.ObjectInfo) (algorithm, checksumA, checksumB string) {
+	algorithm, checksumA = a.PreferredChecksum()
+	checksumB = b.ChecksumIn(algorithm)
+	if checksumB == "" {
+		if other, checksum := b.PreferredChecksum(); a.ChecksumIn(other) != "" {
+			algorithm, checksumA, checksumB = other, a.ChecksumIn(other), checksum
+		}
+	}
+	return checksumAlgorithm(algorithm), checksumA, checksumB
+}
+
+// fileChecksum returns the checksum of the file at localPath in algorithm,
+// CRC64NVME if empty. With a partSize, it returns the composite checksum of
+// the file uploaded in parts of partSize bytes instead. Large files are
+// hashed by up to concurrency goroutines where the algorithm allows it.
+func fileChecksum(localPath string, algorithm string, partSize int64, concurrency int) (string, error) {
+	crc64 := algorithm == "" || algorithm == s3client.ChecksumAlgorithmCRC64NVME
+	if crc64 && partSize == 0 {
+		return calculateFileChecksum(localPath, concurrency)
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if partSize == 0 {
+		h, err := s3client.NewChecksumHash(algorithm)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		return s3client.EncodeChecksum(h), nil
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if crc64 {
+		parts, err := crc64nvme.Parts(f, info.Size(), partSize, concurrency)
+		if err != nil {
+			return "", err
+		}
+		return crc64nvme.Composite(parts), nil
+	}
+
+	// A different part count shows in the "-N" suffix
+	h, err := s3client.NewCompositeHash(algorithm, partSize)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return h.Checksum(), nil
+}
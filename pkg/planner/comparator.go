@@ -0,0 +1,273 @@
+package planner
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+// Decision is the outcome of comparing the listings of an item.
+type Decision int
+
+const (
+	// DecisionUndecided fetches what the Comparator's Needs declare and
+	// leaves the decision to Compare.
+	DecisionUndecided Decision = iota
+	DecisionUnchanged
+	DecisionChanged
+)
+
+// Needs declares what Phase 2 fetches for the items a Comparator can't
+// decide from the listings. Checksums of both sides are calculated in the
+// same algorithm: the one the object has, preferring Options.ChecksumAlgorithm.
+type Needs struct {
+	// LocalChecksum calculates the checksum of the local file. In S3 to S3
+	// syncs it is ignored.
+	LocalChecksum bool
+	// RemoteChecksum looks up the checksum of the objects with HeadObject.
+	RemoteChecksum bool
+	// Metadata looks up the user metadata of the objects with HeadObject.
+	Metadata bool
+	// SourceMetadata compares local files and objects in CRC64NVME, taking
+	// the checksums from the source metadata stored with objects where it
+	// can be trusted. See SourceMetadataComparator. In S3 to S3 syncs it is
+	// ignored.
+	SourceMetadata bool
+}
+
+// Comparator decides whether the destination of an item is up to date with
+// its source. Files of different sizes always differ, so only items of the
+// same size on both sides are compared.
+type Comparator interface {
+	// Needs returns what Phase 2 fetches for the items CompareListings
+	// leaves undecided.
+	Needs() Needs
+	// CompareListings decides from the listings of both sides whether dest
+	// is up to date with source, and returns the reason recorded in the
+	// plan.
+	CompareListings(source, dest ItemMetadata) (Decision, string)
+	// Compare decides on the items CompareListings left undecided, with
+	// Checksum and Metadata holding what Needs asked for, and returns the
+	// reason recorded in the plan.
+	Compare(source, dest ItemMetadata) (changed bool, reason string)
+}
+
+// StrictComparator is the default Comparator. It compares the checksums of
+// all files of the same size, treating a missing checksum on either side as
+// changed.
+type StrictComparator struct{}
+
+func (StrictComparator) Needs() Needs {
+	return Needs{LocalChecksum: true, RemoteChecksum: true}
+}
+
+// CompareListings leaves every item to Compare. Listings don't carry
+// checksums that could be compared.
+func (StrictComparator) CompareListings(source, dest ItemMetadata) (Decision, string) {
+	return DecisionUndecided, ""
+}
+
+func (StrictComparator) Compare(source, dest ItemMetadata) (bool, string) {
+	// A missing checksum on either side can't prove the files are identical
+	if source.Checksum == "" || source.Checksum != dest.Checksum {
+		return true, "checksum differs"
+	}
+	return false, "unchanged"
+}
+
+// SizeOnlyComparator treats files of the same size as unchanged without
+// comparing their checksums. Used for endpoints that don't return checksums
+// and for trees whose files never change in place.
+type SizeOnlyComparator struct{}
+
+func (SizeOnlyComparator) Needs() Needs {
+	return Needs{}
+}
+
+func (SizeOnlyComparator) CompareListings(source, dest ItemMetadata) (Decision, string) {
+	return DecisionUnchanged, "same size"
+}
+
+func (SizeOnlyComparator) Compare(source, dest ItemMetadata) (bool, string) {
+	return false, "same size"
+}
+
+// TimestampComparator treats files of the same size as unchanged if their
+// modification times match, and leaves the others to Comparator.
+type TimestampComparator struct {
+	// Match is called with the modification times of the source and the
+	// destination of an item, e.g. SameTimestamp or NotOlder.
+	Match func(source, dest time.Time) bool
+	// Comparator compares the items whose modification times don't match,
+	// StrictComparator if nil.
+	Comparator Comparator
+}
+
+func (c TimestampComparator) Needs() Needs {
+	return c.next().Needs()
+}
+
+func (c TimestampComparator) CompareListings(source, dest ItemMetadata) (Decision, string) {
+	if !source.ModTime.IsZero() && !dest.ModTime.IsZero() && c.Match(source.ModTime, dest.ModTime) {
+		return DecisionUnchanged, "same size and timestamp"
+	}
+	return c.next().CompareListings(source, dest)
+}
+
+func (c TimestampComparator) Compare(source, dest ItemMetadata) (bool, string) {
+	return c.next().Compare(source, dest)
+}
+
+func (c TimestampComparator) next() Comparator {
+	if c.Comparator == nil {
+		return StrictComparator{}
+	}
+	return c.Comparator
+}
+
+// SameTimestamp reports whether source and dest were modified in the same
+// second. S3 reports modification times in whole seconds.
+func SameTimestamp(source, dest time.Time) bool {
+	return source.Truncate(time.Second).Equal(dest.Truncate(time.Second))
+}
+
+// NotOlder reports whether dest was modified in the same second as source
+// or later. S3 sets the modification time of an object when it is written,
+// so an object not older than its source was written from its current
+// content.
+func NotOlder(source, dest time.Time) bool {
+	return !dest.Truncate(time.Second).Before(source.Truncate(time.Second))
+}
+
+// comparator returns the Comparator of opts, StrictComparator if unset.
+func (opts Options) comparator() Comparator {
+	if opts.Comparator == nil {
+		return StrictComparator{}
+	}
+	return opts.Comparator
+}
+
+// ComparePhase1 lets c decide on the items that still need a checksum
+// comparison from the listings of source and dest, and moves the decided
+// ones to Compared.
+func ComparePhase1(result Phase1Result, source []ItemMetadata, dest []ItemMetadata, c Comparator) Phase1Result {
+	sourceMap := metadataByPath(source)
+	destMap := metadataByPath(dest)
+
+	needChecksum := []ItemRef{}
+	for _, ref := range result.NeedChecksum {
+		decision, reason := c.CompareListings(sourceMap[ref.Path], destMap[ref.Path])
+		if decision == DecisionUndecided {
+			needChecksum = append(needChecksum, ref)
+			continue
+		}
+		result.Compared = append(result.Compared, ComparedRef{ItemRef: ref, Changed: decision != DecisionUnchanged, Reason: reason})
+	}
+	result.NeedChecksum = needChecksum
+	return result
+}
+
+// ComparePhase2 lets c decide on the items that still need a checksum
+// comparison with what Phase 2 fetched for them, and moves them to
+// Compared. Items without Phase 2 data are left alone.
+func ComparePhase2(result Phase1Result, checksums []ChecksumData, source []ItemMetadata, dest []ItemMetadata, c Comparator) Phase1Result {
+	sourceMap := metadataByPath(source)
+	destMap := metadataByPath(dest)
+	checksumMap := make(map[string]ChecksumData, len(checksums))
+	for _, cs := range checksums {
+		checksumMap[cs.ItemRef.Path] = cs
+	}
+
+	needChecksum := []ItemRef{}
+	for _, ref := range result.NeedChecksum {
+		cs, ok := checksumMap[ref.Path]
+		if !ok {
+			needChecksum = append(needChecksum, ref)
+			continue
+		}
+
+		sourceItem, destItem := sourceMap[ref.Path], destMap[ref.Path]
+		sourceItem.Checksum, sourceItem.Metadata = cs.SourceChecksum, cs.SourceMetadata
+		destItem.Checksum, destItem.Metadata = cs.DestChecksum, cs.DestMetadata
+
+		changed, reason := c.Compare(sourceItem, destItem)
+		result.Compared = append(result.Compared, ComparedRef{ItemRef: ref, Changed: changed, Reason: reason})
+	}
+	result.NeedChecksum = needChecksum
+	return result
+}
+
+func metadataByPath(items []ItemMetadata) map[string]ItemMetadata {
+	m := make(map[string]ItemMetadata, len(items))
+	for _, item := range items {
+		m[item.Path] = item
+	}
+	return m
+}
+
+// fetchLocalRemote fetches what needs asks for of an item stored both as the
+// local file at relPath under localBase and as the object key in bucket.
+// The local file is hashed in preferred while the object is looked up, and
+// only hashed again if the object turns out to be compared differently.
+// etag is the ETag the object was listed with, if known.
+// Like in ChecksumData, algorithm is empty for CRC64NVME.
+func fetchLocalRemote(ctx context.Context, client s3client.Client, pools *phase2Pools, needs Needs, cache ChecksumCache, localBase string, relPath string, bucket string, key string, etag string, preferred string) (algorithm, local, remote string, metadata map[string]string, err error) {
+	algorithm = preferred
+
+	// Source metadata decides whether the file is read at all
+	var waitLocal func() (string, error)
+	if needs.LocalChecksum && !needs.SourceMetadata {
+		waitLocal = pools.hashAsync(ctx, func() (string, error) {
+			return localChecksum(cache, localBase, relPath, preferred, pools.fileConcurrency)
+		})
+	}
+
+	var objInfo *s3client.ObjectInfo
+	if needs.SourceMetadata {
+		objInfo = cachedObject(cache, relPath, etag)
+	}
+	if objInfo == nil && (needs.RemoteChecksum || needs.Metadata || needs.SourceMetadata) {
+		objInfo, err = pools.headObject(ctx, client, &s3client.HeadObjectRequest{
+			Bucket: bucket,
+			Key:    key,
+		})
+		if err != nil {
+			return "", "", "", nil, fmt.Errorf("failed to head object %s: %w", key, err)
+		}
+		if needs.SourceMetadata {
+			cacheObject(cache, relPath, objInfo)
+		}
+	}
+	if objInfo != nil {
+		metadata = objInfo.Metadata
+	}
+
+	switch {
+	case needs.SourceMetadata:
+		algorithm = ""
+		local, remote, err = sourceMetadataChecksums(ctx, pools, cache, localBase, relPath, objInfo)
+	case needs.LocalChecksum && needs.RemoteChecksum:
+		algorithm, remote = remoteChecksum(objInfo, preferred)
+		if algorithm == preferred && objInfo.ChecksumType != s3client.ChecksumTypeComposite {
+			local, err = waitLocal()
+			break
+		}
+		err = pools.hash(ctx, func() error {
+			var err error
+			algorithm, local, remote, err = comparableChecksum(cache, localBase, relPath, objInfo, preferred, pools.fileConcurrency)
+			return err
+		})
+	case needs.RemoteChecksum:
+		algorithm, remote = remoteChecksum(objInfo, preferred)
+	case needs.LocalChecksum:
+		local, err = waitLocal()
+	}
+	if err != nil {
+		return "", "", "", nil, fmt.Errorf("failed to calculate checksum for %s: %w", filepath.Join(localBase, relPath), err)
+	}
+
+	return algorithm, local, remote, metadata, nil
+}
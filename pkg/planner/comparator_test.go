@@ -0,0 +1,252 @@
+package planner
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+// extComparator treats files by extension without fetching anything.
+type extComparator struct{}
+
+func (extComparator) Needs() Needs { return Needs{} }
+
+func (extComparator) CompareListings(source, dest ItemMetadata) (Decision, string) {
+	if path.Ext(source.Path) == ".map" {
+		return DecisionChanged, "source map"
+	}
+	return DecisionUnchanged, "same size"
+}
+
+func (extComparator) Compare(source, dest ItemMetadata) (bool, string) {
+	panic("Compare called for an item decided from the listings")
+}
+
+// metadataComparator compares the local checksum with the one stored in the
+// object's metadata.
+type metadataComparator struct{}
+
+func (metadataComparator) Needs() Needs { return Needs{LocalChecksum: true, Metadata: true} }
+
+func (metadataComparator) CompareListings(source, dest ItemMetadata) (Decision, string) {
+	return DecisionUndecided, ""
+}
+
+func (metadataComparator) Compare(source, dest ItemMetadata) (bool, string) {
+	if source.Checksum != dest.Metadata["source-checksum"] {
+		return true, "stored checksum differs"
+	}
+	return false, "stored checksum matches"
+}
+
+func TestStrictComparator(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		dest        string
+		wantChanged bool
+		wantReason  string
+	}{
+		{name: "same", source: "SoXXbx67KpE=", dest: "SoXXbx67KpE=", wantReason: "unchanged"},
+		{name: "different", source: "SoXXbx67KpE=", dest: "CXIbLYbJFB0=", wantChanged: true, wantReason: "checksum differs"},
+		{name: "missing on both sides", wantChanged: true, wantReason: "checksum differs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, reason := StrictComparator{}.Compare(ItemMetadata{Checksum: tt.source}, ItemMetadata{Checksum: tt.dest})
+			if changed != tt.wantChanged || reason != tt.wantReason {
+				t.Errorf("Compare() = %v, %q, want %v, %q", changed, reason, tt.wantChanged, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestTimestampComparator(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	source := []ItemMetadata{
+		{Path: "same.txt", Size: 100, ModTime: modTime.Add(300 * time.Millisecond)},
+		{Path: "newer.txt", Size: 100, ModTime: modTime},
+		{Path: "older.txt", Size: 100, ModTime: modTime},
+		{Path: "unknown.txt", Size: 100, ModTime: modTime},
+	}
+	dest := []ItemMetadata{
+		{Path: "same.txt", Size: 100, ModTime: modTime},
+		{Path: "newer.txt", Size: 100, ModTime: modTime.Add(time.Hour)},
+		{Path: "older.txt", Size: 100, ModTime: modTime.Add(-time.Hour)},
+		{Path: "unknown.txt", Size: 100},
+	}
+	phase1 := Phase1Compare(source, dest, false)
+
+	tests := []struct {
+		name       string
+		comparator TimestampComparator
+		want       []ComparedRef
+	}{
+		{
+			name:       "same timestamp",
+			comparator: TimestampComparator{Match: SameTimestamp},
+			want:       []ComparedRef{{ItemRef: ItemRef{Path: "same.txt", Size: 100}, Reason: "same size and timestamp"}},
+		},
+		{
+			name:       "not older",
+			comparator: TimestampComparator{Match: NotOlder},
+			want: []ComparedRef{
+				{ItemRef: ItemRef{Path: "newer.txt", Size: 100}, Reason: "same size and timestamp"},
+				{ItemRef: ItemRef{Path: "same.txt", Size: 100}, Reason: "same size and timestamp"},
+			},
+		},
+		{
+			name:       "size only for the others",
+			comparator: TimestampComparator{Match: SameTimestamp, Comparator: SizeOnlyComparator{}},
+			want: []ComparedRef{
+				{ItemRef: ItemRef{Path: "newer.txt", Size: 100}, Reason: "same size"},
+				{ItemRef: ItemRef{Path: "older.txt", Size: 100}, Reason: "same size"},
+				{ItemRef: ItemRef{Path: "same.txt", Size: 100}, Reason: "same size and timestamp"},
+				{ItemRef: ItemRef{Path: "unknown.txt", Size: 100}, Reason: "same size"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ComparePhase1(phase1, source, dest, tt.comparator)
+			if !reflect.DeepEqual(got.Compared, tt.want) {
+				t.Errorf("Compared = %v, want %v", got.Compared, tt.want)
+			}
+			// Everything else is still compared by checksum
+			if len(got.NeedChecksum)+len(got.Compared) != len(source) {
+				t.Errorf("NeedChecksum = %v, want the remaining items", got.NeedChecksum)
+			}
+		})
+	}
+}
+
+func TestComparePhase(t *testing.T) {
+	source := []ItemMetadata{
+		{Path: "app.js", Size: 10},
+		{Path: "app.js.map", Size: 20},
+	}
+	dest := []ItemMetadata{
+		{Path: "app.js", Size: 10},
+		{Path: "app.js.map", Size: 20},
+	}
+	result := Phase1Result{
+		NeedChecksum: []ItemRef{{Path: "app.js", Size: 10}, {Path: "app.js.map", Size: 20}},
+	}
+
+	got := ComparePhase1(result, source, dest, extComparator{})
+	want := Phase1Result{
+		NeedChecksum: []ItemRef{},
+		Compared: []ComparedRef{
+			{ItemRef: ItemRef{Path: "app.js", Size: 10}, Reason: "same size"},
+			{ItemRef: ItemRef{Path: "app.js.map", Size: 20}, Changed: true, Reason: "source map"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ComparePhase1() = %+v, want %+v", got, want)
+	}
+
+	// Strict comparison leaves everything to Phase 2
+	got = ComparePhase1(result, source, dest, StrictComparator{})
+	if !reflect.DeepEqual(got, result) {
+		t.Errorf("ComparePhase1() = %+v, want %+v", got, result)
+	}
+
+	checksums := []ChecksumData{
+		{ItemRef: ItemRef{Path: "app.js", Size: 10}, SourceChecksum: "SoXXbx67KpE=", DestMetadata: map[string]string{"source-checksum": "SoXXbx67KpE="}},
+	}
+	got = ComparePhase2(result, checksums, source, dest, metadataComparator{})
+	want = Phase1Result{
+		// Items without Phase 2 data are left alone
+		NeedChecksum: []ItemRef{{Path: "app.js.map", Size: 20}},
+		Compared: []ComparedRef{
+			{ItemRef: ItemRef{Path: "app.js", Size: 10}, Reason: "stored checksum matches"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ComparePhase2() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFSToS3Planner_PlanComparator(t *testing.T) {
+	localBase := t.TempDir()
+	files := map[string]string{
+		"app.js":     "Hello, World!\n",
+		"app.js.map": "Hello, World?\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(localBase, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var heads atomic.Int32
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			return []s3client.ItemMetadata{
+				{Path: "app.js", Size: 14},
+				{Path: "app.js.map", Size: 14},
+			}, nil
+		},
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			heads.Add(1)
+			// Uploaded by another tool, which recorded the checksum of its source
+			return &s3client.ObjectInfo{Size: 14, Metadata: map[string]string{"source-checksum": "SoXXbx67KpE="}}, nil
+		},
+	}
+
+	tests := []struct {
+		name       string
+		comparator Comparator
+		wantHeads  int32
+		want       []Item
+	}{
+		{
+			name:       "decided from listings",
+			comparator: extComparator{},
+			wantHeads:  0,
+			want: []Item{
+				{Action: ActionSkip, LocalPath: filepath.Join(localBase, "app.js"), Bucket: "test-bucket", Key: "app.js", Size: 14, Reason: "same size"},
+				{Action: ActionUpload, LocalPath: filepath.Join(localBase, "app.js.map"), Bucket: "test-bucket", Key: "app.js.map", Size: 14, Reason: "source map"},
+			},
+		},
+		{
+			name:       "decided from metadata",
+			comparator: metadataComparator{},
+			wantHeads:  2,
+			want: []Item{
+				{Action: ActionSkip, LocalPath: filepath.Join(localBase, "app.js"), Bucket: "test-bucket", Key: "app.js", Size: 14, Reason: "stored checksum matches"},
+				{Action: ActionUpload, LocalPath: filepath.Join(localBase, "app.js.map"), Bucket: "test-bucket", Key: "app.js.map", Size: 14, Reason: "stored checksum differs", Checksum: "CXIbLYbJFB0="},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			heads.Store(0)
+			p := NewFSToS3Planner(client, &mockLogger{})
+			got, err := p.Plan(context.Background(),
+				Source{Type: SourceTypeFileSystem, Path: localBase},
+				Destination{Type: DestTypeS3, Path: "s3://test-bucket"},
+				Options{Comparator: tt.comparator, BackfillChecksums: true},
+			)
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+			if got := heads.Load(); got != tt.wantHeads {
+				t.Errorf("HeadObject called %d times, want %d", got, tt.wantHeads)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Plan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,22 +2,18 @@ package planner
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
-	"hash/crc64"
-	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
 
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/crc64nvme"
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/logger"
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
 )
 
-// CRC64NVME polynomial as per AWS S3 specification
-var crc64NVMETable = crc64.MakeTable(0x9a6c9329ac4bc9b5)
-
 type FSToS3Planner struct {
 	client s3client.Client
 	logger logger.Logger
@@ -31,194 +27,162 @@ func NewFSToS3Planner(client s3client.Client, logger logger.Logger) *FSToS3Plann
 }
 
 func (p *FSToS3Planner) Plan(ctx context.Context, source Source, dest Destination, opts Options) ([]Item, error) {
+	return collectPlan(func(items chan<- Item) error {
+		return p.PlanStream(ctx, source, dest, opts, items)
+	})
+}
+
+func (p *FSToS3Planner) PlanStream(ctx context.Context, source Source, dest Destination, opts Options, items chan<- Item) error {
+	defer close(items)
+
 	if source.Type != SourceTypeFileSystem {
-		return nil, fmt.Errorf("source must be filesystem, got %s", source.Type)
+		return fmt.Errorf("source must be filesystem, got %s", source.Type)
 	}
 	if dest.Type != DestTypeS3 {
-		return nil, fmt.Errorf("destination must be s3, got %s", dest.Type)
+		return fmt.Errorf("destination must be s3, got %s", dest.Type)
 	}
 
 	bucket, prefix, err := parseS3URI(dest.Path)
 	if err != nil {
-		return nil, fmt.Errorf("invalid S3 URI: %w", err)
-	}
-
-	localFiles, err := p.gatherLocalFiles(source.Path, opts.Excludes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to gather local files: %w", err)
-	}
-
-	s3ClientObjects, err := p.client.ListObjects(ctx, &s3client.ListObjectsRequest{
-		Bucket: bucket,
-		Prefix: prefix,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to list S3 objects: %w", err)
+		return fmt.Errorf("invalid S3 URI: %w", err)
 	}
 
-	s3Objects := []ItemMetadata{}
-	for _, obj := range s3ClientObjects {
-		// Apply exclude patterns to S3 objects
-		excluded, err := IsExcluded(obj.Path, opts.Excludes)
-		if err != nil {
-			return nil, fmt.Errorf("failed to check exclude pattern for %s: %w", obj.Path, err)
-		}
-		if excluded {
-			continue
-		}
-
-		s3Objects = append(s3Objects, ItemMetadata{
-			Path:     obj.Path,
-			Size:     obj.Size,
-			ModTime:  obj.ModTime,
-			Checksum: obj.Checksum,
-		})
-	}
+	return streamPlan(ctx,
+		localListing(source.Path, opts.Filters, opts.KeepGoing),
+		s3Listing(ctx, p.client, bucket, prefix, opts.Filters),
+		func(ctx context.Context, localFiles, s3Objects []ItemMetadata) ([]Item, error) {
+			return p.planChunk(ctx, localFiles, s3Objects, source.Path, bucket, prefix, opts)
+		},
+		items)
+}
 
+// planChunk plans the local files and objects of a chunk of paths.
+func (p *FSToS3Planner) planChunk(ctx context.Context, localFiles []ItemMetadata, s3Objects []ItemMetadata, localBase string, bucket string, prefix string, opts Options) ([]Item, error) {
 	phase1Result := Phase1Compare(localFiles, s3Objects, opts.DeleteEnabled)
+	phase1Result = ComparePhase1(phase1Result, localFiles, s3Objects, opts.comparator())
+	phase1Result = skipCompleted(phase1Result, opts.Journal, ActionUpload, uploadItem(localBase, bucket, prefix))
 
-	checksums, err := p.Phase2CollectChecksums(ctx, phase1Result.NeedChecksum, source.Path, bucket, prefix)
+	checksums, err := p.phase2CollectChecksums(ctx, phase1Result.NeedChecksum, etags(s3Objects), localBase, bucket, prefix, opts)
+	// Items that could not be compared are left out of the plan
+	itemErrs, err := splitItemErrors(err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to collect checksums: %w", err)
 	}
-
-	items := Phase3GeneratePlan(phase1Result, checksums, source.Path, bucket, prefix)
-
-	// Calculate checksums for upload items
+	phase1Result = ComparePhase2(phase1Result, checksums, localFiles, s3Objects, opts.comparator())
+
+	items := Phase3GeneratePlan(phase1Result, checksums, localBase, bucket, prefix)
+
+	// Changed files were hashed in phase 2 already, unless they were compared
+	// by another algorithm or a composite checksum. New and resized files are
+	// hashed while they are uploaded, unless the plan has to pin them or
+	// record their source metadata.
+	algorithm := checksumAlgorithm(opts.ChecksumAlgorithm)
+	needs := opts.comparator().Needs()
+	sourceChecksums := make(map[string]string, len(checksums))
+	missingChecksums := make(map[string]bool)
+	localMetadata := make(map[string]map[string]string)
+	for _, cs := range checksums {
+		if cs.LocalMetadata != nil {
+			localMetadata[cs.ItemRef.Path] = cs.LocalMetadata
+		}
+		if cs.Algorithm == algorithm && !s3client.IsCompositeChecksum(cs.SourceChecksum) {
+			sourceChecksums[cs.ItemRef.Path] = cs.SourceChecksum
+			// Without a remote checksum the local one is in the algorithm
+			// S3 is asked to calculate
+			missingChecksums[cs.ItemRef.Path] = needs.RemoteChecksum && cs.DestChecksum == ""
+		}
+	}
 	for i, item := range items {
-		if item.Action == ActionUpload {
-			checksum, err := calculateFileChecksum(item.LocalPath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to calculate checksum for %s: %w", item.LocalPath, err)
+		if item.Action != ActionUpload {
+			continue
+		}
+		items[i].ChecksumAlgorithm = algorithm
+		relPath, err := filepath.Rel(localBase, item.LocalPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to calculate checksum for %s: %w", item.LocalPath, err)
+		}
+		relPath = filepath.ToSlash(relPath)
+		if needs.SourceMetadata {
+			// The executor stores the metadata as planned instead of
+			// reading the file again
+			metadata, ok := localMetadata[relPath]
+			if !ok {
+				if metadata, err = localSourceMetadata(opts.ChecksumCache, localBase, relPath); err != nil {
+					return nil, fmt.Errorf("failed to calculate checksum for %s: %w", item.LocalPath, err)
+				}
 			}
+			items[i].Metadata = metadata
+			if algorithm == "" {
+				sourceChecksums[relPath] = metadata[MetadataChecksum]
+			}
+		}
+		if checksum, ok := sourceChecksums[relPath]; ok && checksum != "" {
 			items[i].Checksum = checksum
+			if opts.BackfillChecksums && missingChecksums[relPath] {
+				items[i].Action = ActionBackfill
+				items[i].Reason = "missing checksum"
+			}
+			continue
 		}
+		if !opts.PlanChecksums {
+			continue
+		}
+		checksum, err := localChecksum(opts.ChecksumCache, localBase, relPath, algorithm, runtime.GOMAXPROCS(0))
+		if err != nil {
+			return nil, fmt.Errorf("failed to calculate checksum for %s: %w", item.LocalPath, err)
+		}
+		items[i].Checksum = checksum
 	}
 
-	return items, nil
+	return items, itemErrs.orNil()
 }
 
-func (p *FSToS3Planner) gatherLocalFiles(basePath string, excludes []string) ([]ItemMetadata, error) {
-	var items []ItemMetadata
-
-	err := filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+func (p *FSToS3Planner) Phase2CollectChecksums(ctx context.Context, items []ItemRef, localBase string, bucket string, prefix string) ([]ChecksumData, error) {
+	return p.phase2CollectChecksums(ctx, items, nil, localBase, bucket, prefix, Options{})
+}
 
-		if info.IsDir() {
-			return nil
+// phase2CollectChecksums collects the checksums of items. etags holds the
+// ETags the objects were listed with by path.
+func (p *FSToS3Planner) phase2CollectChecksums(ctx context.Context, items []ItemRef, etags map[string]string, localBase string, bucket string, prefix string, opts Options) ([]ChecksumData, error) {
+	needs := opts.comparator().Needs()
+	return collectChecksums(ctx, items, opts, func(ctx context.Context, pools *phase2Pools, item ItemRef) (ChecksumData, error) {
+		// Stat first, so that a file modified while it is read doesn't get
+		// a newer mtime recorded than its checksum
+		var info os.FileInfo
+		if needs.SourceMetadata {
+			var err error
+			if info, err = os.Stat(filepath.Join(localBase, item.Path)); err != nil {
+				return ChecksumData{}, fmt.Errorf("failed to calculate checksum for %s: %w", filepath.Join(localBase, item.Path), err)
+			}
 		}
 
-		relPath, err := filepath.Rel(basePath, path)
+		s3Key := path.Join(prefix, item.Path)
+		algorithm, sourceChecksum, destChecksum, metadata, err := fetchLocalRemote(ctx, p.client, pools, needs, opts.ChecksumCache, localBase, item.Path, bucket, s3Key, etags[item.Path], checksumAlgorithm(opts.ChecksumAlgorithm))
 		if err != nil {
-			return err
+			return ChecksumData{}, err
 		}
 
-		relPath = filepath.ToSlash(relPath)
-
-		excluded, err := IsExcluded(relPath, excludes)
-		if err != nil {
-			return err
+		data := ChecksumData{
+			ItemRef:        item,
+			SourceChecksum: sourceChecksum,
+			DestChecksum:   destChecksum,
+			Algorithm:      algorithm,
+			DestMetadata:   metadata,
 		}
-		if excluded {
-			return nil
+		if info != nil {
+			data.LocalMetadata = SourceMetadata(sourceChecksum, info)
 		}
-
-		items = append(items, ItemMetadata{
-			Path:    relPath,
-			Size:    info.Size(),
-			ModTime: info.ModTime(),
-		})
-
-		return nil
+		return data, nil
 	})
-
-	return items, err
 }
 
-func (p *FSToS3Planner) Phase2CollectChecksums(ctx context.Context, items []ItemRef, localBase string, bucket string, prefix string) ([]ChecksumData, error) {
-	if len(items) == 0 {
-		return nil, nil
-	}
-
-	// ワーカー数は並列度設定かCPU数の2倍
-	workerCount := 32 // TODO: make configurable
-	if len(items) < workerCount {
-		workerCount = len(items)
-	}
-
-	type checksumTask struct {
-		index int
-		item  ItemRef
-	}
-
-	type checksumResult struct {
-		index int
-		data  ChecksumData
-		err   error
+// etags returns the ETags of objects by path.
+func etags(objects []ItemMetadata) map[string]string {
+	m := make(map[string]string, len(objects))
+	for _, obj := range objects {
+		m[obj.Path] = obj.ETag
 	}
-
-	// チャンネルの作成
-	tasks := make(chan checksumTask, len(items))
-	results := make(chan checksumResult, len(items))
-
-	// ワーカーの起動
-	for i := 0; i < workerCount; i++ {
-		go func() {
-			for task := range tasks {
-				localPath := filepath.Join(localBase, task.item.Path)
-				sourceChecksum, err := calculateFileChecksum(localPath)
-				if err != nil {
-					results <- checksumResult{
-						index: task.index,
-						err:   fmt.Errorf("failed to calculate checksum for %s: %w", localPath, err),
-					}
-					continue
-				}
-
-				s3Key := path.Join(prefix, task.item.Path)
-				objInfo, err := p.client.HeadObject(ctx, &s3client.HeadObjectRequest{
-					Bucket: bucket,
-					Key:    s3Key,
-				})
-				if err != nil {
-					results <- checksumResult{
-						index: task.index,
-						err:   fmt.Errorf("failed to head object %s: %w", s3Key, err),
-					}
-					continue
-				}
-
-				results <- checksumResult{
-					index: task.index,
-					data: ChecksumData{
-						ItemRef:        task.item,
-						SourceChecksum: sourceChecksum,
-						DestChecksum:   objInfo.Checksum,
-					},
-				}
-			}
-		}()
-	}
-
-	// タスクの送信
-	for i, item := range items {
-		tasks <- checksumTask{index: i, item: item}
-	}
-	close(tasks)
-
-	// 結果の収集（順序を保持）
-	checksums := make([]ChecksumData, len(items))
-	for i := 0; i < len(items); i++ {
-		result := <-results
-		if result.err != nil {
-			return nil, result.err
-		}
-		checksums[result.index] = result.data
-	}
-
-	return checksums, nil
+	return m
 }
 
 func parseS3URI(uri string) (bucket, prefix string, err error) {
@@ -247,17 +211,6 @@ func parseS3URI(uri string) (bucket, prefix string, err error) {
 	return bucket, prefix, nil
 }
 
-func calculateFileChecksum(path string) (string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	hash := crc64.New(crc64NVMETable)
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
-	}
-
-	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+func calculateFileChecksum(path string, concurrency int) (string, error) {
+	return crc64nvme.FileWithConcurrency(path, concurrency)
 }
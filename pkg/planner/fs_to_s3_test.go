@@ -1,8 +1,13 @@
 package planner
 
 import (
+	"context"
+	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
 )
 
 func TestCalculateFileChecksum(t *testing.T) {
@@ -47,7 +52,7 @@ func TestCalculateFileChecksum(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			path := filepath.Join("testdata", tt.filename)
-			got, err := calculateFileChecksum(path)
+			got, err := calculateFileChecksum(path, 1)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("calculateFileChecksum() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -169,3 +174,319 @@ func TestParseS3URI(t *testing.T) {
 		})
 	}
 }
+
+func TestFSToS3Planner_PlanChecksums(t *testing.T) {
+	localBase := t.TempDir()
+	files := map[string]string{
+		"same.txt":    "Hello, World!\n",
+		"changed.txt": "Hello, World?\n",
+		"resized.txt": "short",
+		"new.txt":     "The quick brown fox jumps over the lazy dog\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(localBase, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			return []s3client.ItemMetadata{
+				{Path: "same.txt", Size: 14},
+				{Path: "changed.txt", Size: 14},
+				{Path: "resized.txt", Size: 100},
+			}, nil
+		},
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			// "SoXXbx67KpE=" is the CRC64NVME of "Hello, World!\n"
+			return &s3client.ObjectInfo{Size: 14, Checksum: "SoXXbx67KpE="}, nil
+		},
+	}
+
+	tests := []struct {
+		name          string
+		planChecksums bool
+		want          map[string]string
+	}{
+		{
+			// Only the changed file, which phase 2 hashed anyway, gets one
+			name: "hashed while uploading",
+			want: map[string]string{
+				"changed.txt": "CXIbLYbJFB0=",
+				"resized.txt": "",
+				"new.txt":     "",
+			},
+		},
+		{
+			name:          "pinned by the plan",
+			planChecksums: true,
+			want: map[string]string{
+				"changed.txt": "CXIbLYbJFB0=",
+				"resized.txt": "wGMObaA9yL0=",
+				"new.txt":     "2yX60sjqiYo=",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewFSToS3Planner(client, &mockLogger{})
+			items, err := p.Plan(context.Background(),
+				Source{Type: SourceTypeFileSystem, Path: localBase},
+				Destination{Type: DestTypeS3, Path: "s3://test-bucket/prefix"},
+				Options{PlanChecksums: tt.planChecksums},
+			)
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+
+			got := make(map[string]string)
+			for _, item := range items {
+				if item.Action == ActionUpload {
+					got[filepath.Base(item.LocalPath)] = item.Checksum
+				}
+			}
+			for name, want := range tt.want {
+				if checksum, ok := got[name]; !ok || checksum != want {
+					t.Errorf("checksum of %s = %q (planned %v), want %q", name, checksum, ok, want)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("planned uploads = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFSToS3Planner_PlanCompositeChecksum(t *testing.T) {
+	localBase := t.TempDir()
+	files := map[string]string{
+		"same.txt":    "Hello, World!\n",
+		"changed.txt": "Hello, World?\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(localBase, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			return []s3client.ItemMetadata{
+				{Path: "same.txt", Size: 14},
+				{Path: "changed.txt", Size: 14},
+			}, nil
+		},
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			// "Hello, World!\n" uploaded in parts of 7 bytes
+			return &s3client.ObjectInfo{
+				Size:         14,
+				Checksum:     "xre5xYZyUZ4=-2",
+				ChecksumType: s3client.ChecksumTypeComposite,
+				PartCount:    2,
+				PartSize:     7,
+			}, nil
+		},
+	}
+
+	p := NewFSToS3Planner(client, &mockLogger{})
+	got, err := p.Plan(context.Background(),
+		Source{Type: SourceTypeFileSystem, Path: localBase},
+		Destination{Type: DestTypeS3, Path: "s3://test-bucket"},
+		Options{PlanChecksums: true},
+	)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	// The upload carries the full object checksum, not the composite one
+	want := []Item{
+		{Action: ActionSkip, LocalPath: filepath.Join(localBase, "same.txt"), Bucket: "test-bucket", Key: "same.txt", Size: 14, Reason: "unchanged"},
+		{Action: ActionUpload, LocalPath: filepath.Join(localBase, "changed.txt"), Bucket: "test-bucket", Key: "changed.txt", Size: 14, Reason: "checksum differs", Checksum: "CXIbLYbJFB0="},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFSToS3Planner_PlanOtherChecksumAlgorithm(t *testing.T) {
+	localBase := t.TempDir()
+	files := map[string]string{
+		"same.txt":    "Hello, World!\n",
+		"changed.txt": "Hello, World?\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(localBase, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			return []s3client.ItemMetadata{
+				{Path: "same.txt", Size: 14},
+				{Path: "changed.txt", Size: 14},
+			}, nil
+		},
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			// Uploaded by a tool that only sends CRC32 checksums of "Hello, World!\n"
+			return &s3client.ObjectInfo{
+				Size:         14,
+				Checksums:    map[string]string{s3client.ChecksumAlgorithmCRC32: "tOiehA=="},
+				ChecksumType: s3client.ChecksumTypeFullObject,
+			}, nil
+		},
+	}
+
+	p := NewFSToS3Planner(client, &mockLogger{})
+	got, err := p.Plan(context.Background(),
+		Source{Type: SourceTypeFileSystem, Path: localBase},
+		Destination{Type: DestTypeS3, Path: "s3://test-bucket"},
+		Options{PlanChecksums: true},
+	)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	// The upload carries a CRC64NVME checksum again
+	want := []Item{
+		{Action: ActionSkip, LocalPath: filepath.Join(localBase, "same.txt"), Bucket: "test-bucket", Key: "same.txt", Size: 14, Reason: "unchanged"},
+		{Action: ActionUpload, LocalPath: filepath.Join(localBase, "changed.txt"), Bucket: "test-bucket", Key: "changed.txt", Size: 14, Reason: "checksum differs", Checksum: "CXIbLYbJFB0="},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFSToS3Planner_PlanChecksumAlgorithm(t *testing.T) {
+	localBase := t.TempDir()
+	files := map[string]string{
+		"same.txt":     "Hello, World!\n",
+		"changed.txt":  "Hello, World?\n",
+		"migrated.txt": "Hello, World?\n",
+		"new.txt":      "new\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(localBase, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const sha256Hello = "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE="
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			return []s3client.ItemMetadata{
+				{Path: "same.txt", Size: 14},
+				{Path: "changed.txt", Size: 14},
+				{Path: "migrated.txt", Size: 14},
+			}, nil
+		},
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			// Objects uploaded before the algorithm was chosen are still
+			// compared by their CRC64NVME
+			if req.Key == "migrated.txt" {
+				return &s3client.ObjectInfo{Size: 14, Checksum: "SoXXbx67KpE=", ChecksumType: s3client.ChecksumTypeFullObject}, nil
+			}
+			return &s3client.ObjectInfo{
+				Size:     14,
+				Checksum: "SoXXbx67KpE=",
+				Checksums: map[string]string{
+					s3client.ChecksumAlgorithmCRC64NVME: "SoXXbx67KpE=",
+					s3client.ChecksumAlgorithmSHA256:    sha256Hello,
+				},
+				ChecksumType: s3client.ChecksumTypeFullObject,
+			}, nil
+		},
+	}
+
+	p := NewFSToS3Planner(client, &mockLogger{})
+	got, err := p.Plan(context.Background(),
+		Source{Type: SourceTypeFileSystem, Path: localBase},
+		Destination{Type: DestTypeS3, Path: "s3://test-bucket"},
+		Options{PlanChecksums: true, ChecksumAlgorithm: s3client.ChecksumAlgorithmSHA256},
+	)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	want := []Item{
+		{Action: ActionSkip, LocalPath: filepath.Join(localBase, "same.txt"), Bucket: "test-bucket", Key: "same.txt", Size: 14, Reason: "unchanged"},
+		{Action: ActionUpload, LocalPath: filepath.Join(localBase, "changed.txt"), Bucket: "test-bucket", Key: "changed.txt", Size: 14, Reason: "checksum differs", Checksum: "0P3mVy6ry6WUdL4CmgAzTojPFFjJ+FpCX570+PCKl1o=", ChecksumAlgorithm: "SHA256"},
+		{Action: ActionUpload, LocalPath: filepath.Join(localBase, "migrated.txt"), Bucket: "test-bucket", Key: "migrated.txt", Size: 14, Reason: "checksum differs", Checksum: "0P3mVy6ry6WUdL4CmgAzTojPFFjJ+FpCX570+PCKl1o=", ChecksumAlgorithm: "SHA256"},
+		{Action: ActionUpload, LocalPath: filepath.Join(localBase, "new.txt"), Bucket: "test-bucket", Key: "new.txt", Size: 4, Reason: "new file", Checksum: "eqelNZFz0Ftjz9aC48OEh/PLT38dYGWf5Z+rFQWXfUw=", ChecksumAlgorithm: "SHA256"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFSToS3Planner_PlanBackfillChecksums(t *testing.T) {
+	localBase := t.TempDir()
+	files := map[string]string{
+		"same.txt":    "Hello, World!\n",
+		"legacy.txt":  "Hello, World?\n",
+		"resized.txt": "short",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(localBase, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			return []s3client.ItemMetadata{
+				{Path: "same.txt", Size: 14},
+				{Path: "legacy.txt", Size: 14},
+				{Path: "resized.txt", Size: 100},
+			}, nil
+		},
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			// Uploaded before S3 stored checksums
+			if req.Key == "legacy.txt" {
+				return &s3client.ObjectInfo{Size: 14}, nil
+			}
+			return &s3client.ObjectInfo{Size: 14, Checksum: "SoXXbx67KpE="}, nil
+		},
+	}
+
+	tests := []struct {
+		name     string
+		backfill bool
+		want     Item
+	}{
+		{
+			name: "uploaded again",
+			want: Item{Action: ActionUpload, LocalPath: filepath.Join(localBase, "legacy.txt"), Bucket: "test-bucket", Key: "legacy.txt", Size: 14, Reason: "checksum differs", Checksum: "CXIbLYbJFB0="},
+		},
+		{
+			name:     "backfilled",
+			backfill: true,
+			want:     Item{Action: ActionBackfill, LocalPath: filepath.Join(localBase, "legacy.txt"), Bucket: "test-bucket", Key: "legacy.txt", Size: 14, Reason: "missing checksum", Checksum: "CXIbLYbJFB0="},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewFSToS3Planner(client, &mockLogger{})
+			got, err := p.Plan(context.Background(),
+				Source{Type: SourceTypeFileSystem, Path: localBase},
+				Destination{Type: DestTypeS3, Path: "s3://test-bucket"},
+				Options{BackfillChecksums: tt.backfill},
+			)
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+
+			// Resized objects have changed anyway
+			want := []Item{
+				{Action: ActionSkip, LocalPath: filepath.Join(localBase, "same.txt"), Bucket: "test-bucket", Key: "same.txt", Size: 14, Reason: "unchanged"},
+				tt.want,
+				{Action: ActionUpload, LocalPath: filepath.Join(localBase, "resized.txt"), Bucket: "test-bucket", Key: "resized.txt", Size: 5, Reason: "size differs"},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Plan() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
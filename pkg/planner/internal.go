@@ -11,10 +11,31 @@ type Phase1Result struct {
 	SizeMismatch []ItemRef
 	NeedChecksum []ItemRef
 	Identical    []ItemRef
+	// Journaled holds the items a previous run already synced (see SkipCompleted).
+	Journaled []ItemRef
+	// Compared holds the items a Comparator decided on (see ComparePhase1
+	// and ComparePhase2).
+	Compared []ComparedRef
+}
+
+// ComparedRef is an item a Comparator decided on.
+type ComparedRef struct {
+	ItemRef
+	Changed bool
+	Reason  string
 }
 
 type ChecksumData struct {
 	ItemRef        ItemRef
 	SourceChecksum string
 	DestChecksum   string
+	// Algorithm is the algorithm of both checksums if it is not CRC64NVME.
+	Algorithm string
+	// SourceMetadata and DestMetadata are the user metadata of objects,
+	// collected if the Comparator needs them.
+	SourceMetadata map[string]string
+	DestMetadata   map[string]string
+	// LocalMetadata is the source metadata of the local file, collected for
+	// uploads if the Comparator needs SourceMetadata.
+	LocalMetadata map[string]string
 }
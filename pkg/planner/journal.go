@@ -0,0 +1,16 @@
+package planner
+
+// skipCompleted applies SkipCompleted with the items journal reports as
+// completed. newItem builds the transfer item the plan would contain.
+func skipCompleted(phase1 Phase1Result, journal Journal, transfer Action, newItem itemFunc) Phase1Result {
+	if journal == nil {
+		return phase1
+	}
+
+	return SkipCompleted(phase1, func(ref ItemRef) bool {
+		item := newItem(transfer, ref)
+		item.Action = transfer
+		item.Size = ref.Size
+		return journal.Completed(item)
+	})
+}
@@ -3,6 +3,8 @@ package planner
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
 )
@@ -11,7 +13,9 @@ import (
 type mockS3Client struct {
 	listObjectsFunc  func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error)
 	headObjectFunc   func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error)
+	getObjectFunc    func(ctx context.Context, req *s3client.GetObjectRequest) (*s3client.Object, error)
 	putObjectFunc    func(ctx context.Context, req *s3client.PutObjectRequest) error
+	copyObjectFunc   func(ctx context.Context, req *s3client.CopyObjectRequest) error
 	deleteObjectFunc func(ctx context.Context, req *s3client.DeleteObjectRequest) error
 }
 
@@ -22,6 +26,20 @@ func (m *mockS3Client) ListObjects(ctx context.Context, req *s3client.ListObject
 	return nil, fmt.Errorf("ListObjects not implemented")
 }
 
+// ListObjectsPages returns the objects of listObjectsFunc in a single page,
+// sorted like S3 lists them.
+func (m *mockS3Client) ListObjectsPages(ctx context.Context, req *s3client.ListObjectsRequest, fn func(page []s3client.ItemMetadata) error) error {
+	items, err := m.ListObjects(ctx, req)
+	if err != nil {
+		return err
+	}
+	items = slices.Clone(items)
+	slices.SortFunc(items, func(a, b s3client.ItemMetadata) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return fn(items)
+}
+
 func (m *mockS3Client) HeadObject(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
 	if m.headObjectFunc != nil {
 		return m.headObjectFunc(ctx, req)
@@ -29,6 +47,13 @@ func (m *mockS3Client) HeadObject(ctx context.Context, req *s3client.HeadObjectR
 	return nil, fmt.Errorf("HeadObject not implemented")
 }
 
+func (m *mockS3Client) GetObject(ctx context.Context, req *s3client.GetObjectRequest) (*s3client.Object, error) {
+	if m.getObjectFunc != nil {
+		return m.getObjectFunc(ctx, req)
+	}
+	return nil, fmt.Errorf("GetObject not implemented")
+}
+
 func (m *mockS3Client) PutObject(ctx context.Context, req *s3client.PutObjectRequest) error {
 	if m.putObjectFunc != nil {
 		return m.putObjectFunc(ctx, req)
@@ -36,6 +61,13 @@ func (m *mockS3Client) PutObject(ctx context.Context, req *s3client.PutObjectReq
 	return fmt.Errorf("PutObject not implemented")
 }
 
+func (m *mockS3Client) CopyObject(ctx context.Context, req *s3client.CopyObjectRequest) error {
+	if m.copyObjectFunc != nil {
+		return m.copyObjectFunc(ctx, req)
+	}
+	return fmt.Errorf("CopyObject not implemented")
+}
+
 func (m *mockS3Client) DeleteObject(ctx context.Context, req *s3client.DeleteObjectRequest) error {
 	if m.deleteObjectFunc != nil {
 		return m.deleteObjectFunc(ctx, req)
@@ -45,10 +77,12 @@ func (m *mockS3Client) DeleteObject(ctx context.Context, req *s3client.DeleteObj
 
 // mockLogger is a mock implementation of logger.Logger for testing
 type mockLogger struct {
-	uploadCalls []uploadCall
-	deleteCalls []deleteCall
-	errorCalls  []errorCall
-	debugCalls  []string
+	uploadCalls   []uploadCall
+	downloadCalls []downloadCall
+	copyCalls     []copyCall
+	deleteCalls   []deleteCall
+	errorCalls    []errorCall
+	debugCalls    []string
 }
 
 type uploadCall struct {
@@ -56,6 +90,16 @@ type uploadCall struct {
 	s3Path    string
 }
 
+type downloadCall struct {
+	s3Path    string
+	localPath string
+}
+
+type copyCall struct {
+	sourceS3Path string
+	destS3Path   string
+}
+
 type deleteCall struct {
 	s3Path string
 }
@@ -70,6 +114,14 @@ func (m *mockLogger) Upload(localPath, s3Path string) {
 	m.uploadCalls = append(m.uploadCalls, uploadCall{localPath, s3Path})
 }
 
+func (m *mockLogger) Download(s3Path, localPath string) {
+	m.downloadCalls = append(m.downloadCalls, downloadCall{s3Path, localPath})
+}
+
+func (m *mockLogger) Copy(sourceS3Path, destS3Path string) {
+	m.copyCalls = append(m.copyCalls, copyCall{sourceS3Path, destS3Path})
+}
+
 func (m *mockLogger) Delete(s3Path string) {
 	m.deleteCalls = append(m.deleteCalls, deleteCall{s3Path})
 }
@@ -0,0 +1,230 @@
+package planner
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+// DefaultHeadConcurrency is how many HeadObject requests Phase 2 sends at a
+// time unless Options.HeadConcurrency says otherwise.
+const DefaultHeadConcurrency = 32
+
+// checksumFunc collects the source and destination checksums of a single item.
+type checksumFunc func(ctx context.Context, pools *phase2Pools, item ItemRef) (ChecksumData, error)
+
+// collectChecksums runs fn for every item concurrently and returns the
+// results in the same order as items. Enough items are in flight at a time
+// to keep both pools busy. The first error cancels the items still running,
+// unless opts.KeepGoing, which collects the errors of all items into
+// ItemErrors and returns the results of the others along with it.
+func collectChecksums(ctx context.Context, items []ItemRef, opts Options, fn checksumFunc) ([]ChecksumData, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pools := newPhase2Pools(opts)
+	workerCount := min(cap(pools.hashSlots)+cap(pools.headSlots), len(items))
+
+	type checksumTask struct {
+		index int
+		item  ItemRef
+	}
+
+	type checksumResult struct {
+		index int
+		data  ChecksumData
+		err   error
+	}
+
+	tasks := make(chan checksumTask, len(items))
+	results := make(chan checksumResult, len(items))
+
+	for range workerCount {
+		go func() {
+			for task := range tasks {
+				// Items left after a failure are not started at all
+				if err := ctx.Err(); err != nil {
+					results <- checksumResult{index: task.index, err: err}
+					continue
+				}
+				data, err := fn(ctx, pools, task.item)
+				results <- checksumResult{
+					index: task.index,
+					data:  data,
+					err:   err,
+				}
+			}
+		}()
+	}
+
+	for i, item := range items {
+		tasks <- checksumTask{index: i, item: item}
+	}
+	close(tasks)
+
+	// Collect the results in the order of items
+	checksums := make([]ChecksumData, len(items))
+	failed := make([]bool, len(items))
+	var errs ItemErrors
+	for range len(items) {
+		result := <-results
+		if result.err == nil {
+			checksums[result.index] = result.data
+			continue
+		}
+		if !opts.KeepGoing || ctx.Err() != nil {
+			return nil, result.err
+		}
+		errs = append(errs, &ItemError{Path: items[result.index].Path, Err: result.err})
+		failed[result.index] = true
+	}
+	if len(errs) == 0 {
+		return checksums, nil
+	}
+
+	succeeded := checksums[:0]
+	for i, data := range checksums {
+		if !failed[i] {
+			succeeded = append(succeeded, data)
+		}
+	}
+	slices.SortFunc(errs, func(a, b *ItemError) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return succeeded, errs
+}
+
+// ItemError is the error of an item that could not be compared.
+type ItemError struct {
+	// Path is the path of the item relative to the source and destination
+	Path string
+	Err  error
+}
+
+func (e *ItemError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// ItemErrors is returned by planners with Options.KeepGoing along with the
+// plan of the items that could be compared. The items that could not are
+// left out of the plan.
+type ItemErrors []*ItemError
+
+func (e ItemErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("failed to compare %d files:\n%s", len(e), strings.Join(lines, "\n"))
+}
+
+func (e ItemErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// splitItemErrors splits an error of collectChecksums into the ItemErrors of
+// Options.KeepGoing, which still leave a plan, and any other error.
+func splitItemErrors(err error) (ItemErrors, error) {
+	if errs, ok := err.(ItemErrors); ok {
+		return errs, nil
+	}
+	return nil, err
+}
+
+// orNil returns e as an error, nil if there are no errors.
+func (e ItemErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// phase2Pools bounds how many local files Phase 2 hashes and how many
+// HeadObject requests it sends at a time. Hashing is bound by CPU and disk,
+// HeadObject by the network, so each has a pool of its own.
+type phase2Pools struct {
+	hashSlots chan struct{}
+	headSlots chan struct{}
+	// fileConcurrency is how many goroutines hash a file in the hash
+	// pool, so that the pool as a whole uses about GOMAXPROCS of them.
+	fileConcurrency int
+}
+
+func newPhase2Pools(opts Options) *phase2Pools {
+	hashConcurrency := opts.HashConcurrency
+	if hashConcurrency <= 0 {
+		hashConcurrency = runtime.NumCPU()
+	}
+	headConcurrency := opts.HeadConcurrency
+	if headConcurrency <= 0 {
+		headConcurrency = DefaultHeadConcurrency
+	}
+	return &phase2Pools{
+		hashSlots:       make(chan struct{}, hashConcurrency),
+		headSlots:       make(chan struct{}, headConcurrency),
+		fileConcurrency: max(1, runtime.GOMAXPROCS(0)/hashConcurrency),
+	}
+}
+
+// hash runs fn, which hashes local files, in the hash pool.
+func (p *phase2Pools) hash(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case p.hashSlots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.hashSlots }()
+	return fn()
+}
+
+// hashAsync starts hashing with fn in the hash pool and returns a function
+// that waits for the checksum.
+func (p *phase2Pools) hashAsync(ctx context.Context, fn func() (string, error)) func() (string, error) {
+	done := make(chan struct{})
+	var checksum string
+	var err error
+	go func() {
+		defer close(done)
+		err = p.hash(ctx, func() error {
+			checksum, err = fn()
+			return err
+		})
+	}()
+	return func() (string, error) {
+		<-done
+		return checksum, err
+	}
+}
+
+// headObject sends a HeadObject request in the HEAD pool.
+func (p *phase2Pools) headObject(ctx context.Context, client s3client.Client, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case p.headSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-p.headSlots }()
+	return client.HeadObject(ctx, req)
+}
@@ -21,6 +21,10 @@ func (c *benchMockS3Client) ListObjects(ctx context.Context, req *s3client.ListO
 	return nil, nil
 }
 
+func (c *benchMockS3Client) ListObjectsPages(ctx context.Context, req *s3client.ListObjectsRequest, fn func(page []s3client.ItemMetadata) error) error {
+	return nil
+}
+
 func (c *benchMockS3Client) HeadObject(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
 	// S3 APIのレイテンシをシミュレート
 	if c.latency > 0 {
@@ -32,10 +36,18 @@ func (c *benchMockS3Client) HeadObject(ctx context.Context, req *s3client.HeadOb
 	}, nil
 }
 
+func (c *benchMockS3Client) GetObject(ctx context.Context, req *s3client.GetObjectRequest) (*s3client.Object, error) {
+	return nil, nil
+}
+
 func (c *benchMockS3Client) PutObject(ctx context.Context, req *s3client.PutObjectRequest) error {
 	return nil
 }
 
+func (c *benchMockS3Client) CopyObject(ctx context.Context, req *s3client.CopyObjectRequest) error {
+	return nil
+}
+
 func (c *benchMockS3Client) DeleteObject(ctx context.Context, req *s3client.DeleteObjectRequest) error {
 	return nil
 }
@@ -120,7 +132,7 @@ func (p *FSToS3Planner) Phase2CollectChecksumsSerial(ctx context.Context, items
 	var checksums []ChecksumData
 	for _, item := range items {
 		localPath := filepath.Join(localBase, item.Path)
-		sourceChecksum, err := calculateFileChecksum(localPath)
+		sourceChecksum, err := calculateFileChecksum(localPath, 1)
 		if err != nil {
 			return nil, fmt.Errorf("failed to calculate checksum for %s: %w", localPath, err)
 		}
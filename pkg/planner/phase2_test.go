@@ -2,10 +2,15 @@ package planner
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path"
 	"reflect"
+	"runtime"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
 )
@@ -189,3 +194,153 @@ func TestPhase2CollectChecksums(t *testing.T) {
 		})
 	}
 }
+
+// signalCache signals each file hashed by looking it up.
+type signalCache struct {
+	hashing chan string
+}
+
+func (c signalCache) Get(path string, algorithm string, info os.FileInfo) (string, bool) {
+	c.hashing <- path
+	return "", false
+}
+
+func (c signalCache) Put(path string, algorithm string, info os.FileInfo, checksum string) {}
+
+func TestPhase2HashesWhileHeading(t *testing.T) {
+	cache := signalCache{hashing: make(chan string, 1)}
+	client := &mockS3Client{
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			// Only returns once the file is being hashed
+			select {
+			case <-cache.hashing:
+			case <-time.After(5 * time.Second):
+				return nil, fmt.Errorf("%s was not hashed while heading it", req.Key)
+			}
+			return &s3client.ObjectInfo{Size: 13, Checksum: "SoXXbx67KpE="}, nil
+		},
+	}
+
+	p := NewFSToS3Planner(client, &mockLogger{})
+	got, err := p.phase2CollectChecksums(context.Background(), []ItemRef{{Path: "hello.txt", Size: 13}}, nil, "testdata", "test-bucket", "", Options{ChecksumCache: cache})
+	if err != nil {
+		t.Fatalf("phase2CollectChecksums() error = %v", err)
+	}
+	if got[0].SourceChecksum != "SoXXbx67KpE=" {
+		t.Errorf("SourceChecksum = %q, want %q", got[0].SourceChecksum, "SoXXbx67KpE=")
+	}
+}
+
+func TestPhase2HeadConcurrency(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	client := &mockS3Client{
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return &s3client.ObjectInfo{Size: 1, Checksum: "SoXXbx67KpE="}, nil
+		},
+	}
+
+	var items []ItemRef
+	for i := range 10 {
+		items = append(items, ItemRef{Path: fmt.Sprintf("file%d", i), Size: 1})
+	}
+
+	p := NewS3ToS3Planner(client, &mockLogger{})
+	if _, err := p.phase2CollectChecksums(context.Background(), items, "source-bucket", "", "dest-bucket", "", Options{HeadConcurrency: 3}); err != nil {
+		t.Fatalf("phase2CollectChecksums() error = %v", err)
+	}
+	if n := maxInFlight.Load(); n != 3 {
+		t.Errorf("%d HeadObject requests in flight at most, want 3", n)
+	}
+}
+
+func TestPhase2FileConcurrency(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	tests := []struct {
+		hashConcurrency int
+		want            int
+	}{
+		// Every file of the default pool is hashed by a single goroutine
+		{hashConcurrency: 0, want: max(1, procs/runtime.NumCPU())},
+		{hashConcurrency: procs, want: 1},
+		{hashConcurrency: 4 * procs, want: 1},
+		// A single file at a time is hashed in parallel
+		{hashConcurrency: 1, want: procs},
+	}
+
+	for _, tt := range tests {
+		pools := newPhase2Pools(Options{HashConcurrency: tt.hashConcurrency})
+		if pools.fileConcurrency != tt.want {
+			t.Errorf("fileConcurrency with HashConcurrency %d = %d, want %d", tt.hashConcurrency, pools.fileConcurrency, tt.want)
+		}
+	}
+}
+
+func TestPhase2CancelsOnFirstError(t *testing.T) {
+	var heads atomic.Int32
+	client := &mockS3Client{
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			heads.Add(1)
+			time.Sleep(time.Millisecond)
+			return nil, fmt.Errorf("access denied")
+		},
+	}
+
+	var items []ItemRef
+	for i := range 100 {
+		items = append(items, ItemRef{Path: fmt.Sprintf("file%02d", i), Size: 1})
+	}
+
+	p := NewS3ToS3Planner(client, &mockLogger{})
+	_, err := p.phase2CollectChecksums(context.Background(), items, "source-bucket", "", "dest-bucket", "", Options{HeadConcurrency: 1})
+	if err == nil {
+		t.Fatal("phase2CollectChecksums() error = nil, want an error")
+	}
+	if n := heads.Load(); n >= 100 {
+		t.Errorf("sent %d HeadObject requests after the first failure, want the rest cancelled", n)
+	}
+}
+
+func TestS3ToS3Planner_PlanKeepGoing(t *testing.T) {
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			return []s3client.ItemMetadata{{Path: "a", Size: 1}, {Path: "b", Size: 1}, {Path: "c", Size: 1}}, nil
+		},
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			if req.Key != "b" && req.Bucket == "dest-bucket" {
+				return nil, fmt.Errorf("access denied to %s", req.Key)
+			}
+			return &s3client.ObjectInfo{Size: 1, Checksum: "SoXXbx67KpE=", Checksums: map[string]string{s3client.ChecksumAlgorithmCRC64NVME: "SoXXbx67KpE="}}, nil
+		},
+	}
+
+	p := NewS3ToS3Planner(client, &mockLogger{})
+	got, err := p.Plan(context.Background(),
+		Source{Type: SourceTypeS3, Path: "s3://source-bucket"},
+		Destination{Type: DestTypeS3, Path: "s3://dest-bucket"},
+		Options{KeepGoing: true},
+	)
+
+	var itemErrs ItemErrors
+	if !errors.As(err, &itemErrs) {
+		t.Fatalf("Plan() error = %v, want ItemErrors", err)
+	}
+	var failed []string
+	for _, itemErr := range itemErrs {
+		failed = append(failed, itemErr.Path)
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed paths = %v, want %v", failed, want)
+	}
+	if len(got) != 1 || got[0].Key != "b" || got[0].Action != ActionSkip {
+		t.Errorf("Plan() = %+v, want only b skipped", got)
+	}
+}
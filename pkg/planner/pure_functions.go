@@ -61,29 +61,98 @@ func Phase1Compare(source []ItemMetadata, dest []ItemMetadata, deleteEnabled boo
 	return result
 }
 
+// SkipCompleted moves the items that still need a checksum comparison and
+// are reported as completed to Journaled. Items of different sizes are left
+// alone as they have obviously changed since.
+func SkipCompleted(result Phase1Result, completed func(ref ItemRef) bool) Phase1Result {
+	needChecksum := []ItemRef{}
+	for _, ref := range result.NeedChecksum {
+		if completed(ref) {
+			result.Journaled = append(result.Journaled, ref)
+		} else {
+			needChecksum = append(needChecksum, ref)
+		}
+	}
+	result.NeedChecksum = needChecksum
+	return result
+}
+
 func Phase3GeneratePlan(phase1 Phase1Result, checksums []ChecksumData, localBase string, bucket string, prefix string) []Item {
+	return generatePlan(phase1, checksums, ActionUpload, ActionDelete, "deleted locally", uploadItem(localBase, bucket, prefix))
+}
+
+// Phase3GenerateDownloadPlan is the S3 to file system counterpart of Phase3GeneratePlan.
+func Phase3GenerateDownloadPlan(phase1 Phase1Result, checksums []ChecksumData, bucket string, prefix string, localBase string) []Item {
+	return generatePlan(phase1, checksums, ActionDownload, ActionDeleteLocal, "deleted in source", downloadItem(bucket, prefix, localBase))
+}
+
+// Phase3GenerateCopyPlan is the S3 to S3 counterpart of Phase3GeneratePlan.
+func Phase3GenerateCopyPlan(phase1 Phase1Result, checksums []ChecksumData, sourceBucket string, sourcePrefix string, bucket string, prefix string) []Item {
+	return generatePlan(phase1, checksums, ActionCopy, ActionDelete, "deleted in source", copyItem(sourceBucket, sourcePrefix, bucket, prefix))
+}
+
+// itemFunc fills in the locations of the plan item for ref.
+type itemFunc func(action Action, ref ItemRef) Item
+
+func uploadItem(localBase string, bucket string, prefix string) itemFunc {
+	return func(action Action, ref ItemRef) Item {
+		item := Item{
+			Bucket: bucket,
+			Key:    path.Join(prefix, ref.Path),
+		}
+		if action != ActionDelete {
+			item.LocalPath = filepath.Join(localBase, ref.Path)
+		}
+		return item
+	}
+}
+
+func downloadItem(bucket string, prefix string, localBase string) itemFunc {
+	return func(action Action, ref ItemRef) Item {
+		item := Item{
+			LocalPath: filepath.Join(localBase, ref.Path),
+		}
+		if action != ActionDeleteLocal {
+			item.Bucket = bucket
+			item.Key = objectKey(prefix, ref.Path)
+		}
+		return item
+	}
+}
+
+func copyItem(sourceBucket string, sourcePrefix string, bucket string, prefix string) itemFunc {
+	return func(action Action, ref ItemRef) Item {
+		item := Item{
+			Bucket: bucket,
+			Key:    objectKey(prefix, ref.Path),
+		}
+		if action != ActionDelete {
+			item.SourceBucket = sourceBucket
+			item.SourceKey = objectKey(sourcePrefix, ref.Path)
+		}
+		return item
+	}
+}
+
+// generatePlan turns the phase 1 and phase 2 results into plan items.
+// newItem fills in the location fields of an item for the given action.
+func generatePlan(phase1 Phase1Result, checksums []ChecksumData, transfer Action, remove Action, removeReason string, newItem itemFunc) []Item {
 	items := []Item{}
 
+	add := func(action Action, ref ItemRef, reason string) {
+		item := newItem(action, ref)
+		item.Action = action
+		item.Size = ref.Size
+		item.Reason = reason
+		items = append(items, item)
+	}
+
 	for _, ref := range phase1.NewItems {
-		items = append(items, Item{
-			Action:    ActionUpload,
-			LocalPath: filepath.Join(localBase, ref.Path),
-			Bucket:    bucket,
-			Key:       path.Join(prefix, ref.Path),
-			Size:      ref.Size,
-			Reason:    "new file",
-		})
+		add(transfer, ref, "new file")
 	}
 
 	for _, ref := range phase1.SizeMismatch {
-		items = append(items, Item{
-			Action:    ActionUpload,
-			LocalPath: filepath.Join(localBase, ref.Path),
-			Bucket:    bucket,
-			Key:       path.Join(prefix, ref.Path),
-			Size:      ref.Size,
-			Reason:    "size differs",
-		})
+		add(transfer, ref, "size differs")
 	}
 
 	checksumMap := make(map[string]ChecksumData)
@@ -93,48 +162,54 @@ func Phase3GeneratePlan(phase1 Phase1Result, checksums []ChecksumData, localBase
 
 	for _, ref := range phase1.NeedChecksum {
 		if cs, exists := checksumMap[ref.Path]; exists {
-			if cs.SourceChecksum != cs.DestChecksum {
-				items = append(items, Item{
-					Action:    ActionUpload,
-					LocalPath: filepath.Join(localBase, ref.Path),
-					Bucket:    bucket,
-					Key:       path.Join(prefix, ref.Path),
-					Size:      ref.Size,
-					Reason:    "checksum differs",
-				})
+			// A missing checksum on either side can't prove the files are identical
+			if cs.SourceChecksum == "" || cs.SourceChecksum != cs.DestChecksum {
+				add(transfer, ref, "checksum differs")
 			} else {
 				// Checksum matches, file is unchanged
-				items = append(items, Item{
-					Action:    ActionSkip,
-					LocalPath: filepath.Join(localBase, ref.Path),
-					Bucket:    bucket,
-					Key:       path.Join(prefix, ref.Path),
-					Size:      ref.Size,
-					Reason:    "unchanged",
-				})
+				add(ActionSkip, ref, "unchanged")
 			}
 		}
 	}
 
+	for _, ref := range phase1.Compared {
+		if ref.Changed {
+			add(transfer, ref.ItemRef, ref.Reason)
+		} else {
+			add(ActionSkip, ref.ItemRef, ref.Reason)
+		}
+	}
+
+	for _, ref := range phase1.Journaled {
+		add(ActionSkip, ref, "completed in previous run")
+	}
+
 	for _, ref := range phase1.DeletedItems {
-		items = append(items, Item{
-			Action:    ActionDelete,
-			LocalPath: "",
-			Bucket:    bucket,
-			Key:       path.Join(prefix, ref.Path),
-			Size:      ref.Size,
-			Reason:    "deleted locally",
-		})
+		add(remove, ref, removeReason)
 	}
 
+	sortItems(items)
+	return items
+}
+
+// sortItems sorts items by action, then by key and local path. Backfills
+// are sorted with the uploads they stand in for.
+func sortItems(items []Item) {
+	action := func(item Item) Action {
+		if item.Action == ActionBackfill {
+			return ActionUpload
+		}
+		return item.Action
+	}
 	sort.Slice(items, func(i, j int) bool {
-		if items[i].Action != items[j].Action {
-			return items[i].Action < items[j].Action
+		if action(items[i]) != action(items[j]) {
+			return action(items[i]) < action(items[j])
 		}
-		return items[i].Key < items[j].Key
+		if items[i].Key != items[j].Key {
+			return items[i].Key < items[j].Key
+		}
+		return items[i].LocalPath < items[j].LocalPath
 	})
-
-	return items
 }
 
 func sortPhase1Result(result *Phase1Result) {
@@ -149,16 +224,20 @@ func sortPhase1Result(result *Phase1Result) {
 	sortItemRefs(result.SizeMismatch)
 	sortItemRefs(result.NeedChecksum)
 	sortItemRefs(result.Identical)
+	sortItemRefs(result.Journaled)
 }
 
-func IsExcluded(path string, patterns []string) (bool, error) {
-	for _, pattern := range patterns {
-		matched, err := fnmatch.Match(pattern, path)
+// IsExcluded reports whether path is filtered out by filters.
+// All paths are included by default, and the last filter that matches the
+// path decides whether it is excluded or included.
+func IsExcluded(path string, filters []Filter) (bool, error) {
+	for i := len(filters) - 1; i >= 0; i-- {
+		matched, err := fnmatch.Match(filters[i].Pattern, path)
 		if err != nil {
 			return false, err
 		}
 		if matched {
-			return true, nil
+			return filters[i].Type == FilterExclude, nil
 		}
 	}
 	return false, nil
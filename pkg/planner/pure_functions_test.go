@@ -3,6 +3,7 @@ package planner
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestPhase1Compare(t *testing.T) {
@@ -225,6 +226,52 @@ func TestPhase3GeneratePlan(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "size only comparison",
+			phase1: ComparePhase1(Phase1Result{
+				NeedChecksum: []ItemRef{
+					{Path: "file1.txt", Size: 100},
+				},
+			}, nil, nil, SizeOnlyComparator{}),
+			checksums: []ChecksumData{},
+			localBase: "/local",
+			bucket:    "test-bucket",
+			prefix:    "prefix",
+			want: []Item{
+				{
+					Action:    ActionSkip,
+					LocalPath: "/local/file1.txt",
+					Bucket:    "test-bucket",
+					Key:       "prefix/file1.txt",
+					Size:      100,
+					Reason:    "same size",
+				},
+			},
+		},
+		{
+			name: "timestamp comparison",
+			phase1: ComparePhase1(Phase1Result{
+				NeedChecksum: []ItemRef{
+					{Path: "file1.txt", Size: 100},
+				},
+			}, []ItemMetadata{{Path: "file1.txt", Size: 100, ModTime: time.Unix(1, 0)}},
+				[]ItemMetadata{{Path: "file1.txt", Size: 100, ModTime: time.Unix(1, 0)}},
+				TimestampComparator{Match: SameTimestamp}),
+			checksums: []ChecksumData{},
+			localBase: "/local",
+			bucket:    "test-bucket",
+			prefix:    "prefix",
+			want: []Item{
+				{
+					Action:    ActionSkip,
+					LocalPath: "/local/file1.txt",
+					Bucket:    "test-bucket",
+					Key:       "prefix/file1.txt",
+					Size:      100,
+					Reason:    "same size and timestamp",
+				},
+			},
+		},
 		{
 			name: "checksum differs",
 			phase1: Phase1Result{
@@ -359,130 +406,181 @@ func TestPhase3GeneratePlan(t *testing.T) {
 
 func TestIsExcluded(t *testing.T) {
 	tests := []struct {
-		name     string
-		path     string
-		patterns []string
-		want     bool
-		wantErr  bool
+		name    string
+		path    string
+		filters []Filter
+		want    bool
+		wantErr bool
 	}{
 		{
-			name:     "no patterns",
-			path:     "file.txt",
-			patterns: []string{},
-			want:     false,
-			wantErr:  false,
+			name:    "no patterns",
+			path:    "file.txt",
+			filters: []Filter{},
+			want:    false,
+			wantErr: false,
 		},
 		{
-			name:     "simple match",
-			path:     "test.tmp",
-			patterns: []string{"*.tmp"},
-			want:     true,
-			wantErr:  false,
+			name:    "simple match",
+			path:    "test.tmp",
+			filters: []Filter{{Type: FilterExclude, Pattern: "*.tmp"}},
+			want:    true,
+			wantErr: false,
 		},
 		{
-			name:     "simple no match",
-			path:     "test.txt",
-			patterns: []string{"*.tmp"},
-			want:     false,
-			wantErr:  false,
+			name:    "simple no match",
+			path:    "test.txt",
+			filters: []Filter{{Type: FilterExclude, Pattern: "*.tmp"}},
+			want:    false,
+			wantErr: false,
 		},
 		{
-			name:     "multiple patterns with match",
-			path:     "test.tmp",
-			patterns: []string{"*.log", "*.tmp", "*.bak"},
-			want:     true,
-			wantErr:  false,
+			name:    "multiple patterns with match",
+			path:    "test.tmp",
+			filters: []Filter{{Type: FilterExclude, Pattern: "*.log"}, {Type: FilterExclude, Pattern: "*.tmp"}, {Type: FilterExclude, Pattern: "*.bak"}},
+			want:    true,
+			wantErr: false,
 		},
 		{
-			name:     "multiple patterns no match",
-			path:     "test.txt",
-			patterns: []string{"*.log", "*.tmp", "*.bak"},
-			want:     false,
-			wantErr:  false,
+			name:    "multiple patterns no match",
+			path:    "test.txt",
+			filters: []Filter{{Type: FilterExclude, Pattern: "*.log"}, {Type: FilterExclude, Pattern: "*.tmp"}, {Type: FilterExclude, Pattern: "*.bak"}},
+			want:    false,
+			wantErr: false,
 		},
 		{
-			name:     "directory match",
-			path:     "node_modules/package.json",
-			patterns: []string{"node_modules/*"},
-			want:     true,
-			wantErr:  false,
+			name:    "directory match",
+			path:    "node_modules/package.json",
+			filters: []Filter{{Type: FilterExclude, Pattern: "node_modules/*"}},
+			want:    true,
+			wantErr: false,
 		},
 		{
-			name:     "nested directory match",
-			path:     "src/test/data.tmp",
-			patterns: []string{"*.tmp"},
-			want:     true,
-			wantErr:  false,
+			name:    "nested directory match",
+			path:    "src/test/data.tmp",
+			filters: []Filter{{Type: FilterExclude, Pattern: "*.tmp"}},
+			want:    true,
+			wantErr: false,
 		},
 		{
-			name:     "exact path match",
-			path:     "config/secret.key",
-			patterns: []string{"config/secret.key"},
-			want:     true,
-			wantErr:  false,
+			name:    "exact path match",
+			path:    "config/secret.key",
+			filters: []Filter{{Type: FilterExclude, Pattern: "config/secret.key"}},
+			want:    true,
+			wantErr: false,
 		},
 		{
-			name:     "directory prefix without wildcard",
-			path:     "temp/file.txt",
-			patterns: []string{"temp/"},
-			want:     false,
-			wantErr:  false,
+			name:    "directory prefix without wildcard",
+			path:    "temp/file.txt",
+			filters: []Filter{{Type: FilterExclude, Pattern: "temp/"}},
+			want:    false,
+			wantErr: false,
 		},
 		{
-			name:     "directory prefix with wildcard",
-			path:     "temp/file.txt",
-			patterns: []string{"temp/*"},
-			want:     true,
-			wantErr:  false,
+			name:    "directory prefix with wildcard",
+			path:    "temp/file.txt",
+			filters: []Filter{{Type: FilterExclude, Pattern: "temp/*"}},
+			want:    true,
+			wantErr: false,
 		},
 		// AWS S3 sync compatible behavior tests
 		{
-			name:     "aws compat: star matches across directories",
-			path:     "_next/subdir/file.txt",
-			patterns: []string{"_next/*"},
-			want:     true, // With fnmatch, * matches path separators
-			wantErr:  false,
+			name:    "aws compat: star matches across directories",
+			path:    "_next/subdir/file.txt",
+			filters: []Filter{{Type: FilterExclude, Pattern: "_next/*"}},
+			want:    true, // With fnmatch, * matches path separators
+			wantErr: false,
+		},
+		{
+			name:    "aws compat: deeply nested match",
+			path:    "_next/subdir/deep/file.txt",
+			filters: []Filter{{Type: FilterExclude, Pattern: "_next/*"}},
+			want:    true, // With fnmatch, * matches path separators
+			wantErr: false,
 		},
 		{
-			name:     "aws compat: deeply nested match",
-			path:     "_next/subdir/deep/file.txt",
-			patterns: []string{"_next/*"},
-			want:     true, // With fnmatch, * matches path separators
-			wantErr:  false,
+			name:    "complex pattern",
+			path:    "src/components/Button.test.tsx",
+			filters: []Filter{{Type: FilterExclude, Pattern: "*.test.*"}},
+			want:    true, // With fnmatch, * matches path separators
+			wantErr: false,
 		},
 		{
-			name:     "complex pattern",
-			path:     "src/components/Button.test.tsx",
-			patterns: []string{"*.test.*"},
-			want:     true, // With fnmatch, * matches path separators
-			wantErr:  false,
+			name:    "case sensitive",
+			path:    "File.TXT",
+			filters: []Filter{{Type: FilterExclude, Pattern: "*.txt"}},
+			want:    false,
+			wantErr: false,
 		},
 		{
-			name:     "case sensitive",
-			path:     "File.TXT",
-			patterns: []string{"*.txt"},
-			want:     false,
-			wantErr:  false,
+			name:    "hidden files",
+			path:    ".git/config",
+			filters: []Filter{{Type: FilterExclude, Pattern: ".git/*"}},
+			want:    true,
+			wantErr: false,
 		},
 		{
-			name:     "hidden files",
-			path:     ".git/config",
-			patterns: []string{".git/*"},
-			want:     true,
-			wantErr:  false,
+			name:    "hidden file pattern",
+			path:    ".env",
+			filters: []Filter{{Type: FilterExclude, Pattern: ".*"}},
+			want:    true,
+			wantErr: false,
 		},
+		// Include/exclude ordering (later filters take precedence)
 		{
-			name:     "hidden file pattern",
-			path:     ".env",
-			patterns: []string{".*"},
-			want:     true,
-			wantErr:  false,
+			name: "include after exclude all",
+			path: "index.html",
+			filters: []Filter{
+				{Type: FilterExclude, Pattern: "*"},
+				{Type: FilterInclude, Pattern: "*.html"},
+			},
+			want:    false,
+			wantErr: false,
+		},
+		{
+			name: "exclude all with include not matching",
+			path: "style.css",
+			filters: []Filter{
+				{Type: FilterExclude, Pattern: "*"},
+				{Type: FilterInclude, Pattern: "*.html"},
+			},
+			want:    true,
+			wantErr: false,
+		},
+		{
+			name: "exclude after include overrides include",
+			path: "index.html",
+			filters: []Filter{
+				{Type: FilterInclude, Pattern: "*.html"},
+				{Type: FilterExclude, Pattern: "*"},
+			},
+			want:    true,
+			wantErr: false,
+		},
+		{
+			name: "include only does not exclude anything",
+			path: "style.css",
+			filters: []Filter{
+				{Type: FilterInclude, Pattern: "*.html"},
+			},
+			want:    false,
+			wantErr: false,
+		},
+		{
+			name: "re-exclude subdirectory after include",
+			path: "drafts/index.html",
+			filters: []Filter{
+				{Type: FilterExclude, Pattern: "*"},
+				{Type: FilterInclude, Pattern: "*.html"},
+				{Type: FilterExclude, Pattern: "drafts/*"},
+			},
+			want:    true,
+			wantErr: false,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := IsExcluded(tt.path, tt.patterns)
+			got, err := IsExcluded(tt.path, tt.filters)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("IsExcluded() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -493,3 +591,149 @@ func TestIsExcluded(t *testing.T) {
 		})
 	}
 }
+
+func TestPhase3GenerateDownloadPlan(t *testing.T) {
+	tests := []struct {
+		name      string
+		phase1    Phase1Result
+		checksums []ChecksumData
+		want      []Item
+	}{
+		{
+			name: "new and size mismatch files",
+			phase1: Phase1Result{
+				NewItems: []ItemRef{
+					{Path: "file1.txt", Size: 100},
+				},
+				SizeMismatch: []ItemRef{
+					{Path: "file2.txt", Size: 200},
+				},
+			},
+			checksums: []ChecksumData{},
+			want: []Item{
+				{
+					Action:    ActionDownload,
+					LocalPath: "/local/file1.txt",
+					Bucket:    "test-bucket",
+					Key:       "prefix/file1.txt",
+					Size:      100,
+					Reason:    "new file",
+				},
+				{
+					Action:    ActionDownload,
+					LocalPath: "/local/file2.txt",
+					Bucket:    "test-bucket",
+					Key:       "prefix/file2.txt",
+					Size:      200,
+					Reason:    "size differs",
+				},
+			},
+		},
+		{
+			name: "checksum differs and matches",
+			phase1: Phase1Result{
+				NeedChecksum: []ItemRef{
+					{Path: "changed.txt", Size: 100},
+					{Path: "same.txt", Size: 100},
+				},
+			},
+			checksums: []ChecksumData{
+				{
+					ItemRef:        ItemRef{Path: "changed.txt", Size: 100},
+					SourceChecksum: "abc123",
+					DestChecksum:   "def456",
+				},
+				{
+					ItemRef:        ItemRef{Path: "same.txt", Size: 100},
+					SourceChecksum: "abc123",
+					DestChecksum:   "abc123",
+				},
+			},
+			want: []Item{
+				{
+					Action:    ActionDownload,
+					LocalPath: "/local/changed.txt",
+					Bucket:    "test-bucket",
+					Key:       "prefix/changed.txt",
+					Size:      100,
+					Reason:    "checksum differs",
+				},
+				{
+					Action:    ActionSkip,
+					LocalPath: "/local/same.txt",
+					Bucket:    "test-bucket",
+					Key:       "prefix/same.txt",
+					Size:      100,
+					Reason:    "unchanged",
+				},
+			},
+		},
+		{
+			name: "deleted files are removed locally",
+			phase1: Phase1Result{
+				DeletedItems: []ItemRef{
+					{Path: "b.txt", Size: 100},
+					{Path: "a.txt", Size: 200},
+				},
+			},
+			checksums: []ChecksumData{},
+			want: []Item{
+				{
+					Action:    ActionDeleteLocal,
+					LocalPath: "/local/a.txt",
+					Size:      200,
+					Reason:    "deleted in source",
+				},
+				{
+					Action:    ActionDeleteLocal,
+					LocalPath: "/local/b.txt",
+					Size:      100,
+					Reason:    "deleted in source",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Phase3GenerateDownloadPlan(tt.phase1, tt.checksums, "test-bucket", "prefix", "/local")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Phase3GenerateDownloadPlan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPhase3GenerateCopyPlan(t *testing.T) {
+	phase1 := Phase1Result{
+		NewItems: []ItemRef{
+			{Path: "new.txt", Size: 100},
+		},
+		NeedChecksum: []ItemRef{
+			{Path: "changed.txt", Size: 200},
+			{Path: "no-checksum.txt", Size: 300},
+			{Path: "same.txt", Size: 400},
+		},
+		DeletedItems: []ItemRef{
+			{Path: "old.txt", Size: 500},
+		},
+	}
+	checksums := []ChecksumData{
+		{ItemRef: ItemRef{Path: "changed.txt", Size: 200}, SourceChecksum: "abc", DestChecksum: "def"},
+		{ItemRef: ItemRef{Path: "no-checksum.txt", Size: 300}, SourceChecksum: "", DestChecksum: ""},
+		{ItemRef: ItemRef{Path: "same.txt", Size: 400}, SourceChecksum: "abc", DestChecksum: "abc"},
+	}
+
+	want := []Item{
+		{Action: ActionCopy, SourceBucket: "src-bucket", SourceKey: "src/changed.txt", Bucket: "dst-bucket", Key: "dst/changed.txt", Size: 200, Reason: "checksum differs"},
+		{Action: ActionCopy, SourceBucket: "src-bucket", SourceKey: "src/new.txt", Bucket: "dst-bucket", Key: "dst/new.txt", Size: 100, Reason: "new file"},
+		{Action: ActionCopy, SourceBucket: "src-bucket", SourceKey: "src/no-checksum.txt", Bucket: "dst-bucket", Key: "dst/no-checksum.txt", Size: 300, Reason: "checksum differs"},
+		{Action: ActionDelete, Bucket: "dst-bucket", Key: "dst/old.txt", Size: 500, Reason: "deleted in source"},
+		{Action: ActionSkip, SourceBucket: "src-bucket", SourceKey: "src/same.txt", Bucket: "dst-bucket", Key: "dst/same.txt", Size: 400, Reason: "unchanged"},
+	}
+
+	got := Phase3GenerateCopyPlan(phase1, checksums, "src-bucket", "src", "dst-bucket", "dst")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Phase3GenerateCopyPlan() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,155 @@
+package planner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/logger"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+type S3ToFSPlanner struct {
+	client s3client.Client
+	logger logger.Logger
+}
+
+func NewS3ToFSPlanner(client s3client.Client, logger logger.Logger) *S3ToFSPlanner {
+	return &S3ToFSPlanner{
+		client: client,
+		logger: logger,
+	}
+}
+
+func (p *S3ToFSPlanner) Plan(ctx context.Context, source Source, dest Destination, opts Options) ([]Item, error) {
+	return collectPlan(func(items chan<- Item) error {
+		return p.PlanStream(ctx, source, dest, opts, items)
+	})
+}
+
+func (p *S3ToFSPlanner) PlanStream(ctx context.Context, source Source, dest Destination, opts Options, items chan<- Item) error {
+	defer close(items)
+
+	if source.Type != SourceTypeS3 {
+		return fmt.Errorf("source must be s3, got %s", source.Type)
+	}
+	if dest.Type != DestTypeFileSystem {
+		return fmt.Errorf("destination must be filesystem, got %s", dest.Type)
+	}
+
+	bucket, prefix, err := parseS3URI(source.Path)
+	if err != nil {
+		return fmt.Errorf("invalid S3 URI: %w", err)
+	}
+
+	// The destination directory is created on download if it does not exist yet
+	localFiles := emptyListing
+	if _, err := os.Stat(dest.Path); err == nil {
+		localFiles = localListing(dest.Path, opts.Filters, opts.KeepGoing)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat destination: %w", err)
+	}
+
+	return streamPlan(ctx,
+		s3Listing(ctx, p.client, bucket, prefix, opts.Filters),
+		localFiles,
+		func(ctx context.Context, listed, localFiles []ItemMetadata) ([]Item, error) {
+			return p.planChunk(ctx, listed, localFiles, bucket, prefix, dest.Path, opts)
+		},
+		items)
+}
+
+// planChunk plans the objects and local files of a chunk of paths.
+func (p *S3ToFSPlanner) planChunk(ctx context.Context, listed []ItemMetadata, localFiles []ItemMetadata, bucket string, prefix string, localBase string, opts Options) ([]Item, error) {
+	// Directory markers ("dir/") have no corresponding local file
+	s3Objects := []ItemMetadata{}
+	var unsafeErrs ItemErrors
+	for _, obj := range listed {
+		if obj.Path == "" || strings.HasSuffix(obj.Path, "/") {
+			continue
+		}
+		// Keys that would be written outside the destination are never
+		// downloaded
+		if err := checkLocalPath(localBase, obj.Path); err != nil {
+			err = fmt.Errorf("refusing to download s3://%s/%s: %w", bucket, objectKey(prefix, obj.Path), err)
+			if !opts.KeepGoing {
+				return nil, err
+			}
+			unsafeErrs = append(unsafeErrs, &ItemError{Path: obj.Path, Err: err})
+			continue
+		}
+		s3Objects = append(s3Objects, obj)
+	}
+
+	phase1Result := Phase1Compare(s3Objects, localFiles, opts.DeleteEnabled)
+	phase1Result = ComparePhase1(phase1Result, s3Objects, localFiles, opts.comparator())
+	phase1Result = skipCompleted(phase1Result, opts.Journal, ActionDownload, downloadItem(bucket, prefix, localBase))
+
+	checksums, err := p.phase2CollectChecksums(ctx, phase1Result.NeedChecksum, localBase, bucket, prefix, opts)
+	// Items that could not be compared are left out of the plan
+	itemErrs, err := splitItemErrors(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect checksums: %w", err)
+	}
+	phase1Result = ComparePhase2(phase1Result, checksums, s3Objects, localFiles, opts.comparator())
+
+	itemErrs = append(unsafeErrs, itemErrs...)
+	slices.SortFunc(itemErrs, func(a, b *ItemError) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return Phase3GenerateDownloadPlan(phase1Result, checksums, bucket, prefix, localBase), itemErrs.orNil()
+}
+
+// checkLocalPath returns an error if the object at relPath, relative to the
+// source prefix, would not be written inside localBase. Unlike file paths,
+// keys may have ".." segments and start with "/".
+func checkLocalPath(localBase string, relPath string) error {
+	if strings.HasPrefix(relPath, "/") {
+		return fmt.Errorf("key starts with /")
+	}
+	if slices.Contains(strings.Split(relPath, "/"), "..") {
+		return fmt.Errorf("key has a .. segment")
+	}
+	localPath := filepath.Join(localBase, filepath.FromSlash(relPath))
+	if rel, err := filepath.Rel(localBase, localPath); err != nil || !filepath.IsLocal(rel) {
+		return fmt.Errorf("%s is outside %s", localPath, localBase)
+	}
+	return nil
+}
+
+// objectKey returns the key of the object listed as relPath under prefix.
+// Unlike path.Join it keeps the key as listed, e.g. with "//" in it.
+func objectKey(prefix string, relPath string) string {
+	if prefix == "" {
+		return relPath
+	}
+	return prefix + "/" + relPath
+}
+
+// Phase2CollectChecksums retrieves the source checksums with HeadObject and
+// calculates the destination checksums from the local files.
+func (p *S3ToFSPlanner) Phase2CollectChecksums(ctx context.Context, items []ItemRef, localBase string, bucket string, prefix string) ([]ChecksumData, error) {
+	return p.phase2CollectChecksums(ctx, items, localBase, bucket, prefix, Options{})
+}
+
+func (p *S3ToFSPlanner) phase2CollectChecksums(ctx context.Context, items []ItemRef, localBase string, bucket string, prefix string, opts Options) ([]ChecksumData, error) {
+	needs := opts.comparator().Needs()
+	return collectChecksums(ctx, items, opts, func(ctx context.Context, pools *phase2Pools, item ItemRef) (ChecksumData, error) {
+		s3Key := objectKey(prefix, item.Path)
+		algorithm, destChecksum, sourceChecksum, metadata, err := fetchLocalRemote(ctx, p.client, pools, needs, opts.ChecksumCache, localBase, item.Path, bucket, s3Key, "", checksumAlgorithm(opts.ChecksumAlgorithm))
+		if err != nil {
+			return ChecksumData{}, err
+		}
+
+		return ChecksumData{
+			ItemRef:        item,
+			SourceChecksum: sourceChecksum,
+			DestChecksum:   destChecksum,
+			Algorithm:      algorithm,
+			SourceMetadata: metadata,
+		}, nil
+	})
+}
@@ -0,0 +1,185 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client/memory"
+)
+
+func TestS3ToFSPlanner_Plan(t *testing.T) {
+	localBase := t.TempDir()
+	files := map[string]string{
+		"same.txt":    "Hello, World!\n",
+		"changed.txt": "Hello, World?\n",
+		"resized.txt": "short",
+		"extra.txt":   "only local",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(localBase, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			return []s3client.ItemMetadata{
+				{Path: "dir/", Size: 0},
+				{Path: "same.txt", Size: 14},
+				{Path: "changed.txt", Size: 14},
+				{Path: "resized.txt", Size: 100},
+				{Path: "new.txt", Size: 10},
+			}, nil
+		},
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			// "SoXXbx67KpE=" is the CRC64NVME of "Hello, World!\n"
+			return &s3client.ObjectInfo{Size: 14, Checksum: "SoXXbx67KpE="}, nil
+		},
+	}
+
+	p := NewS3ToFSPlanner(client, &mockLogger{})
+	got, err := p.Plan(context.Background(),
+		Source{Type: SourceTypeS3, Path: "s3://test-bucket/prefix"},
+		Destination{Type: DestTypeFileSystem, Path: localBase},
+		Options{DeleteEnabled: true},
+	)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	want := []Item{
+		{Action: ActionDeleteLocal, LocalPath: filepath.Join(localBase, "extra.txt"), Size: 10, Reason: "deleted in source"},
+		{Action: ActionDownload, LocalPath: filepath.Join(localBase, "changed.txt"), Bucket: "test-bucket", Key: "prefix/changed.txt", Size: 14, Reason: "checksum differs"},
+		{Action: ActionDownload, LocalPath: filepath.Join(localBase, "new.txt"), Bucket: "test-bucket", Key: "prefix/new.txt", Size: 10, Reason: "new file"},
+		{Action: ActionDownload, LocalPath: filepath.Join(localBase, "resized.txt"), Bucket: "test-bucket", Key: "prefix/resized.txt", Size: 100, Reason: "size differs"},
+		{Action: ActionSkip, LocalPath: filepath.Join(localBase, "same.txt"), Bucket: "test-bucket", Key: "prefix/same.txt", Size: 14, Reason: "unchanged"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %+v, want %+v", got, want)
+	}
+}
+
+func TestS3ToFSPlanner_PlanMissingDestination(t *testing.T) {
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			return []s3client.ItemMetadata{{Path: "file.txt", Size: 10}}, nil
+		},
+	}
+
+	localBase := filepath.Join(t.TempDir(), "does-not-exist")
+	p := NewS3ToFSPlanner(client, &mockLogger{})
+	got, err := p.Plan(context.Background(),
+		Source{Type: SourceTypeS3, Path: "s3://test-bucket"},
+		Destination{Type: DestTypeFileSystem, Path: localBase},
+		Options{},
+	)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	want := []Item{
+		{Action: ActionDownload, LocalPath: filepath.Join(localBase, "file.txt"), Bucket: "test-bucket", Key: "file.txt", Size: 10, Reason: "new file"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %+v, want %+v", got, want)
+	}
+}
+
+func TestS3ToFSPlanner_PlanUnsafeKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		key     string
+		safeKey string
+	}{
+		{name: "parent directory", source: "s3://test-bucket", key: "../escaped.txt", safeKey: "safe.txt"},
+		{name: "parent directory under prefix", source: "s3://test-bucket/prefix", key: "prefix/../escaped.txt", safeKey: "prefix/safe.txt"},
+		{name: "parent directory within the destination", source: "s3://test-bucket", key: "dir/../file.txt", safeKey: "safe.txt"},
+		{name: "absolute path", source: "s3://test-bucket", key: "/etc/escaped.txt", safeKey: "safe.txt"},
+		{name: "absolute path under prefix", source: "s3://test-bucket/prefix", key: "prefix//etc/escaped.txt", safeKey: "prefix/safe.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := memory.New()
+			client.PutBytes("test-bucket", tt.key, []byte("escaped"))
+			client.PutBytes("test-bucket", tt.safeKey, []byte("safe"))
+
+			localBase := filepath.Join(t.TempDir(), "dest")
+			p := NewS3ToFSPlanner(client, &mockLogger{})
+			source := Source{Type: SourceTypeS3, Path: tt.source}
+			dest := Destination{Type: DestTypeFileSystem, Path: localBase}
+
+			if _, err := p.Plan(context.Background(), source, dest, Options{}); err == nil {
+				t.Fatal("Plan() error = nil, want an error for the unsafe key")
+			}
+
+			// With --keep-going the other objects are still downloaded
+			got, err := p.Plan(context.Background(), source, dest, Options{KeepGoing: true})
+			var itemErrs ItemErrors
+			if !errors.As(err, &itemErrs) || len(itemErrs) != 1 {
+				t.Fatalf("Plan() error = %v, want ItemErrors for the unsafe key", err)
+			}
+			if len(got) != 1 || got[0].LocalPath != filepath.Join(localBase, "safe.txt") {
+				t.Errorf("Plan() = %+v, want only safe.txt", got)
+			}
+		})
+	}
+}
+
+func TestS3ToFSPlanner_PlanKeepsListedKeys(t *testing.T) {
+	client := memory.New()
+	client.PutBytes("test-bucket", "prefix/a//b.txt", []byte("double slash"))
+
+	localBase := t.TempDir()
+	p := NewS3ToFSPlanner(client, &mockLogger{})
+	got, err := p.Plan(context.Background(),
+		Source{Type: SourceTypeS3, Path: "s3://test-bucket/prefix"},
+		Destination{Type: DestTypeFileSystem, Path: localBase},
+		Options{},
+	)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	// GetObject must ask for the key that was listed, not a cleaned one
+	want := []Item{
+		{Action: ActionDownload, LocalPath: filepath.Join(localBase, "a", "b.txt"), Bucket: "test-bucket", Key: "prefix/a//b.txt", Size: 12, Reason: "new file"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCheckLocalPath(t *testing.T) {
+	localBase := filepath.Join(t.TempDir(), "dest")
+	tests := []struct {
+		relPath string
+		wantErr bool
+	}{
+		{relPath: "file.txt"},
+		{relPath: "dir/file.txt"},
+		{relPath: "dir//file.txt"},
+		{relPath: "..file.txt"},
+		{relPath: "../file.txt", wantErr: true},
+		{relPath: "dir/../../file.txt", wantErr: true},
+		{relPath: "dir/../file.txt", wantErr: true},
+		{relPath: "..", wantErr: true},
+		{relPath: "/file.txt", wantErr: true},
+		{relPath: "//file.txt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.relPath, func(t *testing.T) {
+			err := checkLocalPath(localBase, tt.relPath)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkLocalPath(%q) error = %v, wantErr %v", tt.relPath, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package planner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/logger"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+type S3ToS3Planner struct {
+	client s3client.Client
+	logger logger.Logger
+}
+
+func NewS3ToS3Planner(client s3client.Client, logger logger.Logger) *S3ToS3Planner {
+	return &S3ToS3Planner{
+		client: client,
+		logger: logger,
+	}
+}
+
+func (p *S3ToS3Planner) Plan(ctx context.Context, source Source, dest Destination, opts Options) ([]Item, error) {
+	return collectPlan(func(items chan<- Item) error {
+		return p.PlanStream(ctx, source, dest, opts, items)
+	})
+}
+
+func (p *S3ToS3Planner) PlanStream(ctx context.Context, source Source, dest Destination, opts Options, items chan<- Item) error {
+	defer close(items)
+
+	if source.Type != SourceTypeS3 {
+		return fmt.Errorf("source must be s3, got %s", source.Type)
+	}
+	if dest.Type != DestTypeS3 {
+		return fmt.Errorf("destination must be s3, got %s", dest.Type)
+	}
+
+	sourceBucket, sourcePrefix, err := parseS3URI(source.Path)
+	if err != nil {
+		return fmt.Errorf("invalid source S3 URI: %w", err)
+	}
+
+	bucket, prefix, err := parseS3URI(dest.Path)
+	if err != nil {
+		return fmt.Errorf("invalid destination S3 URI: %w", err)
+	}
+
+	return streamPlan(ctx,
+		s3Listing(ctx, p.client, sourceBucket, sourcePrefix, opts.Filters),
+		s3Listing(ctx, p.client, bucket, prefix, opts.Filters),
+		func(ctx context.Context, sourceObjects, destObjects []ItemMetadata) ([]Item, error) {
+			return p.planChunk(ctx, sourceObjects, destObjects, sourceBucket, sourcePrefix, bucket, prefix, opts)
+		},
+		items)
+}
+
+// planChunk plans the source and destination objects of a chunk of paths.
+func (p *S3ToS3Planner) planChunk(ctx context.Context, sourceObjects []ItemMetadata, destObjects []ItemMetadata, sourceBucket string, sourcePrefix string, bucket string, prefix string, opts Options) ([]Item, error) {
+	phase1Result := Phase1Compare(sourceObjects, destObjects, opts.DeleteEnabled)
+	phase1Result = ComparePhase1(phase1Result, sourceObjects, destObjects, opts.comparator())
+	phase1Result = skipCompleted(phase1Result, opts.Journal, ActionCopy, copyItem(sourceBucket, sourcePrefix, bucket, prefix))
+
+	checksums, err := p.phase2CollectChecksums(ctx, phase1Result.NeedChecksum, sourceBucket, sourcePrefix, bucket, prefix, opts)
+	// Items that could not be compared are left out of the plan
+	itemErrs, err := splitItemErrors(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect checksums: %w", err)
+	}
+	phase1Result = ComparePhase2(phase1Result, checksums, sourceObjects, destObjects, opts.comparator())
+
+	return Phase3GenerateCopyPlan(phase1Result, checksums, sourceBucket, sourcePrefix, bucket, prefix), itemErrs.orNil()
+}
+
+// Phase2CollectChecksums retrieves the checksums of both the source and the
+// destination objects with HeadObject. No data is transferred.
+func (p *S3ToS3Planner) Phase2CollectChecksums(ctx context.Context, items []ItemRef, sourceBucket string, sourcePrefix string, bucket string, prefix string) ([]ChecksumData, error) {
+	return p.phase2CollectChecksums(ctx, items, sourceBucket, sourcePrefix, bucket, prefix, Options{})
+}
+
+func (p *S3ToS3Planner) phase2CollectChecksums(ctx context.Context, items []ItemRef, sourceBucket string, sourcePrefix string, bucket string, prefix string, opts Options) ([]ChecksumData, error) {
+	needs := opts.comparator().Needs()
+	if !needs.RemoteChecksum && !needs.Metadata {
+		// Nothing to look up, every item is compared as listed
+		data := make([]ChecksumData, len(items))
+		for i, item := range items {
+			data[i] = ChecksumData{ItemRef: item}
+		}
+		return data, nil
+	}
+
+	return collectChecksums(ctx, items, opts, func(ctx context.Context, pools *phase2Pools, item ItemRef) (ChecksumData, error) {
+		// Both objects are looked up at the same time
+		sourceKey := objectKey(sourcePrefix, item.Path)
+		var sourceInfo *s3client.ObjectInfo
+		var sourceErr error
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			sourceInfo, sourceErr = pools.headObject(ctx, p.client, &s3client.HeadObjectRequest{
+				Bucket: sourceBucket,
+				Key:    sourceKey,
+			})
+		}()
+
+		destKey := objectKey(prefix, item.Path)
+		destInfo, err := pools.headObject(ctx, p.client, &s3client.HeadObjectRequest{
+			Bucket: bucket,
+			Key:    destKey,
+		})
+		<-done
+		if sourceErr != nil {
+			return ChecksumData{}, fmt.Errorf("failed to head object %s: %w", sourceKey, sourceErr)
+		}
+		if err != nil {
+			return ChecksumData{}, fmt.Errorf("failed to head object %s: %w", destKey, err)
+		}
+
+		data := ChecksumData{
+			ItemRef:        item,
+			SourceMetadata: sourceInfo.Metadata,
+			DestMetadata:   destInfo.Metadata,
+		}
+		if needs.RemoteChecksum {
+			data.Algorithm, data.SourceChecksum, data.DestChecksum = commonChecksums(sourceInfo, destInfo)
+		}
+		return data, nil
+	})
+}
@@ -0,0 +1,122 @@
+package planner
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client/memory"
+)
+
+func TestS3ToS3Planner_Plan(t *testing.T) {
+	objects := map[string][]s3client.ItemMetadata{
+		"staging": {
+			{Path: "app.js", Size: 100},
+			{Path: "index.html", Size: 200},
+			{Path: "new.css", Size: 300},
+		},
+		"prod": {
+			{Path: "app.js", Size: 100},
+			{Path: "index.html", Size: 200},
+			{Path: "removed.txt", Size: 400},
+		},
+	}
+	checksums := map[string]string{
+		"staging/app.js":     "same",
+		"prod/app.js":        "same",
+		"staging/index.html": "new-html",
+		"prod/index.html":    "old-html",
+	}
+
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			return objects[req.Bucket], nil
+		},
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			checksum, ok := checksums[req.Bucket+"/"+req.Key]
+			if !ok {
+				return nil, fmt.Errorf("unexpected call: %s/%s", req.Bucket, req.Key)
+			}
+			return &s3client.ObjectInfo{Checksum: checksum}, nil
+		},
+	}
+
+	p := NewS3ToS3Planner(client, &mockLogger{})
+	got, err := p.Plan(context.Background(),
+		Source{Type: SourceTypeS3, Path: "s3://staging"},
+		Destination{Type: DestTypeS3, Path: "s3://prod/"},
+		Options{DeleteEnabled: true},
+	)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	want := []Item{
+		{Action: ActionCopy, SourceBucket: "staging", SourceKey: "index.html", Bucket: "prod", Key: "index.html", Size: 200, Reason: "checksum differs"},
+		{Action: ActionCopy, SourceBucket: "staging", SourceKey: "new.css", Bucket: "prod", Key: "new.css", Size: 300, Reason: "new file"},
+		{Action: ActionDelete, Bucket: "prod", Key: "removed.txt", Size: 400, Reason: "deleted in source"},
+		{Action: ActionSkip, SourceBucket: "staging", SourceKey: "app.js", Bucket: "prod", Key: "app.js", Size: 100, Reason: "unchanged"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %+v, want %+v", got, want)
+	}
+}
+
+func TestS3ToS3Planner_PlanKeepsListedKeys(t *testing.T) {
+	client := memory.New()
+	client.PutBytes("src", "p/a//b", []byte("double slash"))
+	client.PutBytes("dst", "q/a//b", []byte("double slash"))
+	client.PutBytes("dst", "q/a/b", []byte("single slash"))
+
+	p := NewS3ToS3Planner(client, &mockLogger{})
+	got, err := p.Plan(context.Background(),
+		Source{Type: SourceTypeS3, Path: "s3://src/p"},
+		Destination{Type: DestTypeS3, Path: "s3://dst/q"},
+		Options{DeleteEnabled: true},
+	)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	// "a/b" is not in the source and "a//b" must be compared with itself
+	want := []Item{
+		{Action: ActionDelete, Bucket: "dst", Key: "q/a/b", Size: 12, Reason: "deleted in source"},
+		{Action: ActionSkip, SourceBucket: "src", SourceKey: "p/a//b", Bucket: "dst", Key: "q/a//b", Size: 12, Reason: "unchanged"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCommonChecksums(t *testing.T) {
+	crc64 := &s3client.ObjectInfo{Checksum: "crc64"}
+	sha256Only := &s3client.ObjectInfo{Checksums: map[string]string{s3client.ChecksumAlgorithmSHA256: "sha256"}}
+	both := &s3client.ObjectInfo{Checksum: "crc64", Checksums: map[string]string{
+		s3client.ChecksumAlgorithmCRC64NVME: "crc64",
+		s3client.ChecksumAlgorithmSHA256:    "sha256",
+	}}
+
+	tests := []struct {
+		name          string
+		a, b          *s3client.ObjectInfo
+		wantAlgorithm string
+		wantA, wantB  string
+	}{
+		{name: "both CRC64NVME", a: crc64, b: both, wantA: "crc64", wantB: "crc64"},
+		{name: "falls back to the algorithm of b", a: both, b: sha256Only, wantAlgorithm: "SHA256", wantA: "sha256", wantB: "sha256"},
+		{name: "algorithm of a in b", a: sha256Only, b: both, wantAlgorithm: "SHA256", wantA: "sha256", wantB: "sha256"},
+		{name: "no common algorithm", a: crc64, b: sha256Only, wantA: "crc64", wantB: ""},
+		{name: "no checksums", a: &s3client.ObjectInfo{}, b: crc64, wantA: "", wantB: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			algorithm, a, b := commonChecksums(tt.a, tt.b)
+			if algorithm != tt.wantAlgorithm || a != tt.wantA || b != tt.wantB {
+				t.Errorf("commonChecksums() = %q, %q, %q, want %q, %q, %q", algorithm, a, b, tt.wantAlgorithm, tt.wantA, tt.wantB)
+			}
+		})
+	}
+}
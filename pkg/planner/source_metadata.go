@@ -0,0 +1,134 @@
+package planner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+// User metadata keys of the source metadata stored with uploaded objects,
+// without the x-amz-meta- prefix.
+const (
+	MetadataChecksum = "source-crc64nvme"
+	MetadataSize     = "source-size"
+	MetadataModTime  = "source-mtime"
+)
+
+// SourceMetadata returns the user metadata recording checksum, the CRC64NVME
+// checksum of the local file described by info, along with its size and
+// mtime.
+func SourceMetadata(checksum string, info os.FileInfo) map[string]string {
+	return map[string]string{
+		MetadataChecksum: checksum,
+		MetadataSize:     strconv.FormatInt(info.Size(), 10),
+		MetadataModTime:  strconv.FormatInt(info.ModTime().UnixNano(), 10),
+	}
+}
+
+// SourceMetadataComparator compares CRC64NVME checksums like
+// StrictComparator, using the source metadata stored with objects uploaded
+// with SourceMetadata. A local file that still has the stored size and mtime
+// is not read again, and an object without a CRC64NVME checksum of its own
+// is compared by the stored one. Objects without source metadata are
+// compared like with StrictComparator. With a ChecksumCache that is an
+// ObjectCache, objects still listed with the ETag they had when they were
+// last headed are not headed again.
+type SourceMetadataComparator struct {
+	StrictComparator
+}
+
+func (SourceMetadataComparator) Needs() Needs {
+	return Needs{LocalChecksum: true, RemoteChecksum: true, SourceMetadata: true}
+}
+
+// sourceMetadataChecksums returns the CRC64NVME checksums of the local file
+// at relPath under localBase and of obj, taking them from the source metadata
+// of obj where it can be trusted.
+func sourceMetadataChecksums(ctx context.Context, pools *phase2Pools, cache ChecksumCache, localBase string, relPath string, obj *s3client.ObjectInfo) (local, remote string, err error) {
+	stored, size, modTime, ok := storedSourceMetadata(obj.Metadata)
+
+	remote = obj.ChecksumIn(s3client.ChecksumAlgorithmCRC64NVME)
+	if remote == "" && ok {
+		remote = stored
+	}
+
+	if ok {
+		info, err := os.Stat(filepath.Join(localBase, relPath))
+		if err != nil {
+			return "", "", err
+		}
+		if info.Size() == size && info.ModTime().Equal(modTime) {
+			return stored, remote, nil
+		}
+	}
+
+	err = pools.hash(ctx, func() error {
+		local, err = localChecksum(cache, localBase, relPath, "", pools.fileConcurrency)
+		return err
+	})
+	return local, remote, err
+}
+
+// localSourceMetadata returns the source metadata of the local file at
+// relPath under localBase, for a file that was not compared in Phase 2.
+func localSourceMetadata(cache ChecksumCache, localBase string, relPath string) (map[string]string, error) {
+	// Stat first, so that a file modified while it is read doesn't get a
+	// newer mtime recorded than its checksum
+	info, err := os.Stat(filepath.Join(localBase, relPath))
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := localChecksum(cache, localBase, relPath, "", runtime.GOMAXPROCS(0))
+	if err != nil {
+		return nil, err
+	}
+	return SourceMetadata(checksum, info), nil
+}
+
+// cachedObject returns what cache stored of the object at relPath when it was
+// last headed, or nil if cache doesn't store objects or the object has been
+// written since, i.e. is no longer listed with etag.
+func cachedObject(cache ChecksumCache, relPath string, etag string) *s3client.ObjectInfo {
+	objects, ok := cache.(ObjectCache)
+	if !ok || etag == "" {
+		return nil
+	}
+	checksum, metadata, ok := objects.GetObject(relPath, etag)
+	if !ok {
+		return nil
+	}
+	return &s3client.ObjectInfo{ETag: etag, Checksum: checksum, Metadata: metadata}
+}
+
+// cacheObject stores what sourceMetadataChecksums uses of obj, the object
+// at relPath, if cache stores objects.
+func cacheObject(cache ChecksumCache, relPath string, obj *s3client.ObjectInfo) {
+	objects, ok := cache.(ObjectCache)
+	if !ok || obj.ETag == "" {
+		return
+	}
+	objects.PutObject(relPath, obj.ETag, obj.ChecksumIn(s3client.ChecksumAlgorithmCRC64NVME), obj.Metadata)
+}
+
+// storedSourceMetadata parses the source metadata written by SourceMetadata.
+// ok is false if metadata has none or it is incomplete.
+func storedSourceMetadata(metadata map[string]string) (checksum string, size int64, modTime time.Time, ok bool) {
+	checksum = metadata[MetadataChecksum]
+	if checksum == "" {
+		return "", 0, time.Time{}, false
+	}
+	size, err := strconv.ParseInt(metadata[MetadataSize], 10, 64)
+	if err != nil {
+		return "", 0, time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(metadata[MetadataModTime], 10, 64)
+	if err != nil {
+		return "", 0, time.Time{}, false
+	}
+	return checksum, size, time.Unix(0, nanos), true
+}
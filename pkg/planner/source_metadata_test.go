@@ -0,0 +1,207 @@
+package planner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client/memory"
+)
+
+func TestFSToS3Planner_PlanSourceMetadata(t *testing.T) {
+	localBase := t.TempDir()
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	files := []string{"trusted.txt", "touched.txt", "changed.txt", "native.txt", "plain.txt", "composite.txt"}
+	for _, name := range files {
+		localPath := filepath.Join(localBase, name)
+		if err := os.WriteFile(localPath, []byte("Hello, World!\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(localPath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(filepath.Join(localBase, "trusted.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := map[string]*s3client.ObjectInfo{
+		// The stored checksum is trusted without reading the file
+		"trusted.txt": {Size: 14, Metadata: SourceMetadata("AAAAAAAAAAA=", info)},
+		// A touched file is read again and compared with the stored checksum
+		"touched.txt": {Size: 14, Metadata: map[string]string{MetadataChecksum: "SoXXbx67KpE=", MetadataSize: "14", MetadataModTime: "0"}},
+		"changed.txt": {Size: 14, Metadata: map[string]string{MetadataChecksum: "CXIbLYbJFB0=", MetadataSize: "14", MetadataModTime: "0"}},
+		// Overwritten by another tool after it was uploaded with metadata
+		"native.txt": {Size: 14, Checksum: "CXIbLYbJFB0=", Checksums: map[string]string{s3client.ChecksumAlgorithmCRC64NVME: "CXIbLYbJFB0="}, Metadata: SourceMetadata("SoXXbx67KpE=", info)},
+		"plain.txt":  {Size: 14},
+		"composite.txt": {
+			Size:         14,
+			Checksums:    map[string]string{s3client.ChecksumAlgorithmCRC32: "AAAAAA==-2"},
+			ChecksumType: s3client.ChecksumTypeComposite,
+			PartCount:    2,
+			PartSize:     8,
+			Metadata:     SourceMetadata("SoXXbx67KpE=", info),
+		},
+	}
+
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			var items []s3client.ItemMetadata
+			for _, name := range files {
+				items = append(items, s3client.ItemMetadata{Path: name, Size: 14})
+			}
+			return items, nil
+		},
+		headObjectFunc: func(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+			return objects[req.Key], nil
+		},
+	}
+
+	p := NewFSToS3Planner(client, &mockLogger{})
+	got, err := p.Plan(context.Background(),
+		Source{Type: SourceTypeFileSystem, Path: localBase},
+		Destination{Type: DestTypeS3, Path: "s3://test-bucket"},
+		Options{Comparator: SourceMetadataComparator{}},
+	)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	item := func(action Action, name string, reason string) Item {
+		return Item{Action: action, LocalPath: filepath.Join(localBase, name), Bucket: "test-bucket", Key: name, Size: 14, Reason: reason}
+	}
+	// Uploads carry the metadata to store, so that the executor doesn't
+	// read the files again
+	upload := func(name string) Item {
+		item := item(ActionUpload, name, "checksum differs")
+		item.Checksum = "SoXXbx67KpE="
+		item.Metadata = SourceMetadata("SoXXbx67KpE=", info)
+		return item
+	}
+	want := []Item{
+		item(ActionSkip, "composite.txt", "unchanged"),
+		item(ActionSkip, "touched.txt", "unchanged"),
+		item(ActionSkip, "trusted.txt", "unchanged"),
+		upload("changed.txt"),
+		upload("native.txt"),
+		upload("plain.txt"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %+v, want %+v", got, want)
+	}
+}
+
+// objectMapCache is a mapCache that also stores objects.
+type objectMapCache struct {
+	mapCache
+	objects map[string]cachedObjectInfo
+}
+
+type cachedObjectInfo struct {
+	etag     string
+	checksum string
+	metadata map[string]string
+}
+
+func (c objectMapCache) GetObject(path string, etag string) (string, map[string]string, bool) {
+	obj, ok := c.objects[path]
+	if !ok || obj.etag != etag {
+		return "", nil, false
+	}
+	return obj.checksum, obj.metadata, true
+}
+
+func (c objectMapCache) PutObject(path string, etag string, checksum string, metadata map[string]string) {
+	c.objects[path] = cachedObjectInfo{etag: etag, checksum: checksum, metadata: metadata}
+}
+
+func TestFSToS3Planner_PlanSourceMetadataCachesObjects(t *testing.T) {
+	localBase := t.TempDir()
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	client := memory.New()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		localPath := filepath.Join(localBase, name)
+		if err := os.WriteFile(localPath, []byte("Hello, World!\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(localPath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(localPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.SetObject("test-bucket", memory.Object{Key: name, Data: []byte("Hello, World!\n"), Metadata: SourceMetadata("SoXXbx67KpE=", info)})
+	}
+
+	cache := objectMapCache{mapCache: mapCache{}, objects: map[string]cachedObjectInfo{}}
+	plan := func() []Item {
+		t.Helper()
+		p := NewFSToS3Planner(client, &mockLogger{})
+		got, err := p.Plan(context.Background(),
+			Source{Type: SourceTypeFileSystem, Path: localBase},
+			Destination{Type: DestTypeS3, Path: "s3://test-bucket"},
+			Options{Comparator: SourceMetadataComparator{}, ChecksumCache: cache},
+		)
+		if err != nil {
+			t.Fatalf("Plan() error = %v", err)
+		}
+		return got
+	}
+
+	plan()
+	if heads := client.Calls(memory.OpHeadObject); heads != 2 {
+		t.Fatalf("HeadObject calls = %d, want 2", heads)
+	}
+
+	// Objects still listed with the same ETag are not headed again
+	for _, item := range plan() {
+		if item.Action != ActionSkip {
+			t.Errorf("item %s planned to %s, want skip", item.Key, item.Action)
+		}
+	}
+	if heads := client.Calls(memory.OpHeadObject); heads != 2 {
+		t.Errorf("HeadObject calls = %d, want still 2", heads)
+	}
+
+	// An object written since is headed again
+	client.PutBytes("test-bucket", "b.txt", []byte("Hello, World?\n"))
+	got := plan()
+	if heads := client.Calls(memory.OpHeadObject); heads != 3 {
+		t.Errorf("HeadObject calls = %d, want 3", heads)
+	}
+	if len(got) != 2 || got[1].Key != "b.txt" || got[1].Action != ActionUpload {
+		t.Errorf("Plan() = %+v, want b.txt to be uploaded", got)
+	}
+}
+
+func TestStoredSourceMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		wantOK   bool
+	}{
+		{name: "complete", metadata: map[string]string{MetadataChecksum: "SoXXbx67KpE=", MetadataSize: "14", MetadataModTime: "1700000000000000000"}, wantOK: true},
+		{name: "none"},
+		{name: "missing checksum", metadata: map[string]string{MetadataSize: "14", MetadataModTime: "1700000000000000000"}},
+		{name: "invalid size", metadata: map[string]string{MetadataChecksum: "SoXXbx67KpE=", MetadataSize: "large", MetadataModTime: "1700000000000000000"}},
+		{name: "missing mtime", metadata: map[string]string{MetadataChecksum: "SoXXbx67KpE=", MetadataSize: "14"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checksum, size, modTime, ok := storedSourceMetadata(tt.metadata)
+			if ok != tt.wantOK {
+				t.Fatalf("storedSourceMetadata() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (checksum != "SoXXbx67KpE=" || size != 14 || !modTime.Equal(time.Unix(0, 1700000000000000000))) {
+				t.Errorf("storedSourceMetadata() = %q, %d, %v", checksum, size, modTime)
+			}
+		})
+	}
+}
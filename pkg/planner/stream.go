@@ -0,0 +1,343 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+// streamChunkSize is how many paths PlanStream compares at a time, as many
+// as S3 returns in a ListObjectsV2 page.
+const streamChunkSize = 1000
+
+// StreamPlanner is implemented by planners that send the items of a plan as
+// they are decided, instead of returning the whole plan at once. Memory use
+// doesn't grow with the number of files and objects.
+type StreamPlanner interface {
+	Planner
+	// PlanStream sends the items of the plan to items and closes it when
+	// done, also on error. Source and destination are merge-joined in
+	// lexical order of their paths and compared a chunk at a time, so
+	// items are only sorted within a chunk. With Options.KeepGoing, the
+	// ItemErrors of items that could not be compared are returned once the
+	// others are sent.
+	PlanStream(ctx context.Context, source Source, dest Destination, opts Options, items chan<- Item) error
+}
+
+// listing yields the items of one side of a sync in lexical order of their
+// paths, the order S3 lists keys in. An *ItemError is yielded for an item,
+// or a directory of items, that could not be listed with Options.KeepGoing,
+// and the listing goes on; any other error ends it.
+type listing = iter.Seq2[ItemMetadata, error]
+
+// chunkFunc plans a chunk of paths given the items of both sides in it.
+type chunkFunc func(ctx context.Context, source []ItemMetadata, dest []ItemMetadata) ([]Item, error)
+
+// errStopped stops a listing whose consumer stopped pulling items.
+var errStopped = errors.New("listing stopped")
+
+// streamPlan merge-joins source and dest, plans them a chunk at a time with
+// plan and sends the planned items to items. The ItemErrors of all chunks
+// are returned once everything else is sent.
+func streamPlan(ctx context.Context, source listing, dest listing, plan chunkFunc, items chan<- Item) error {
+	var itemErrs ItemErrors
+	listErrs, err := splitItemErrors(mergeListings(source, dest, streamChunkSize, func(source, dest []ItemMetadata) error {
+		chunk, err := plan(ctx, source, dest)
+		chunkErrs, err := splitItemErrors(err)
+		if err != nil {
+			return err
+		}
+		itemErrs = append(itemErrs, chunkErrs...)
+		for _, item := range chunk {
+			select {
+			case items <- item:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+	return append(listErrs, itemErrs...).orNil()
+}
+
+// collectPlan returns every item stream sends, sorted like a plan of a
+// single chunk. The items are also returned along with ItemErrors.
+func collectPlan(stream func(items chan<- Item) error) ([]Item, error) {
+	ch := make(chan Item, streamChunkSize)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- stream(ch)
+	}()
+
+	items := []Item{}
+	for item := range ch {
+		items = append(items, item)
+	}
+	itemErrs, err := splitItemErrors(<-errCh)
+	if err != nil {
+		return nil, err
+	}
+
+	sortItems(items)
+	return items, itemErrs.orNil()
+}
+
+// mergeListings merge-joins source and dest and calls fn with chunks of at
+// most chunkSize paths. The items of both sides with the same path are
+// always in the same chunk. Listings that are not in lexical order fail,
+// since items with the same path could no longer be matched.
+// The items of either side that the other could not list are left out, so
+// that they are neither deleted nor overwritten, and the ItemErrors of the
+// listings are returned once every chunk is done.
+func mergeListings(source listing, dest listing, chunkSize int, fn func(source, dest []ItemMetadata) error) error {
+	var itemErrs ItemErrors
+	src := newCursor(source, &itemErrs)
+	defer src.stop()
+	dst := newCursor(dest, &itemErrs)
+	defer dst.stop()
+
+	if err := src.advance(); err != nil {
+		return err
+	}
+	if err := dst.advance(); err != nil {
+		return err
+	}
+
+	var sourceChunk, destChunk []ItemMetadata
+	paths := 0
+	flush := func() error {
+		if paths == 0 {
+			return nil
+		}
+		err := fn(sourceChunk, destChunk)
+		sourceChunk, destChunk, paths = nil, nil, 0
+		return err
+	}
+
+	for src.ok || dst.ok {
+		var err error
+		switch {
+		case src.ok && itemErrs.covers(src.item.Path):
+			if err := src.advance(); err != nil {
+				return err
+			}
+			continue
+		case dst.ok && itemErrs.covers(dst.item.Path):
+			if err := dst.advance(); err != nil {
+				return err
+			}
+			continue
+		case src.ok && (!dst.ok || src.item.Path < dst.item.Path):
+			sourceChunk = append(sourceChunk, src.item)
+			err = src.advance()
+		case dst.ok && (!src.ok || dst.item.Path < src.item.Path):
+			destChunk = append(destChunk, dst.item)
+			err = dst.advance()
+		default:
+			sourceChunk = append(sourceChunk, src.item)
+			destChunk = append(destChunk, dst.item)
+			if err = src.advance(); err == nil {
+				err = dst.advance()
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		paths++
+		if paths == chunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+	return itemErrs.orNil()
+}
+
+// covers reports whether path is the path of one of e, or is in a directory
+// of one of them.
+func (e ItemErrors) covers(path string) bool {
+	for _, err := range e {
+		if path == err.Path || strings.HasPrefix(path, err.Path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cursor pulls the items of a listing one at a time.
+type cursor struct {
+	next func() (ItemMetadata, error, bool)
+	stop func()
+	item ItemMetadata
+	ok   bool
+	// itemErrs collects the ItemErrors the listing yields
+	itemErrs *ItemErrors
+}
+
+func newCursor(l listing, itemErrs *ItemErrors) *cursor {
+	next, stop := iter.Pull2(l)
+	return &cursor{next: next, stop: stop, itemErrs: itemErrs}
+}
+
+// advance moves to the next item. ok turns false at the end of the listing.
+func (c *cursor) advance() error {
+	prev, hadPrev := c.item.Path, c.ok
+	item, err, ok := c.next()
+	for {
+		itemErr, isItemErr := err.(*ItemError)
+		if !isItemErr {
+			break
+		}
+		*c.itemErrs = append(*c.itemErrs, itemErr)
+		item, err, ok = c.next()
+	}
+	if err != nil {
+		return err
+	}
+	c.item, c.ok = item, ok
+	if ok && hadPrev && item.Path <= prev {
+		return fmt.Errorf("listing is not in lexical order: %q after %q", item.Path, prev)
+	}
+	return nil
+}
+
+// localListing lists the files under basePath that filters don't exclude.
+// With keepGoing, files and subdirectories that can't be read are yielded
+// as ItemErrors.
+func localListing(basePath string, filters []Filter, keepGoing bool) listing {
+	return func(yield func(ItemMetadata, error) bool) {
+		err := walkLocalFiles(basePath, "", filters, keepGoing, yield)
+		if err != nil && !errors.Is(err, errStopped) {
+			yield(ItemMetadata{}, fmt.Errorf("failed to gather local files: %w", err))
+		}
+	}
+}
+
+// walkLocalFiles yields the files in relDir under basePath and its
+// subdirectories in lexical order of their slash-separated relative paths.
+// Unlike filepath.Walk, which visits "a/b" before "a.txt", that is the order
+// S3 lists keys in.
+func walkLocalFiles(basePath string, relDir string, filters []Filter, keepGoing bool, yield func(ItemMetadata, error) bool) error {
+	entries, err := os.ReadDir(filepath.Join(basePath, filepath.FromSlash(relDir)))
+	// Without the base directory, every object would look deleted
+	if err != nil && keepGoing && relDir != "" {
+		return skipUnreadable(relDir, err, yield)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The paths in a directory continue its name with "/"
+	slices.SortFunc(entries, func(a, b os.DirEntry) int {
+		return strings.Compare(entryKey(a), entryKey(b))
+	})
+
+	for _, entry := range entries {
+		relPath := path.Join(relDir, entry.Name())
+		if entry.IsDir() {
+			if err := walkLocalFiles(basePath, relPath, filters, keepGoing, yield); err != nil {
+				return err
+			}
+			continue
+		}
+
+		excluded, err := IsExcluded(relPath, filters)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil && keepGoing {
+			if err := skipUnreadable(relPath, err, yield); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !yield(ItemMetadata{Path: relPath, Size: info.Size(), ModTime: info.ModTime()}, nil) {
+			return errStopped
+		}
+	}
+
+	return nil
+}
+
+// skipUnreadable yields the ItemError of relPath, a file or directory that
+// can't be read.
+func skipUnreadable(relPath string, err error, yield func(ItemMetadata, error) bool) error {
+	if !yield(ItemMetadata{}, &ItemError{Path: relPath, Err: fmt.Errorf("failed to gather local files: %w", err)}) {
+		return errStopped
+	}
+	return nil
+}
+
+func entryKey(entry os.DirEntry) string {
+	if entry.IsDir() {
+		return entry.Name() + "/"
+	}
+	return entry.Name()
+}
+
+// s3Listing lists the objects under prefix that filters don't exclude.
+func s3Listing(ctx context.Context, client s3client.Client, bucket string, prefix string, filters []Filter) listing {
+	// Keys that merely start with prefix, like prefix2/key, would not be in
+	// lexical order once prefix is trimmed from the others
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return func(yield func(ItemMetadata, error) bool) {
+		err := client.ListObjectsPages(ctx, &s3client.ListObjectsRequest{
+			Bucket: bucket,
+			Prefix: prefix,
+		}, func(page []s3client.ItemMetadata) error {
+			for _, obj := range page {
+				excluded, err := IsExcluded(obj.Path, filters)
+				if err != nil {
+					return fmt.Errorf("failed to check filters for %s: %w", obj.Path, err)
+				}
+				if excluded {
+					continue
+				}
+
+				if !yield(ItemMetadata{
+					Path:     obj.Path,
+					Size:     obj.Size,
+					ModTime:  obj.ModTime,
+					Checksum: obj.Checksum,
+					ETag:     obj.ETag,
+				}, nil) {
+					return errStopped
+				}
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopped) {
+			yield(ItemMetadata{}, fmt.Errorf("failed to list S3 objects: %w", err))
+		}
+	}
+}
+
+// emptyListing lists nothing, e.g. a local destination that doesn't exist yet.
+func emptyListing(yield func(ItemMetadata, error) bool) {}
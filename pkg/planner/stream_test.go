@@ -0,0 +1,262 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client/memory"
+)
+
+// listingOf lists items with the given paths. A path starting with "!" is
+// yielded as an ItemError instead, like a directory that can't be read.
+func listingOf(paths ...string) listing {
+	return func(yield func(ItemMetadata, error) bool) {
+		for _, p := range paths {
+			if unlisted, ok := strings.CutPrefix(p, "!"); ok {
+				if !yield(ItemMetadata{}, &ItemError{Path: unlisted, Err: errors.New("permission denied")}) {
+					return
+				}
+				continue
+			}
+			if !yield(ItemMetadata{Path: p}, nil) {
+				return
+			}
+		}
+	}
+}
+
+func paths(items []ItemMetadata) []string {
+	var paths []string
+	for _, item := range items {
+		paths = append(paths, item.Path)
+	}
+	return paths
+}
+
+func TestLocalListing(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "a/b", "a/c/d", "a-b", "b", "skip.tmp"} {
+		localPath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(localPath, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for item, err := range localListing(dir, []Filter{{Type: FilterExclude, Pattern: "*.tmp"}}, false) {
+		if err != nil {
+			t.Fatalf("localListing() error = %v", err)
+		}
+		got = append(got, item.Path)
+	}
+
+	// The order S3 lists the same keys in, unlike filepath.Walk
+	want := []string{"a-b", "a.txt", "a/b", "a/c/d", "b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("localListing() = %v, want %v", got, want)
+	}
+}
+
+func TestMergeListings(t *testing.T) {
+	type chunk struct {
+		source []string
+		dest   []string
+	}
+
+	tests := []struct {
+		name    string
+		source  listing
+		dest    listing
+		want    []chunk
+		wantErr string
+		// wantUnlisted are the paths of the ItemErrors of the listings
+		wantUnlisted []string
+	}{
+		{
+			name:   "chunks keep pairs together",
+			source: listingOf("a", "b", "d", "e"),
+			dest:   listingOf("b", "c", "e", "f"),
+			want: []chunk{
+				{source: []string{"a", "b"}, dest: []string{"b"}},
+				{source: []string{"d"}, dest: []string{"c"}},
+				{source: []string{"e"}, dest: []string{"e", "f"}},
+			},
+		},
+		{
+			name:   "empty side",
+			source: listingOf("a", "b", "c"),
+			dest:   emptyListing,
+			want: []chunk{
+				{source: []string{"a", "b"}},
+				{source: []string{"c"}},
+			},
+		},
+		{
+			name:   "unlisted items are left out on both sides",
+			source: listingOf("a", "!b", "c", "!d.txt"),
+			dest:   listingOf("a", "b/x", "b/y", "b2", "c", "d.txt", "e"),
+			want: []chunk{
+				{source: []string{"a"}, dest: []string{"a", "b2"}},
+				{source: []string{"c"}, dest: []string{"c", "e"}},
+			},
+			wantUnlisted: []string{"b", "d.txt"},
+		},
+		{
+			name:    "out of order",
+			source:  listingOf("a", "c", "b"),
+			dest:    emptyListing,
+			wantErr: `listing is not in lexical order: "b" after "c"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []chunk
+			err := mergeListings(tt.source, tt.dest, 2, func(source, dest []ItemMetadata) error {
+				got = append(got, chunk{source: paths(source), dest: paths(dest)})
+				return nil
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("mergeListings() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			itemErrs, err := splitItemErrors(err)
+			if err != nil {
+				t.Fatalf("mergeListings() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeListings() chunks = %+v, want %+v", got, tt.want)
+			}
+			var unlisted []string
+			for _, err := range itemErrs {
+				unlisted = append(unlisted, err.Path)
+			}
+			if !reflect.DeepEqual(unlisted, tt.wantUnlisted) {
+				t.Errorf("mergeListings() unlisted = %v, want %v", unlisted, tt.wantUnlisted)
+			}
+		})
+	}
+}
+
+func TestFSToS3Planner_PlanStream(t *testing.T) {
+	localBase := t.TempDir()
+	for _, name := range []string{"new.txt", "same.txt"} {
+		if err := os.WriteFile(filepath.Join(localBase, name), []byte("Hello, World!\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := memory.New()
+	client.PageSize = 1
+	client.SetObject("test-bucket", memory.Object{Key: "dir/same.txt", Data: []byte("Hello, World!\n"), Checksum: "SoXXbx67KpE="})
+	client.SetObject("test-bucket", memory.Object{Key: "dir/old.txt", Data: []byte("old")})
+	// Not in dir, even though its key starts with the prefix
+	client.SetObject("test-bucket", memory.Object{Key: "dir2/other.txt", Data: []byte("other")})
+
+	p := NewFSToS3Planner(client, &mockLogger{})
+	items := make(chan Item)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.PlanStream(context.Background(),
+			Source{Type: SourceTypeFileSystem, Path: localBase},
+			Destination{Type: DestTypeS3, Path: "s3://test-bucket/dir"},
+			Options{DeleteEnabled: true},
+			items,
+		)
+	}()
+
+	var got []Item
+	for item := range items {
+		got = append(got, item)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("PlanStream() error = %v", err)
+	}
+
+	want := []Item{
+		{Action: ActionDelete, Bucket: "test-bucket", Key: "dir/old.txt", Size: 3, Reason: "deleted locally"},
+		{Action: ActionSkip, LocalPath: filepath.Join(localBase, "same.txt"), Bucket: "test-bucket", Key: "dir/same.txt", Size: 14, Reason: "unchanged"},
+		{Action: ActionUpload, LocalPath: filepath.Join(localBase, "new.txt"), Bucket: "test-bucket", Key: "dir/new.txt", Size: 14, Reason: "new file"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PlanStream() = %+v, want %+v", got, want)
+	}
+	if n := client.Calls(memory.OpListObjects); n != 2 {
+		t.Errorf("listed %d pages, want 2", n)
+	}
+}
+
+func TestFSToS3Planner_PlanStreamListingError(t *testing.T) {
+	client := &mockS3Client{
+		listObjectsFunc: func(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+			return nil, os.ErrPermission
+		},
+	}
+
+	p := NewFSToS3Planner(client, &mockLogger{})
+	_, err := p.Plan(context.Background(),
+		Source{Type: SourceTypeFileSystem, Path: t.TempDir()},
+		Destination{Type: DestTypeS3, Path: "s3://test-bucket"},
+		Options{},
+	)
+	if err == nil || !strings.Contains(err.Error(), "failed to list S3 objects") {
+		t.Errorf("Plan() error = %v, want a listing error", err)
+	}
+}
+
+func TestFSToS3Planner_PlanKeepGoingUnreadableDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+
+	localBase := t.TempDir()
+	for _, name := range []string{"ok.txt", "locked/a.txt"} {
+		localPath := filepath.Join(localBase, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(localPath, []byte("Hello, World!\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	locked := filepath.Join(localBase, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(locked, 0755) })
+
+	client := memory.New()
+	client.PutBytes("test-bucket", "ok.txt", []byte("Hello, World!\n"))
+	client.PutBytes("test-bucket", "locked/a.txt", []byte("Hello, World!\n"))
+
+	p := NewFSToS3Planner(client, &mockLogger{})
+	source := Source{Type: SourceTypeFileSystem, Path: localBase}
+	dest := Destination{Type: DestTypeS3, Path: "s3://test-bucket"}
+	if _, err := p.Plan(context.Background(), source, dest, Options{DeleteEnabled: true}); err == nil {
+		t.Fatal("Plan() error = nil, want an error for the unreadable directory")
+	}
+
+	// The objects of the directory are not deleted
+	got, err := p.Plan(context.Background(), source, dest, Options{DeleteEnabled: true, KeepGoing: true})
+	var itemErrs ItemErrors
+	if !errors.As(err, &itemErrs) || len(itemErrs) != 1 || itemErrs[0].Path != "locked" {
+		t.Fatalf("Plan() error = %v, want ItemErrors for locked", err)
+	}
+	want := []Item{
+		{Action: ActionSkip, LocalPath: filepath.Join(localBase, "ok.txt"), Bucket: "test-bucket", Key: "ok.txt", Size: 14, Reason: "unchanged"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %+v, want %+v", got, want)
+	}
+}
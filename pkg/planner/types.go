@@ -2,6 +2,7 @@ package planner
 
 import (
 	"context"
+	"os"
 	"time"
 
 	"github.com/yuya-takeyama/strict-s3-sync/pkg/logger"
@@ -27,6 +28,11 @@ type ItemMetadata struct {
 	Size     int64
 	ModTime  time.Time
 	Checksum string
+	// ETag is the entity tag of an object, empty for local files.
+	ETag string
+	// Metadata is the user metadata of an object. It is only set for
+	// Comparators whose Needs ask for it.
+	Metadata map[string]string
 }
 
 type Source struct {
@@ -41,26 +47,128 @@ type Destination struct {
 	Metadata []ItemMetadata
 }
 
+// FilterType is the kind of a filter rule.
+type FilterType string
+
+const (
+	FilterExclude FilterType = "exclude"
+	FilterInclude FilterType = "include"
+)
+
+// Filter is a single --exclude or --include rule.
+// Filters are evaluated in order and later filters take precedence over
+// earlier ones, the same way as aws s3 sync.
+type Filter struct {
+	Type    FilterType
+	Pattern string
+}
+
 type Options struct {
 	DeleteEnabled bool
-	Excludes      []string
+	Filters       []Filter
 	Logger        logger.Logger
+	// Journal holds the items completed by a previous run. Same-size items
+	// it reports as completed are skipped without collecting checksums.
+	Journal Journal
+	// PlanChecksums calculates the checksum of every file to upload while
+	// planning, so that the plan pins the exact content to upload. Otherwise
+	// new and resized files are only hashed while they are uploaded.
+	PlanChecksums bool
+	// ChecksumCache, if set, is consulted before reading a local file to
+	// calculate its checksum, and remembers the calculated checksums.
+	ChecksumCache ChecksumCache
+	// ChecksumAlgorithm is the algorithm uploads are hashed and stored
+	// with, and the one objects are compared by if they have a checksum in
+	// it. Empty means CRC64NVME.
+	ChecksumAlgorithm string
+	// BackfillChecksums plans same-size objects without a checksum to be
+	// copied onto themselves, so that S3 calculates their checksum on the
+	// server side, instead of uploading them again.
+	BackfillChecksums bool
+	// Comparator decides which items of the same size on both sides are
+	// unchanged. StrictComparator is used if nil.
+	Comparator Comparator
+	// HashConcurrency is how many local files Phase 2 hashes at a time.
+	// Zero means the number of CPUs. Large files are hashed by
+	// GOMAXPROCS / HashConcurrency goroutines each, at least one.
+	HashConcurrency int
+	// HeadConcurrency is how many HeadObject requests Phase 2 sends at a
+	// time, independently of the hashing. Zero means
+	// DefaultHeadConcurrency.
+	HeadConcurrency int
+	// KeepGoing plans the items that could be compared when others can't,
+	// e.g. unreadable files, and reports those in ItemErrors. Otherwise the
+	// first failure stops planning.
+	KeepGoing bool
+}
+
+// Journal tells which items an interrupted run has already synced.
+type Journal interface {
+	// Completed reports whether item was synced and its local file, if
+	// any, has not changed since.
+	Completed(item Item) bool
+}
+
+// ChecksumCache stores the checksums of local files between runs.
+// Paths are relative to the local side of the sync and slash-separated.
+// Algorithms are named like in ChecksumData, empty for CRC64NVME.
+type ChecksumCache interface {
+	// Get returns the checksum in algorithm stored for path if the file
+	// described by info still has the stored size, mtime and inode.
+	Get(path string, algorithm string, info os.FileInfo) (string, bool)
+	// Put stores the checksum of path in algorithm, which was read as
+	// described by info.
+	Put(path string, algorithm string, info os.FileInfo, checksum string)
+}
+
+// ObjectCache is implemented by ChecksumCaches that also store what
+// SourceMetadataComparator looked up of objects between runs, so that objects
+// still listed with the same ETag are not headed again. Paths are those of
+// ChecksumCache.
+type ObjectCache interface {
+	// GetObject returns the CRC64NVME checksum, empty if it has none, and the
+	// user metadata stored for the object at path if it still has etag.
+	GetObject(path string, etag string) (checksum string, metadata map[string]string, ok bool)
+	// PutObject stores the CRC64NVME checksum and user metadata of the
+	// object at path, which has etag.
+	PutObject(path string, etag string, checksum string, metadata map[string]string)
 }
 
 type Action string
 
 const (
-	ActionUpload Action = "upload"
-	ActionDelete Action = "delete"
-	ActionSkip   Action = "skip"
+	ActionUpload      Action = "upload"
+	ActionDownload    Action = "download"
+	ActionCopy        Action = "copy"
+	ActionDelete      Action = "delete"
+	ActionDeleteLocal Action = "delete-local"
+	ActionSkip        Action = "skip"
+
+	// ActionBackfill copies an object onto itself so that S3 calculates its
+	// missing checksum, and uploads the local file only if it differs.
+	ActionBackfill Action = "backfill"
 )
 
+// Item is a single planned operation.
+// Bucket and Key always refer to the S3 object and LocalPath to the local
+// file, regardless of the direction of the sync. For S3 to S3 syncs, Bucket
+// and Key refer to the destination object and SourceBucket and SourceKey to
+// the source object.
 type Item struct {
-	Action    Action
-	LocalPath string
-	Bucket    string
-	Key       string
-	Size      int64
-	Reason    string
-	Checksum  string
+	Action       Action
+	LocalPath    string
+	Bucket       string
+	Key          string
+	SourceBucket string
+	SourceKey    string
+	Size         int64
+	Reason       string
+	Checksum     string
+	// ChecksumAlgorithm is the algorithm of Checksum, and for uploads the
+	// one the object is stored with. Empty means CRC64NVME.
+	ChecksumAlgorithm string
+	// Metadata is the user metadata to store with an uploaded object, the
+	// source metadata of the local file for uploads planned with
+	// SourceMetadataComparator.
+	Metadata map[string]string
 }
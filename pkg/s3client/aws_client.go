@@ -1,10 +1,14 @@
 package s3client
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
@@ -19,7 +23,7 @@ func trimS3KeyPrefix(key, prefix string) string {
 	if prefix == "" {
 		return key
 	}
-	return strings.TrimPrefix(key, prefix+"/")
+	return strings.TrimPrefix(key, strings.TrimSuffix(prefix, "/")+"/")
 }
 
 const (
@@ -32,19 +36,135 @@ const (
 	MaxParts                 = 10000                  // S3 maximum number of parts
 )
 
+// ErrChecksumUnsupported is returned by ProbeChecksum when the endpoint
+// accepts uploads but doesn't return checksums in the probed algorithm.
+var ErrChecksumUnsupported = errors.New("endpoint does not return checksums")
+
+// ErrProbeUpload is returned by ProbeChecksum when the probe object can't be
+// uploaded, e.g. without permission to write to the bucket.
+var ErrProbeUpload = errors.New("failed to upload probe object")
+
+// ErrProbeInconclusive is returned by ProbeListedChecksum when there is no
+// listed object with a checksum to tell by.
+var ErrProbeInconclusive = errors.New("no checksum to tell by")
+
+// errUnverifiedUpload is returned for an upload whose body was not read
+// exactly once in full, so that its checksum is unknown.
+var errUnverifiedUpload = errors.New("failed to verify upload: the checksum of the uploaded content could not be calculated")
+
+// Options configures the AWSClient beyond what aws.Config covers.
+type Options struct {
+	// EndpointURL overrides the S3 endpoint, e.g. for MinIO or Ceph RGW.
+	EndpointURL string
+	// ForcePathStyle addresses buckets as https://endpoint/bucket/key
+	// instead of https://bucket.endpoint/key.
+	ForcePathStyle bool
+}
+
 type AWSClient struct {
 	client *s3.Client
 }
 
-func NewAWSClient(cfg aws.Config) *AWSClient {
+func NewAWSClient(cfg aws.Config, opts Options) *AWSClient {
 	return &AWSClient{
-		client: s3.NewFromConfig(cfg),
+		client: s3.NewFromConfig(cfg, func(o *s3.Options) {
+			if opts.EndpointURL != "" {
+				o.BaseEndpoint = aws.String(opts.EndpointURL)
+			}
+			o.UsePathStyle = opts.ForcePathStyle
+		}),
 	}
 }
 
+// ProbeChecksum checks that the endpoint stores checksums in algorithm by
+// uploading a small object to bucket/key, reading its checksum back and
+// deleting it again. S3-compatible stores that ignore the checksum would
+// otherwise make every object look changed on every run.
+func (c *AWSClient) ProbeChecksum(ctx context.Context, bucket, key, algorithm string) error {
+	body := []byte("strict-s3-sync checksum probe\n")
+	h, err := NewChecksumHash(algorithm)
+	if err != nil {
+		return err
+	}
+	h.Write(body)
+	expected := EncodeChecksum(h)
+
+	if err := c.putObjectSimple(ctx, &PutObjectRequest{
+		Bucket:            bucket,
+		Key:               key,
+		Body:              bytes.NewReader(body),
+		Size:              int64(len(body)),
+		ChecksumAlgorithm: algorithm,
+	}); err != nil {
+		return fmt.Errorf("%w: %w", ErrProbeUpload, err)
+	}
+
+	info, err := c.HeadObject(ctx, &HeadObjectRequest{Bucket: bucket, Key: key})
+
+	// The probe object must not be left behind even if the head failed
+	if delErr := c.DeleteObject(context.WithoutCancel(ctx), &DeleteObjectRequest{Bucket: bucket, Key: key}); delErr != nil && err == nil {
+		err = fmt.Errorf("failed to delete probe object: %w", delErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	algorithm = string(sdkChecksumAlgorithm(algorithm))
+	checksum := info.ChecksumIn(algorithm)
+	if checksum == "" {
+		return fmt.Errorf("%w in %s", ErrChecksumUnsupported, algorithm)
+	}
+	if checksum != expected {
+		return fmt.Errorf("endpoint returned %s %s for the probe object, expected %s", algorithm, checksum, expected)
+	}
+
+	return nil
+}
+
+// ProbeListedChecksum checks without writing anything that the endpoint
+// stores checksums in algorithm, by looking at the checksum of an object
+// listed under prefix in bucket. As that object may have been stored
+// without a checksum, one that has none proves nothing, and
+// ErrProbeInconclusive is returned instead.
+func (c *AWSClient) ProbeListedChecksum(ctx context.Context, bucket, prefix, algorithm string) error {
+	resp, err := c.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+	if len(resp.Contents) == 0 {
+		return fmt.Errorf("%w: no objects under %s", ErrProbeInconclusive, prefix)
+	}
+
+	key := aws.ToString(resp.Contents[0].Key)
+	info, err := c.HeadObject(ctx, &HeadObjectRequest{Bucket: bucket, Key: key})
+	if err != nil {
+		return err
+	}
+
+	algorithm = string(sdkChecksumAlgorithm(algorithm))
+	if info.ChecksumIn(algorithm) == "" {
+		return fmt.Errorf("%w: %s has no %s checksum", ErrProbeInconclusive, key, algorithm)
+	}
+	return nil
+}
+
 func (c *AWSClient) ListObjects(ctx context.Context, req *ListObjectsRequest) ([]ItemMetadata, error) {
 	var items []ItemMetadata
+	err := c.ListObjectsPages(ctx, req, func(page []ItemMetadata) error {
+		items = append(items, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
 
+func (c *AWSClient) ListObjectsPages(ctx context.Context, req *ListObjectsRequest, fn func(page []ItemMetadata) error) error {
 	paginator := s3.NewListObjectsV2Paginator(c.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(req.Bucket),
 		Prefix: aws.String(req.Prefix),
@@ -53,9 +173,10 @@ func (c *AWSClient) ListObjects(ctx context.Context, req *ListObjectsRequest) ([
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list objects: %w", err)
+			return fmt.Errorf("failed to list objects: %w", err)
 		}
 
+		items := make([]ItemMetadata, 0, len(page.Contents))
 		for _, obj := range page.Contents {
 			if obj.Key == nil || obj.Size == nil {
 				continue
@@ -67,11 +188,15 @@ func (c *AWSClient) ListObjects(ctx context.Context, req *ListObjectsRequest) ([
 				Path:    key,
 				Size:    aws.ToInt64(obj.Size),
 				ModTime: aws.ToTime(obj.LastModified),
+				ETag:    aws.ToString(obj.ETag),
 			})
 		}
+		if err := fn(items); err != nil {
+			return err
+		}
 	}
 
-	return items, nil
+	return nil
 }
 
 func (c *AWSClient) HeadObject(ctx context.Context, req *HeadObjectRequest) (*ObjectInfo, error) {
@@ -85,16 +210,114 @@ func (c *AWSClient) HeadObject(ctx context.Context, req *HeadObjectRequest) (*Ob
 	}
 
 	info := &ObjectInfo{
-		Size: aws.ToInt64(resp.ContentLength),
+		Size:     aws.ToInt64(resp.ContentLength),
+		ModTime:  aws.ToTime(resp.LastModified),
+		ETag:     aws.ToString(resp.ETag),
+		Metadata: resp.Metadata,
 	}
 
-	if resp.ChecksumCRC64NVME != nil {
-		info.Checksum = *resp.ChecksumCRC64NVME
+	setChecksums(info, resp.ChecksumType, map[string]*string{
+		ChecksumAlgorithmCRC64NVME: resp.ChecksumCRC64NVME,
+		ChecksumAlgorithmCRC32C:    resp.ChecksumCRC32C,
+		ChecksumAlgorithmCRC32:     resp.ChecksumCRC32,
+		ChecksumAlgorithmSHA256:    resp.ChecksumSHA256,
+		ChecksumAlgorithmSHA1:      resp.ChecksumSHA1,
+	})
+
+	// Comparing a composite checksum needs the layout of the parts. Without
+	// it, e.g. without permission for GetObjectAttributes, the part size
+	// stays zero and the checksum can't be compared, so the object is
+	// transferred.
+	if info.ChecksumType == ChecksumTypeComposite {
+		if err := c.objectParts(ctx, req, info); err != nil && ctx.Err() != nil {
+			return nil, err
+		}
 	}
 
 	return info, nil
 }
 
+// setChecksums fills in the checksums S3 returned for info by algorithm
+// and their type.
+func setChecksums(info *ObjectInfo, checksumType types.ChecksumType, returned map[string]*string) {
+	info.Checksums = checksums(returned)
+	info.Checksum = info.Checksums[ChecksumAlgorithmCRC64NVME]
+
+	if _, checksum := info.PreferredChecksum(); checksum != "" {
+		info.ChecksumType = string(checksumType)
+		// Not every store reports the type of the checksum
+		if info.ChecksumType == "" {
+			info.ChecksumType = ChecksumTypeFullObject
+			if IsCompositeChecksum(checksum) {
+				info.ChecksumType = ChecksumTypeComposite
+			}
+		}
+	}
+}
+
+// checksums returns the checksums S3 returned by algorithm, or nil if there
+// are none.
+func checksums(returned map[string]*string) map[string]string {
+	var result map[string]string
+	for algorithm, checksum := range returned {
+		if aws.ToString(checksum) == "" {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[algorithm] = *checksum
+	}
+	return result
+}
+
+// objectParts fills in the part count and part size of info.
+func (c *AWSClient) objectParts(ctx context.Context, req *HeadObjectRequest, info *ObjectInfo) error {
+	resp, err := c.client.GetObjectAttributes(ctx, &s3.GetObjectAttributesInput{
+		Bucket:           aws.String(req.Bucket),
+		Key:              aws.String(req.Key),
+		ObjectAttributes: []types.ObjectAttributes{types.ObjectAttributesObjectParts},
+		MaxParts:         aws.Int32(1),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get object attributes: %w", err)
+	}
+
+	if resp.ObjectParts != nil && len(resp.ObjectParts.Parts) > 0 {
+		info.PartCount = int(aws.ToInt32(resp.ObjectParts.TotalPartsCount))
+		info.PartSize = aws.ToInt64(resp.ObjectParts.Parts[0].Size)
+	}
+	return nil
+}
+
+func (c *AWSClient) GetObject(ctx context.Context, req *GetObjectRequest) (*Object, error) {
+	resp, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(req.Bucket),
+		Key:          aws.String(req.Key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+
+	obj := &Object{
+		ObjectInfo: ObjectInfo{
+			Size:    aws.ToInt64(resp.ContentLength),
+			ModTime: aws.ToTime(resp.LastModified),
+		},
+		Body: resp.Body,
+	}
+	setChecksums(&obj.ObjectInfo, resp.ChecksumType, map[string]*string{
+		ChecksumAlgorithmCRC64NVME: resp.ChecksumCRC64NVME,
+		ChecksumAlgorithmCRC32C:    resp.ChecksumCRC32C,
+		ChecksumAlgorithmCRC32:     resp.ChecksumCRC32,
+		ChecksumAlgorithmSHA256:    resp.ChecksumSHA256,
+		ChecksumAlgorithmSHA1:      resp.ChecksumSHA1,
+	})
+
+	return obj, nil
+}
+
 func (c *AWSClient) PutObject(ctx context.Context, req *PutObjectRequest) error {
 	if req.Size >= MultipartThreshold {
 		return c.putObjectMultipart(ctx, req)
@@ -109,19 +332,40 @@ func (c *AWSClient) putObjectSimple(ctx context.Context, req *PutObjectRequest)
 		Key:               aws.String(req.Key),
 		Body:              req.Body,
 		ContentLength:     aws.Int64(req.Size),
-		ChecksumAlgorithm: types.ChecksumAlgorithmCrc64nvme,
+		ChecksumAlgorithm: sdkChecksumAlgorithm(req.ChecksumAlgorithm),
+		Metadata:          req.Metadata,
 	}
 
+	// Without a planned checksum, the body is hashed while it is sent and
+	// compared with the checksum S3 stored
+	var hr *hashingReader
+	if req.Checksum != "" {
+		setPutChecksum(input, req.ChecksumAlgorithm, req.Checksum)
+	} else {
+		hash, err := NewChecksumHash(req.ChecksumAlgorithm)
+		if err != nil {
+			return err
+		}
+		input.Body, hr = newHashingReader(req.Body, hash)
+	}
 	if req.ContentType != "" {
 		input.ContentType = aws.String(req.ContentType)
 	}
 
-	_, err := c.client.PutObject(ctx, input)
+	resp, err := c.client.PutObject(ctx, input)
 	if err != nil {
-		return fmt.Errorf("failed to put object: %w", err)
+		return fmt.Errorf("failed to put object: %w", checkDigest(err, req.Checksum))
 	}
 
-	return nil
+	expected := req.Checksum
+	if hr != nil {
+		var ok bool
+		if expected, ok = hr.checksum(req.Size); !ok {
+			return errUnverifiedUpload
+		}
+	}
+	return verifyChecksum(expected, storedChecksum(req.ChecksumAlgorithm,
+		resp.ChecksumCRC64NVME, resp.ChecksumCRC32C, resp.ChecksumCRC32, resp.ChecksumSHA256, resp.ChecksumSHA1))
 }
 
 func (c *AWSClient) putObjectMultipart(ctx context.Context, req *PutObjectRequest) error {
@@ -130,15 +374,54 @@ func (c *AWSClient) putObjectMultipart(ctx context.Context, req *PutObjectReques
 	uploader := manager.NewUploader(c.client, func(u *manager.Uploader) {
 		u.PartSize = partSize
 		u.Concurrency = DefaultUploadConcurrency
+		// The uploader would abort with ctx, which fails once ctx has been
+		// canceled, so the upload is aborted below instead
+		u.LeavePartsOnError = true
 	})
 
 	input := &s3.PutObjectInput{
 		Bucket:            aws.String(req.Bucket),
 		Key:               aws.String(req.Key),
 		Body:              req.Body,
-		ChecksumAlgorithm: types.ChecksumAlgorithmCrc64nvme,
+		ChecksumAlgorithm: sdkChecksumAlgorithm(req.ChecksumAlgorithm),
+		Metadata:          req.Metadata,
 	}
 
+	// The uploader sends it with CompleteMultipartUpload, where it is
+	// checked against the full object. Without it, S3 verifies every part
+	// against the checksum the SDK calculates while sending it, and combines
+	// them into the full object checksum, which is compared with the
+	// checksum of the body calculated while it is read. Other algorithms get
+	// a composite checksum of the parts instead, which is calculated the
+	// same way. S3 can't check it against the planned full object checksum,
+	// so the body is hashed as a whole, too, and compared with it once the
+	// upload is complete.
+	crc64 := sdkChecksumAlgorithm(req.ChecksumAlgorithm) == types.ChecksumAlgorithmCrc64nvme
+	var hr, planned *hashingReader
+	var composite *CompositeHash
+	switch {
+	case crc64 && req.Checksum != "":
+		input.ChecksumCRC64NVME = aws.String(req.Checksum)
+	case crc64:
+		hash, err := NewChecksumHash(req.ChecksumAlgorithm)
+		if err != nil {
+			return err
+		}
+		input.Body, hr = newHashingReader(req.Body, hash)
+	default:
+		var err error
+		if composite, err = NewCompositeHash(req.ChecksumAlgorithm, partSize); err != nil {
+			return err
+		}
+		input.Body, hr = newHashingReader(req.Body, composite)
+		if req.Checksum != "" {
+			hash, err := NewChecksumHash(req.ChecksumAlgorithm)
+			if err != nil {
+				return err
+			}
+			input.Body, planned = newHashingReader(input.Body, hash)
+		}
+	}
 	if req.ContentType != "" {
 		input.ContentType = aws.String(req.ContentType)
 	}
@@ -148,14 +431,389 @@ func (c *AWSClient) putObjectMultipart(ctx context.Context, req *PutObjectReques
 		return fmt.Errorf("body must implement io.ReadSeeker for multipart upload")
 	}
 
-	_, err := uploader.Upload(ctx, input)
+	resp, err := uploader.Upload(ctx, input)
 	if err != nil {
-		return fmt.Errorf("failed to upload object: %w", err)
+		err = fmt.Errorf("failed to upload object: %w", checkDigest(err, aws.ToString(input.ChecksumCRC64NVME)))
+		var failure manager.MultiUploadFailure
+		if errors.As(err, &failure) {
+			return c.abortMultipartUpload(ctx, req.Bucket, req.Key, failure.UploadID(), err)
+		}
+		return err
+	}
+
+	expected := req.Checksum
+	if hr != nil {
+		var ok bool
+		if expected, ok = hr.checksum(req.Size); !ok {
+			return errUnverifiedUpload
+		}
+		if composite != nil {
+			expected = composite.Checksum()
+		}
+	}
+	if planned != nil {
+		actual, ok := planned.checksum(req.Size)
+		if !ok {
+			return errUnverifiedUpload
+		}
+		if actual != req.Checksum {
+			return &ChecksumMismatchError{Expected: req.Checksum, Actual: actual}
+		}
+	}
+	return verifyChecksum(expected, storedChecksum(req.ChecksumAlgorithm,
+		resp.ChecksumCRC64NVME, resp.ChecksumCRC32C, resp.ChecksumCRC32, resp.ChecksumSHA256, resp.ChecksumSHA1))
+}
+
+// checkDigest turns S3 rejecting the content for not matching the expected
+// checksum into a ChecksumMismatchError.
+func checkDigest(err error, expected string) error {
+	// Implemented by smithy.APIError
+	var apiErr interface{ ErrorCode() string }
+	if expected != "" && errors.As(err, &apiErr) && apiErr.ErrorCode() == "BadDigest" {
+		return &ChecksumMismatchError{Expected: expected, Err: err}
+	}
+	return err
+}
+
+// verifyChecksum compares the checksum S3 stored with the expected one.
+// Stores that don't return checksums can't be verified.
+func verifyChecksum(expected string, actual *string) error {
+	if expected == "" || actual == nil || *actual == "" || *actual == expected {
+		return nil
+	}
+	return &ChecksumMismatchError{Expected: expected, Actual: *actual}
+}
+
+func (c *AWSClient) CopyObject(ctx context.Context, req *CopyObjectRequest) error {
+	// CopyObject is limited to 5GB, larger objects need UploadPartCopy.
+	// Copies in given parts are made of parts, too.
+	if req.Size > MultipartMandatory || req.PartSize > 0 {
+		return c.copyObjectMultipart(ctx, req)
+	}
+
+	return c.copyObjectSimple(ctx, req)
+}
+
+func (c *AWSClient) copyObjectSimple(ctx context.Context, req *CopyObjectRequest) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(req.Bucket),
+		Key:               aws.String(req.Key),
+		CopySource:        aws.String(copySource(req.SourceBucket, req.SourceKey)),
+		ChecksumAlgorithm: sdkChecksumAlgorithm(req.ChecksumAlgorithm),
+	}
+
+	// S3 refuses to copy an object onto itself without replacing its
+	// metadata, so the current metadata is sent again. Its tags are copied
+	// by the default tagging directive.
+	if isSelfCopy(req) {
+		head, err := c.sourceHead(ctx, req)
+		if err != nil {
+			return err
+		}
+		attrs, err := c.selfCopyAttributes(ctx, req, head)
+		if err != nil {
+			return err
+		}
+		input.MetadataDirective = types.MetadataDirectiveReplace
+		input.ContentType = head.ContentType
+		input.ContentEncoding = head.ContentEncoding
+		input.ContentDisposition = head.ContentDisposition
+		input.ContentLanguage = head.ContentLanguage
+		input.CacheControl = head.CacheControl
+		input.Metadata = head.Metadata
+		input.StorageClass = attrs.storageClass
+		input.ServerSideEncryption = attrs.serverSideEncryption
+		input.SSEKMSKeyId = attrs.sseKMSKeyID
+		input.BucketKeyEnabled = attrs.bucketKeyEnabled
+		input.WebsiteRedirectLocation = attrs.websiteRedirectLocation
+		input.GrantFullControl = attrs.grants[types.PermissionFullControl]
+		input.GrantRead = attrs.grants[types.PermissionRead]
+		input.GrantReadACP = attrs.grants[types.PermissionReadAcp]
+		input.GrantWriteACP = attrs.grants[types.PermissionWriteAcp]
+	}
+
+	if _, err := c.client.CopyObject(ctx, input); err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+
+	return nil
+}
+
+// sourceHead returns the headers of the source object of req.
+func (c *AWSClient) sourceHead(ctx context.Context, req *CopyObjectRequest) (*s3.HeadObjectOutput, error) {
+	head, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(req.SourceBucket),
+		Key:    aws.String(req.SourceKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to head source object: %w", err)
+	}
+	return head, nil
+}
+
+// isSelfCopy reports whether req copies an object onto itself, e.g. to have
+// S3 calculate its checksum.
+func isSelfCopy(req *CopyObjectRequest) bool {
+	return req.SourceBucket == req.Bucket && req.SourceKey == req.Key
+}
+
+// selfCopyAttributes are the attributes of an object that a copy onto
+// itself must send again. The copy gets the storage class, encryption,
+// website redirect and ACL of a new object otherwise.
+type selfCopyAttributes struct {
+	storageClass            types.StorageClass
+	serverSideEncryption    types.ServerSideEncryption
+	sseKMSKeyID             *string
+	bucketKeyEnabled        *bool
+	websiteRedirectLocation *string
+	// grants holds the x-amz-grant-* header values by permission
+	grants map[types.Permission]*string
+}
+
+func (c *AWSClient) selfCopyAttributes(ctx context.Context, req *CopyObjectRequest, head *s3.HeadObjectOutput) (*selfCopyAttributes, error) {
+	grants, err := c.objectGrants(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// HeadObject only returns the KMS key and bucket key setting of SSE-KMS
+	// objects
+	return &selfCopyAttributes{
+		storageClass:            head.StorageClass,
+		serverSideEncryption:    head.ServerSideEncryption,
+		sseKMSKeyID:             head.SSEKMSKeyId,
+		bucketKeyEnabled:        head.BucketKeyEnabled,
+		websiteRedirectLocation: head.WebsiteRedirectLocation,
+		grants:                  grants,
+	}, nil
+}
+
+// objectGrants returns the grants of the ACL of the source object of req as
+// x-amz-grant-* header values by permission. It returns nil for the ACL
+// every new object gets, which only grants the owner full control, so that
+// buckets with ACLs disabled can still be copied within.
+func (c *AWSClient) objectGrants(ctx context.Context, req *CopyObjectRequest) (map[types.Permission]*string, error) {
+	resp, err := c.client.GetObjectAcl(ctx, &s3.GetObjectAclInput{
+		Bucket: aws.String(req.SourceBucket),
+		Key:    aws.String(req.SourceKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object ACL: %w", err)
+	}
+
+	var owner string
+	if resp.Owner != nil {
+		owner = aws.ToString(resp.Owner.ID)
+	}
+	if len(resp.Grants) == 1 && resp.Grants[0].Permission == types.PermissionFullControl &&
+		resp.Grants[0].Grantee != nil && aws.ToString(resp.Grants[0].Grantee.ID) == owner {
+		return nil, nil
+	}
+
+	grantees := make(map[types.Permission][]string)
+	for _, grant := range resp.Grants {
+		if grant.Grantee == nil {
+			continue
+		}
+		var grantee string
+		switch {
+		case grant.Grantee.ID != nil:
+			grantee = `id="` + *grant.Grantee.ID + `"`
+		case grant.Grantee.URI != nil:
+			grantee = `uri="` + *grant.Grantee.URI + `"`
+		case grant.Grantee.EmailAddress != nil:
+			grantee = `emailAddress="` + *grant.Grantee.EmailAddress + `"`
+		default:
+			continue
+		}
+		grantees[grant.Permission] = append(grantees[grant.Permission], grantee)
+	}
+
+	grants := make(map[types.Permission]*string)
+	for permission, list := range grantees {
+		grants[permission] = aws.String(strings.Join(list, ", "))
+	}
+	return grants, nil
+}
+
+// objectTagging returns the tags of the source object of req encoded for
+// x-amz-tagging, or nil if it has none.
+func (c *AWSClient) objectTagging(ctx context.Context, req *CopyObjectRequest, head *s3.HeadObjectOutput) (*string, error) {
+	if aws.ToInt32(head.TagCount) == 0 {
+		return nil, nil
+	}
+
+	resp, err := c.client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(req.SourceBucket),
+		Key:    aws.String(req.SourceKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object tagging: %w", err)
+	}
+
+	tags := url.Values{}
+	for _, tag := range resp.TagSet {
+		tags.Set(aws.ToString(tag.Key), aws.ToString(tag.Value))
+	}
+	return aws.String(tags.Encode()), nil
+}
+
+func (c *AWSClient) copyObjectMultipart(ctx context.Context, req *CopyObjectRequest) error {
+	// Multipart copies don't carry over the source's headers and tags by
+	// themselves
+	head, err := c.sourceHead(ctx, req)
+	if err != nil {
+		return err
+	}
+	tagging, err := c.objectTagging(ctx, req, head)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(req.Bucket),
+		Key:                aws.String(req.Key),
+		ContentType:        head.ContentType,
+		ContentEncoding:    head.ContentEncoding,
+		ContentDisposition: head.ContentDisposition,
+		ContentLanguage:    head.ContentLanguage,
+		CacheControl:       head.CacheControl,
+		Metadata:           head.Metadata,
+		Tagging:            tagging,
+		ChecksumAlgorithm:  sdkChecksumAlgorithm(req.ChecksumAlgorithm),
+		ChecksumType:       copyChecksumType(req),
+	}
+	if isSelfCopy(req) {
+		attrs, err := c.selfCopyAttributes(ctx, req, head)
+		if err != nil {
+			return err
+		}
+		input.StorageClass = attrs.storageClass
+		input.ServerSideEncryption = attrs.serverSideEncryption
+		input.SSEKMSKeyId = attrs.sseKMSKeyID
+		input.BucketKeyEnabled = attrs.bucketKeyEnabled
+		input.WebsiteRedirectLocation = attrs.websiteRedirectLocation
+		input.GrantFullControl = attrs.grants[types.PermissionFullControl]
+		input.GrantRead = attrs.grants[types.PermissionRead]
+		input.GrantReadACP = attrs.grants[types.PermissionReadAcp]
+		input.GrantWriteACP = attrs.grants[types.PermissionWriteAcp]
+	}
+
+	create, err := c.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	parts, err := c.uploadPartCopies(ctx, req, aws.ToString(create.UploadId))
+	if err == nil {
+		_, err = c.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(req.Bucket),
+			Key:             aws.String(req.Key),
+			UploadId:        create.UploadId,
+			ChecksumType:    copyChecksumType(req),
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to complete multipart upload: %w", err)
+		}
+	}
+	if err != nil {
+		return c.abortMultipartUpload(ctx, req.Bucket, req.Key, aws.ToString(create.UploadId), err)
 	}
 
 	return nil
 }
 
+// abortMultipartUpload aborts the multipart upload that failed with err, so
+// that the incomplete upload is not left behind, and returns err. A fresh
+// context is used so that the abort still goes through when ctx has been
+// canceled, e.g. on an interrupt.
+func (c *AWSClient) abortMultipartUpload(ctx context.Context, bucket string, key string, uploadID string, err error) error {
+	_, abortErr := c.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if abortErr != nil {
+		return fmt.Errorf("%w (abort multipart upload also failed: %v)", err, abortErr)
+	}
+	return err
+}
+
+func (c *AWSClient) uploadPartCopies(ctx context.Context, req *CopyObjectRequest, uploadID string) ([]types.CompletedPart, error) {
+	partSize := req.PartSize
+	if partSize <= 0 {
+		partSize = calculatePartSize(req.Size)
+	}
+	partCount := int((req.Size + partSize - 1) / partSize)
+	parts := make([]types.CompletedPart, partCount)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, DefaultUploadConcurrency)
+
+	for i := 0; i < partCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := int64(i) * partSize
+			end := start + partSize - 1
+			if end >= req.Size {
+				end = req.Size - 1
+			}
+			partNumber := int32(i + 1)
+
+			resp, err := c.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+				Bucket:          aws.String(req.Bucket),
+				Key:             aws.String(req.Key),
+				UploadId:        aws.String(uploadID),
+				PartNumber:      aws.Int32(partNumber),
+				CopySource:      aws.String(copySource(req.SourceBucket, req.SourceKey)),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+			})
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to copy part %d: %w", partNumber, err)
+					cancel()
+				}
+				mu.Unlock()
+				return
+			}
+
+			part := types.CompletedPart{
+				PartNumber: aws.Int32(partNumber),
+			}
+			if resp.CopyPartResult != nil {
+				part.ETag = resp.CopyPartResult.ETag
+				part.ChecksumCRC64NVME = resp.CopyPartResult.ChecksumCRC64NVME
+				part.ChecksumCRC32C = resp.CopyPartResult.ChecksumCRC32C
+				part.ChecksumCRC32 = resp.CopyPartResult.ChecksumCRC32
+				part.ChecksumSHA256 = resp.CopyPartResult.ChecksumSHA256
+				part.ChecksumSHA1 = resp.CopyPartResult.ChecksumSHA1
+			}
+			parts[i] = part
+		}(i)
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return parts, nil
+}
+
 func (c *AWSClient) DeleteObject(ctx context.Context, req *DeleteObjectRequest) error {
 	_, err := c.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(req.Bucket),
@@ -168,6 +826,15 @@ func (c *AWSClient) DeleteObject(ctx context.Context, req *DeleteObjectRequest)
 	return nil
 }
 
+// copySource builds the URL-encoded x-amz-copy-source value for an object.
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
 func calculatePartSize(fileSize int64) int64 {
 	// Calculate minimum part size to stay within 10,000 part limit
 	minPartSize := fileSize / MaxParts
@@ -0,0 +1,968 @@
+package s3client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client/s3test"
+)
+
+// newTestAWSClient returns an AWSClient that talks to srv.
+func newTestAWSClient(srv *s3test.Server) *AWSClient {
+	return newTestAWSClientWithOptions(srv, Options{EndpointURL: srv.URL})
+}
+
+func newTestAWSClientWithOptions(srv *s3test.Server, opts Options) *AWSClient {
+	return NewAWSClient(aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		HTTPClient: srv.Client(),
+	}, opts)
+}
+
+// testChecksum calculates CRC64NVME with the standard library, independently
+// of the crc64nvme package.
+func testChecksum(data []byte) string {
+	h := crc64.New(crc64.MakeTable(0x9a6c9329ac4bc9b5))
+	h.Write(data)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// testData returns deterministic, non-repeating content of the given size.
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/251)
+	}
+	return data
+}
+
+func countRequests(srv *s3test.Server, match func(r s3test.Request) bool) int {
+	n := 0
+	for _, r := range srv.Requests() {
+		if match(r) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestAWSClient_ListObjects(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.PageSize = 2
+
+	for _, key := range []string{"assets/a.txt", "assets/b.txt", "assets/sub/c.txt", "assets/d.txt", "assets/e.txt", "other/f.txt"} {
+		srv.PutObject("test-bucket", key, []byte(key))
+	}
+
+	client := newTestAWSClient(srv)
+	items, err := client.ListObjects(context.Background(), &ListObjectsRequest{Bucket: "test-bucket", Prefix: "assets"})
+	if err != nil {
+		t.Fatalf("ListObjects() error = %v", err)
+	}
+
+	var paths []string
+	for _, item := range items {
+		paths = append(paths, item.Path)
+		if item.Size != int64(len("assets/"+item.Path)) {
+			t.Errorf("item %s size = %d, want %d", item.Path, item.Size, len("assets/"+item.Path))
+		}
+		if item.ModTime.IsZero() {
+			t.Errorf("item %s has no modification time", item.Path)
+		}
+		if stored, _ := srv.Object("test-bucket", "assets/"+item.Path); item.ETag != stored.ETag {
+			t.Errorf("item %s ETag = %s, want %s", item.Path, item.ETag, stored.ETag)
+		}
+	}
+	want := []string{"a.txt", "b.txt", "d.txt", "e.txt", "sub/c.txt"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("ListObjects() = %v, want %v", paths, want)
+	}
+
+	pages := countRequests(srv, func(r s3test.Request) bool { return r.Query.Get("list-type") == "2" })
+	if pages != 3 {
+		t.Errorf("ListObjectsV2 requests = %d, want 3", pages)
+	}
+}
+
+func TestAWSClient_HeadObject(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.PutObject("test-bucket", "hello.txt", []byte("Hello, World!\n"))
+
+	client := newTestAWSClient(srv)
+	info, err := client.HeadObject(context.Background(), &HeadObjectRequest{Bucket: "test-bucket", Key: "hello.txt"})
+	if err != nil {
+		t.Fatalf("HeadObject() error = %v", err)
+	}
+	if info.Size != 14 || info.Checksum != "SoXXbx67KpE=" {
+		t.Errorf("HeadObject() = %+v, want size 14 and checksum SoXXbx67KpE=", info)
+	}
+
+	enabled := countRequests(srv, func(r s3test.Request) bool {
+		return r.Method == http.MethodHead && r.Header.Get("X-Amz-Checksum-Mode") == "ENABLED"
+	})
+	if enabled != 1 {
+		t.Errorf("HeadObject() must request checksums with x-amz-checksum-mode: ENABLED")
+	}
+
+	if _, err := client.HeadObject(context.Background(), &HeadObjectRequest{Bucket: "test-bucket", Key: "missing.txt"}); err == nil {
+		t.Error("HeadObject() of a missing object expected error")
+	}
+}
+
+func TestAWSClient_HeadObjectChecksumType(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	data := testData(2500)
+	srv.PutObject("test-bucket", "full.bin", data)
+	srv.PutCompositeObject("test-bucket", "composite.bin", data, 1000)
+
+	client := newTestAWSClient(srv)
+	info, err := client.HeadObject(context.Background(), &HeadObjectRequest{Bucket: "test-bucket", Key: "full.bin"})
+	if err != nil {
+		t.Fatalf("HeadObject() error = %v", err)
+	}
+	full, _ := srv.Object("test-bucket", "full.bin")
+	want := ObjectInfo{
+		Size:         2500,
+		ModTime:      full.LastModified,
+		ETag:         full.ETag,
+		Checksum:     testChecksum(data),
+		Checksums:    map[string]string{ChecksumAlgorithmCRC64NVME: testChecksum(data)},
+		ChecksumType: ChecksumTypeFullObject,
+	}
+	if !reflect.DeepEqual(*info, want) {
+		t.Errorf("HeadObject() = %+v, want %+v", *info, want)
+	}
+
+	info, err = client.HeadObject(context.Background(), &HeadObjectRequest{Bucket: "test-bucket", Key: "composite.bin"})
+	if err != nil {
+		t.Fatalf("HeadObject() error = %v", err)
+	}
+	stored, _ := srv.Object("test-bucket", "composite.bin")
+	want = ObjectInfo{
+		Size:         2500,
+		ModTime:      stored.LastModified,
+		ETag:         stored.ETag,
+		Checksum:     stored.Checksum,
+		Checksums:    map[string]string{ChecksumAlgorithmCRC64NVME: stored.Checksum},
+		ChecksumType: ChecksumTypeComposite,
+		PartCount:    3,
+		PartSize:     1000,
+	}
+	if !reflect.DeepEqual(*info, want) {
+		t.Errorf("HeadObject() = %+v, want %+v", *info, want)
+	}
+
+	// The parts are only looked up for the composite checksum
+	lookups := countRequests(srv, func(r s3test.Request) bool {
+		return r.Method == http.MethodGet && r.Query.Has("attributes")
+	})
+	if lookups != 1 {
+		t.Errorf("GetObjectAttributes called %d times, want 1", lookups)
+	}
+
+	// Without the parts the checksum can't be compared, which is no error
+	srv.DenyObjectAttributes = true
+	info, err = client.HeadObject(context.Background(), &HeadObjectRequest{Bucket: "test-bucket", Key: "composite.bin"})
+	if err != nil {
+		t.Fatalf("HeadObject() without GetObjectAttributes error = %v", err)
+	}
+	if info.ChecksumType != ChecksumTypeComposite || info.PartSize != 0 {
+		t.Errorf("HeadObject() without GetObjectAttributes = %+v, want a composite checksum without part size", *info)
+	}
+}
+
+func TestAWSClient_HeadObjectOtherAlgorithm(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.PutObjectWithChecksum("test-bucket", "hello.txt", []byte("Hello, World!\n"), ChecksumAlgorithmSHA256, "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE=")
+
+	client := newTestAWSClient(srv)
+	info, err := client.HeadObject(context.Background(), &HeadObjectRequest{Bucket: "test-bucket", Key: "hello.txt"})
+	if err != nil {
+		t.Fatalf("HeadObject() error = %v", err)
+	}
+	if info.Checksum != "" {
+		t.Errorf("HeadObject() Checksum = %s, want empty for an object without CRC64NVME", info.Checksum)
+	}
+	algorithm, checksum := info.PreferredChecksum()
+	if algorithm != ChecksumAlgorithmSHA256 || checksum != "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE=" {
+		t.Errorf("PreferredChecksum() = %s %s, want the SHA256 checksum", algorithm, checksum)
+	}
+	if info.ChecksumType != ChecksumTypeFullObject {
+		t.Errorf("HeadObject() ChecksumType = %s, want %s", info.ChecksumType, ChecksumTypeFullObject)
+	}
+
+	obj, err := client.GetObject(context.Background(), &GetObjectRequest{Bucket: "test-bucket", Key: "hello.txt"})
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	obj.Body.Close()
+	if algorithm, checksum := obj.PreferredChecksum(); algorithm != ChecksumAlgorithmSHA256 || checksum != "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE=" {
+		t.Errorf("GetObject() PreferredChecksum() = %s %s, want the SHA256 checksum", algorithm, checksum)
+	}
+}
+
+func TestObjectInfo_PreferredChecksum(t *testing.T) {
+	info := ObjectInfo{Checksums: map[string]string{
+		ChecksumAlgorithmSHA1:   "sha1",
+		ChecksumAlgorithmCRC32:  "crc32",
+		ChecksumAlgorithmCRC32C: "crc32c",
+	}}
+	if algorithm, checksum := info.PreferredChecksum(); algorithm != ChecksumAlgorithmCRC32C || checksum != "crc32c" {
+		t.Errorf("PreferredChecksum() = %s %s, want CRC32C crc32c", algorithm, checksum)
+	}
+	if got := info.ChecksumIn(ChecksumAlgorithmSHA1); got != "sha1" {
+		t.Errorf("ChecksumIn(SHA1) = %s, want sha1", got)
+	}
+
+	info.Checksum = "crc64"
+	if algorithm, _ := info.PreferredChecksum(); algorithm != ChecksumAlgorithmCRC64NVME {
+		t.Errorf("PreferredChecksum() = %s, want CRC64NVME", algorithm)
+	}
+	if got := info.ChecksumIn(ChecksumAlgorithmCRC64NVME); got != "crc64" {
+		t.Errorf("ChecksumIn(CRC64NVME) = %s, want crc64", got)
+	}
+}
+
+func TestIsCompositeChecksum(t *testing.T) {
+	tests := map[string]bool{
+		"SoXXbx67KpE=":   false,
+		"SoXXbx67KpE=-3": true,
+		"":               false,
+		"SoXXbx67KpE=-":  false,
+	}
+	for checksum, want := range tests {
+		if got := IsCompositeChecksum(checksum); got != want {
+			t.Errorf("IsCompositeChecksum(%q) = %v, want %v", checksum, got, want)
+		}
+	}
+}
+
+func TestAWSClient_GetObject(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	data := testData(1024)
+	srv.PutObject("test-bucket", "data.bin", data)
+
+	client := newTestAWSClient(srv)
+	obj, err := client.GetObject(context.Background(), &GetObjectRequest{Bucket: "test-bucket", Key: "data.bin"})
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	defer obj.Body.Close()
+
+	got, err := io.ReadAll(obj.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("GetObject() body does not match")
+	}
+	if obj.Size != int64(len(data)) || obj.Checksum != testChecksum(data) {
+		t.Errorf("GetObject() = size %d checksum %s, want size %d checksum %s", obj.Size, obj.Checksum, len(data), testChecksum(data))
+	}
+}
+
+func TestAWSClient_PutObject(t *testing.T) {
+	tests := []struct {
+		name      string
+		tls       bool
+		size      int
+		wantParts int
+	}{
+		{
+			name: "simple upload",
+			size: 1024,
+		},
+		{
+			name: "simple upload with trailing checksum over TLS",
+			tls:  true,
+			size: 1024,
+		},
+		{
+			name:      "multipart upload",
+			size:      DefaultPartSize + MinPartSize,
+			wantParts: 2,
+		},
+		{
+			name:      "multipart upload with trailing checksums over TLS",
+			tls:       true,
+			size:      DefaultPartSize + MinPartSize,
+			wantParts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer()
+			if tt.tls {
+				srv.Close()
+				srv = s3test.NewTLSServer()
+			}
+			defer srv.Close()
+
+			data := testData(tt.size)
+			client := newTestAWSClient(srv)
+			err := client.PutObject(context.Background(), &PutObjectRequest{
+				Bucket:      "test-bucket",
+				Key:         "path/to/data.bin",
+				Body:        bytes.NewReader(data),
+				Size:        int64(len(data)),
+				ContentType: "application/octet-stream",
+				Metadata:    map[string]string{"source-size": strconv.Itoa(len(data))},
+			})
+			if err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+
+			obj, ok := srv.Object("test-bucket", "path/to/data.bin")
+			if !ok {
+				t.Fatal("object was not stored")
+			}
+			if !bytes.Equal(obj.Data, data) {
+				t.Error("stored data does not match")
+			}
+			if obj.Checksum != testChecksum(data) || obj.ChecksumType != "FULL_OBJECT" {
+				t.Errorf("stored checksum = %s (%s), want %s (FULL_OBJECT)", obj.Checksum, obj.ChecksumType, testChecksum(data))
+			}
+			if obj.PartCount != tt.wantParts {
+				t.Errorf("part count = %d, want %d", obj.PartCount, tt.wantParts)
+			}
+			if obj.ContentType != "application/octet-stream" {
+				t.Errorf("content type = %q, want application/octet-stream", obj.ContentType)
+			}
+			info, err := client.HeadObject(context.Background(), &HeadObjectRequest{Bucket: "test-bucket", Key: "path/to/data.bin"})
+			if err != nil {
+				t.Fatalf("HeadObject() error = %v", err)
+			}
+			if want := map[string]string{"source-size": strconv.Itoa(len(data))}; !reflect.DeepEqual(info.Metadata, want) {
+				t.Errorf("metadata = %v, want %v", info.Metadata, want)
+			}
+			if srv.Uploads() != 0 {
+				t.Errorf("%d multipart uploads left behind", srv.Uploads())
+			}
+
+			// Every request carrying data must have sent a CRC64NVME checksum
+			unchecked := countRequests(srv, func(r s3test.Request) bool {
+				if r.Method != http.MethodPut {
+					return false
+				}
+				return r.Header.Get("X-Amz-Checksum-Crc64nvme") == "" &&
+					!strings.EqualFold(r.Header.Get("X-Amz-Trailer"), "x-amz-checksum-crc64nvme")
+			})
+			if unchecked != 0 {
+				t.Errorf("%d PUT requests were sent without a CRC64NVME checksum", unchecked)
+			}
+		})
+	}
+}
+
+func TestAWSClient_PutObjectWithChecksum(t *testing.T) {
+	tests := []struct {
+		name string
+		tls  bool
+		size int
+		// method of the request expected to carry the full object checksum
+		method string
+	}{
+		{name: "simple upload", size: 1024, method: http.MethodPut},
+		{name: "simple upload over TLS", tls: true, size: 1024, method: http.MethodPut},
+		{name: "multipart upload", size: DefaultPartSize + MinPartSize, method: http.MethodPost},
+		{name: "multipart upload over TLS", tls: true, size: DefaultPartSize + MinPartSize, method: http.MethodPost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer()
+			if tt.tls {
+				srv.Close()
+				srv = s3test.NewTLSServer()
+			}
+			defer srv.Close()
+
+			data := testData(tt.size)
+			client := newTestAWSClient(srv)
+			put := func(key, checksum string) error {
+				return client.PutObject(context.Background(), &PutObjectRequest{
+					Bucket:   "test-bucket",
+					Key:      key,
+					Body:     bytes.NewReader(data),
+					Size:     int64(len(data)),
+					Checksum: checksum,
+				})
+			}
+
+			planned := testChecksum(data)
+			if err := put("match.bin", planned); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			sent := countRequests(srv, func(r s3test.Request) bool {
+				return r.Method == tt.method && r.Key == "match.bin" && r.Header.Get("X-Amz-Checksum-Crc64nvme") == planned
+			})
+			if sent != 1 {
+				t.Errorf("planned checksum sent with %d %s requests, want 1", sent, tt.method)
+			}
+
+			// The file changed after it was planned
+			err := put("changed.bin", testChecksum([]byte("planned content")))
+			if !errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("PutObject() error = %v, want ErrChecksumMismatch", err)
+			}
+			if _, ok := srv.Object("test-bucket", "changed.bin"); ok {
+				t.Error("object with mismatching content was stored")
+			}
+			if srv.Uploads() != 0 {
+				t.Errorf("%d multipart uploads left behind", srv.Uploads())
+			}
+		})
+	}
+}
+
+func TestAWSClient_PutObjectChecksumAlgorithm(t *testing.T) {
+	for _, tls := range []bool{false, true} {
+		srv := s3test.NewServer()
+		if tls {
+			srv.Close()
+			srv = s3test.NewTLSServer()
+		}
+		defer srv.Close()
+
+		data := []byte("Hello, World!\n")
+		const sha256 = "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE="
+		client := newTestAWSClient(srv)
+		put := func(key, checksum string) error {
+			return client.PutObject(context.Background(), &PutObjectRequest{
+				Bucket:            "test-bucket",
+				Key:               key,
+				Body:              bytes.NewReader(data),
+				Size:              int64(len(data)),
+				Checksum:          checksum,
+				ChecksumAlgorithm: ChecksumAlgorithmSHA256,
+			})
+		}
+
+		for _, checksum := range []string{sha256, ""} {
+			if err := put("hello.txt", checksum); err != nil {
+				t.Fatalf("PutObject() with TLS %v error = %v", tls, err)
+			}
+			obj, _ := srv.Object("test-bucket", "hello.txt")
+			if obj.ChecksumAlgorithm != ChecksumAlgorithmSHA256 || obj.Checksum != sha256 {
+				t.Errorf("stored %s checksum %s, want SHA256 %s", obj.ChecksumAlgorithm, obj.Checksum, sha256)
+			}
+		}
+
+		err := put("changed.txt", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=")
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("PutObject() with TLS %v error = %v, want ErrChecksumMismatch", tls, err)
+		}
+	}
+}
+
+func TestAWSClient_PutObjectMultipartChecksumAlgorithm(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+
+	data := testData(DefaultPartSize + MinPartSize)
+	h, err := NewChecksumHash(ChecksumAlgorithmSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Write(data)
+	client := newTestAWSClient(srv)
+	put := func(key, checksum string) error {
+		return client.PutObject(context.Background(), &PutObjectRequest{
+			Bucket:            "test-bucket",
+			Key:               key,
+			Body:              bytes.NewReader(data),
+			Size:              int64(len(data)),
+			Checksum:          checksum,
+			ChecksumAlgorithm: ChecksumAlgorithmSHA256,
+		})
+	}
+
+	if err := put("match.bin", EncodeChecksum(h)); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	// The file changed after it was planned
+	err = put("changed.bin", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=")
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("PutObject() error = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestAWSClient_PutObjectVerifiesStoredChecksum(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		planned bool
+	}{
+		{name: "simple upload", size: 1024},
+		{name: "simple upload with checksum", size: 1024, planned: true},
+		{name: "multipart upload", size: DefaultPartSize + MinPartSize},
+		{name: "multipart upload with checksum", size: DefaultPartSize + MinPartSize, planned: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer()
+			defer srv.Close()
+			srv.CorruptUploads = true
+
+			data := testData(tt.size)
+			req := &PutObjectRequest{
+				Bucket: "test-bucket",
+				Key:    "data.bin",
+				Body:   bytes.NewReader(data),
+				Size:   int64(len(data)),
+			}
+			if tt.planned {
+				req.Checksum = testChecksum(data)
+			}
+			err := newTestAWSClient(srv).PutObject(context.Background(), req)
+			if !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("PutObject() error = %v, want ErrChecksumMismatch", err)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	if err := verifyChecksum("a", aws.String("a")); err != nil {
+		t.Errorf("verifyChecksum() of matching checksums = %v", err)
+	}
+	// Stores without CRC64NVME support return no checksum
+	if err := verifyChecksum("a", nil); err != nil {
+		t.Errorf("verifyChecksum() without returned checksum = %v", err)
+	}
+
+	err := verifyChecksum("a", aws.String("b"))
+	var mismatch *ChecksumMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Expected != "a" || mismatch.Actual != "b" {
+		t.Errorf("verifyChecksum() = %v, want a mismatch of a and b", err)
+	}
+}
+
+func TestAWSClient_CopyObject(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		multipart bool
+		wantParts int
+	}{
+		{
+			name: "simple copy",
+			size: 1024,
+		},
+		{
+			name:      "multipart copy",
+			size:      DefaultPartSize + MinPartSize,
+			multipart: true,
+			wantParts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer()
+			defer srv.Close()
+
+			data := testData(tt.size)
+			srv.PutObject("source-bucket", "dir/file name.bin", data)
+
+			client := newTestAWSClient(srv)
+			req := &CopyObjectRequest{
+				SourceBucket: "source-bucket",
+				SourceKey:    "dir/file name.bin",
+				Bucket:       "dest-bucket",
+				Key:          "copied.bin",
+				Size:         int64(len(data)),
+			}
+
+			var err error
+			if tt.multipart {
+				// Multipart copies only kick in above 5GB, so call it directly
+				err = client.copyObjectMultipart(context.Background(), req)
+			} else {
+				err = client.CopyObject(context.Background(), req)
+			}
+			if err != nil {
+				t.Fatalf("CopyObject() error = %v", err)
+			}
+
+			obj, ok := srv.Object("dest-bucket", "copied.bin")
+			if !ok {
+				t.Fatal("object was not copied")
+			}
+			if !bytes.Equal(obj.Data, data) || obj.Checksum != testChecksum(data) {
+				t.Errorf("copied object checksum = %s, want %s", obj.Checksum, testChecksum(data))
+			}
+			if obj.PartCount != tt.wantParts {
+				t.Errorf("part count = %d, want %d", obj.PartCount, tt.wantParts)
+			}
+			if srv.Uploads() != 0 {
+				t.Errorf("%d multipart uploads left behind", srv.Uploads())
+			}
+		})
+	}
+}
+
+func TestAWSClient_CopyObjectInParts(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+
+	data := testData(2*MinPartSize + 1024)
+	srv.PutCompositeObject("source-bucket", "file.bin", data, MinPartSize)
+	source, _ := srv.Object("source-bucket", "file.bin")
+
+	client := newTestAWSClient(srv)
+	err := client.CopyObject(context.Background(), &CopyObjectRequest{
+		SourceBucket: "source-bucket",
+		SourceKey:    "file.bin",
+		Bucket:       "dest-bucket",
+		Key:          "copied.bin",
+		Size:         int64(len(data)),
+		PartSize:     MinPartSize,
+	})
+	if err != nil {
+		t.Fatalf("CopyObject() error = %v", err)
+	}
+
+	obj, ok := srv.Object("dest-bucket", "copied.bin")
+	if !ok {
+		t.Fatal("object was not copied")
+	}
+	if obj.ChecksumType != "COMPOSITE" || obj.PartCount != 3 || obj.PartSize != MinPartSize {
+		t.Errorf("copy = %s checksum in %d parts of %d bytes, want COMPOSITE in 3 parts of %d bytes", obj.ChecksumType, obj.PartCount, obj.PartSize, MinPartSize)
+	}
+	if obj.Checksum != source.Checksum {
+		t.Errorf("copy checksum = %s, want %s like the source", obj.Checksum, source.Checksum)
+	}
+}
+
+func TestAWSClient_CopyObjectMultipartAbortsOnFailure(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+
+	// Make the source look larger than it is so that the last part copy fails
+	data := testData(DefaultPartSize + MinPartSize)
+	srv.PutObject("source-bucket", "file.bin", data)
+
+	client := newTestAWSClient(srv)
+	err := client.copyObjectMultipart(context.Background(), &CopyObjectRequest{
+		SourceBucket: "source-bucket",
+		SourceKey:    "file.bin",
+		Bucket:       "dest-bucket",
+		Key:          "copied.bin",
+		Size:         int64(len(data) + 1),
+	})
+	if err == nil {
+		t.Fatal("copyObjectMultipart() expected error")
+	}
+	if _, ok := srv.Object("dest-bucket", "copied.bin"); ok {
+		t.Error("object must not be created when a part copy fails")
+	}
+	if srv.Uploads() != 0 {
+		t.Errorf("multipart upload was not aborted")
+	}
+}
+
+// cancelReader cancels a context once it has been read to the end. It has
+// no ReadAt, so that the uploader reads it in order.
+type cancelReader struct {
+	r      *bytes.Reader
+	cancel context.CancelFunc
+}
+
+func (r cancelReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		r.cancel()
+	}
+	return n, err
+}
+
+func (r cancelReader) Seek(offset int64, whence int) (int64, error) {
+	return r.r.Seek(offset, whence)
+}
+
+func TestAWSClient_PutObjectMultipartAbortsOnCancel(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	data := testData(DefaultPartSize + MinPartSize)
+	client := newTestAWSClient(srv)
+	err := client.PutObject(ctx, &PutObjectRequest{
+		Bucket: "test-bucket",
+		Key:    "file.bin",
+		Body:   cancelReader{r: bytes.NewReader(data), cancel: cancel},
+		Size:   int64(len(data)),
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("PutObject() error = %v, want context.Canceled", err)
+	}
+	if _, ok := srv.Object("test-bucket", "file.bin"); ok {
+		t.Error("object must not be created when the upload is canceled")
+	}
+	if srv.Uploads() != 0 {
+		t.Errorf("multipart upload was not aborted")
+	}
+}
+
+func TestAWSClient_CopyObjectOntoItself(t *testing.T) {
+	data := []byte("Hello, World!\n")
+	tests := []struct {
+		name string
+		copy func(client *AWSClient, req *CopyObjectRequest) error
+		// The server only calculates CRC64NVME for multipart uploads
+		algorithm    string
+		wantChecksum string
+	}{
+		{
+			name: "copy",
+			copy: func(client *AWSClient, req *CopyObjectRequest) error {
+				return client.CopyObject(context.Background(), req)
+			},
+			algorithm:    ChecksumAlgorithmSHA256,
+			wantChecksum: "yYwktnfv9Ehgr+pvSTu67FuxxMuyCcb8K7tH9m/yrTE=",
+		},
+		{
+			name: "multipart copy",
+			copy: func(client *AWSClient, req *CopyObjectRequest) error {
+				return client.copyObjectMultipart(context.Background(), req)
+			},
+			wantChecksum: testChecksum(data),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer()
+			defer srv.Close()
+
+			// Stored by another tool with attributes a copy doesn't keep by itself
+			client := newTestAWSClient(srv)
+			_, err := client.client.PutObject(context.Background(), &s3.PutObjectInput{
+				Bucket:                  aws.String("test-bucket"),
+				Key:                     aws.String("hello.txt"),
+				Body:                    bytes.NewReader(data),
+				ContentType:             aws.String("text/plain"),
+				Metadata:                map[string]string{"owner": "team"},
+				StorageClass:            types.StorageClassStandardIa,
+				ServerSideEncryption:    types.ServerSideEncryptionAwsKms,
+				SSEKMSKeyId:             aws.String("key-id"),
+				WebsiteRedirectLocation: aws.String("/other.html"),
+				GrantFullControl:        aws.String(`id="` + s3test.OwnerID + `"`),
+				GrantRead:               aws.String(`uri="http://acs.amazonaws.com/groups/global/AllUsers"`),
+				Tagging:                 aws.String("project=sync&env=test"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, _ := srv.Object("test-bucket", "hello.txt")
+
+			err = tt.copy(client, &CopyObjectRequest{
+				SourceBucket:      "test-bucket",
+				SourceKey:         "hello.txt",
+				Bucket:            "test-bucket",
+				Key:               "hello.txt",
+				Size:              int64(len(data)),
+				ChecksumAlgorithm: tt.algorithm,
+			})
+			if err != nil {
+				t.Fatalf("copy error = %v", err)
+			}
+
+			obj, _ := srv.Object("test-bucket", "hello.txt")
+			if !bytes.Equal(obj.Data, data) || obj.ContentType != "text/plain" || !reflect.DeepEqual(obj.Metadata, before.Metadata) {
+				t.Errorf("copied object = %q of %s with %v, want the content, type and metadata kept", obj.Data, obj.ContentType, obj.Metadata)
+			}
+			if !reflect.DeepEqual(obj.Attributes, before.Attributes) {
+				t.Errorf("copied object attributes = %+v, want %+v", obj.Attributes, before.Attributes)
+			}
+			if !reflect.DeepEqual(obj.Tags, before.Tags) {
+				t.Errorf("copied object tags = %v, want %v", obj.Tags, before.Tags)
+			}
+			if obj.ChecksumAlgorithm != tt.algorithm || obj.Checksum != tt.wantChecksum {
+				t.Errorf("copied object checksum = %s %s, want %s %s", obj.ChecksumAlgorithm, obj.Checksum, tt.algorithm, tt.wantChecksum)
+			}
+		})
+	}
+
+	// An object with the ACL of a new object is copied without grants, which
+	// buckets with ACLs disabled would reject
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.PutObject("test-bucket", "hello.txt", data)
+	client := newTestAWSClient(srv)
+	err := client.CopyObject(context.Background(), &CopyObjectRequest{
+		SourceBucket: "test-bucket",
+		SourceKey:    "hello.txt",
+		Bucket:       "test-bucket",
+		Key:          "hello.txt",
+		Size:         int64(len(data)),
+	})
+	if err != nil {
+		t.Fatalf("CopyObject() error = %v", err)
+	}
+	granted := countRequests(srv, func(r s3test.Request) bool {
+		return r.Method == http.MethodPut && r.Header.Get("X-Amz-Grant-Full-Control") != ""
+	})
+	if granted != 0 {
+		t.Errorf("%d copies sent grants for the default ACL, want 0", granted)
+	}
+}
+
+func TestAWSClient_DeleteObject(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.PutObject("test-bucket", "file.txt", []byte("data"))
+
+	client := newTestAWSClient(srv)
+	if err := client.DeleteObject(context.Background(), &DeleteObjectRequest{Bucket: "test-bucket", Key: "file.txt"}); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	if _, ok := srv.Object("test-bucket", "file.txt"); ok {
+		t.Error("object still exists after DeleteObject()")
+	}
+}
+
+func TestAWSClient_ForcePathStyle(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.PutObject("test-bucket", "file.txt", []byte("data"))
+
+	// A host name endpoint would use virtual-hosted style addressing,
+	// which the test server doesn't understand
+	endpoint := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	client := newTestAWSClientWithOptions(srv, Options{EndpointURL: endpoint, ForcePathStyle: true})
+
+	info, err := client.HeadObject(context.Background(), &HeadObjectRequest{Bucket: "test-bucket", Key: "file.txt"})
+	if err != nil {
+		t.Fatalf("HeadObject() error = %v", err)
+	}
+	if info.Size != 4 {
+		t.Errorf("HeadObject().Size = %d, want 4", info.Size)
+	}
+}
+
+func TestAWSClient_ProbeChecksum(t *testing.T) {
+	tests := []struct {
+		name          string
+		algorithm     string
+		omitChecksums bool
+		wantErr       error
+	}{
+		{
+			name: "checksums supported",
+		},
+		{
+			name:      "selected algorithm supported",
+			algorithm: ChecksumAlgorithmSHA256,
+		},
+		{
+			name:          "checksums not returned",
+			omitChecksums: true,
+			wantErr:       ErrChecksumUnsupported,
+		},
+		{
+			name:          "selected algorithm not returned",
+			algorithm:     ChecksumAlgorithmCRC32C,
+			omitChecksums: true,
+			wantErr:       ErrChecksumUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer()
+			defer srv.Close()
+			srv.OmitChecksums = tt.omitChecksums
+
+			client := newTestAWSClient(srv)
+			err := client.ProbeChecksum(context.Background(), "test-bucket", "prefix/.probe", tt.algorithm)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ProbeChecksum() error = %v, want %v", err, tt.wantErr)
+			}
+			if _, ok := srv.Object("test-bucket", "prefix/.probe"); ok {
+				t.Error("probe object was left behind")
+			}
+		})
+	}
+}
+
+func TestAWSClient_ProbeListedChecksum(t *testing.T) {
+	tests := []struct {
+		name          string
+		algorithm     string
+		objects       bool
+		omitChecksums bool
+		wantErr       error
+	}{
+		{
+			name:    "checksum returned",
+			objects: true,
+		},
+		{
+			name:          "checksum not returned",
+			objects:       true,
+			omitChecksums: true,
+			wantErr:       ErrProbeInconclusive,
+		},
+		{
+			name:      "checksum in another algorithm",
+			algorithm: ChecksumAlgorithmSHA256,
+			objects:   true,
+			wantErr:   ErrProbeInconclusive,
+		},
+		{
+			name:    "no objects",
+			wantErr: ErrProbeInconclusive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer()
+			defer srv.Close()
+			if tt.objects {
+				srv.PutObject("test-bucket", "prefix/a.txt", []byte("a"))
+				srv.PutObject("test-bucket", "prefix/b.txt", []byte("b"))
+			}
+			srv.OmitChecksums = tt.omitChecksums
+
+			client := newTestAWSClient(srv)
+			err := client.ProbeListedChecksum(context.Background(), "test-bucket", "prefix/", tt.algorithm)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ProbeListedChecksum() error = %v, want %v", err, tt.wantErr)
+			}
+			writes := countRequests(srv, func(r s3test.Request) bool {
+				return r.Method != http.MethodGet && r.Method != http.MethodHead
+			})
+			if writes != 0 {
+				t.Errorf("ProbeListedChecksum() made %d writes, want none", writes)
+			}
+		})
+	}
+}
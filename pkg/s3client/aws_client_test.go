@@ -71,6 +71,12 @@ func TestTrimS3KeyPrefix(t *testing.T) {
 			prefix: "very/long/prefix/path",
 			want:   "short",
 		},
+		{
+			name:   "directory prefix",
+			key:    "assets/images/file.png",
+			prefix: "assets/images/",
+			want:   "file.png",
+		},
 		{
 			name:   "key is exactly prefix with slash",
 			key:    "prefix/",
@@ -133,3 +139,46 @@ func TestPrefixHandlingIntegration(t *testing.T) {
 		})
 	}
 }
+
+func TestCopySource(t *testing.T) {
+	tests := []struct {
+		name   string
+		bucket string
+		key    string
+		want   string
+	}{
+		{
+			name:   "simple key",
+			bucket: "bucket",
+			key:    "file.txt",
+			want:   "bucket/file.txt",
+		},
+		{
+			name:   "nested key keeps slashes",
+			bucket: "bucket",
+			key:    "a/b/c.txt",
+			want:   "bucket/a/b/c.txt",
+		},
+		{
+			name:   "special characters are escaped",
+			bucket: "bucket",
+			key:    "dir with space/file+1?.txt",
+			want:   "bucket/dir%20with%20space/file+1%3F.txt",
+		},
+		{
+			name:   "non-ascii key",
+			bucket: "bucket",
+			key:    "日本語.txt",
+			want:   "bucket/%E6%97%A5%E6%9C%AC%E8%AA%9E.txt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := copySource(tt.bucket, tt.key)
+			if got != tt.want {
+				t.Errorf("copySource(%q, %q) = %q, want %q", tt.bucket, tt.key, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package s3client
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/crc64nvme"
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// NewChecksumHash returns a hash calculating checksums in algorithm, whose
+// Sum is the checksum S3 reports in base64. An empty algorithm means
+// CRC64NVME.
+func NewChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "", ChecksumAlgorithmCRC64NVME:
+		return crc64nvme.New(), nil
+	case ChecksumAlgorithmCRC32C:
+		return crc32.New(castagnoliTable), nil
+	case ChecksumAlgorithmCRC32:
+		return crc32.NewIEEE(), nil
+	case ChecksumAlgorithmSHA256:
+		return sha256.New(), nil
+	case ChecksumAlgorithmSHA1:
+		return sha1.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %s", algorithm)
+}
+
+// ParseChecksumAlgorithm returns the algorithm named s, in any case.
+func ParseChecksumAlgorithm(s string) (string, error) {
+	for _, algorithm := range checksumAlgorithms {
+		if strings.EqualFold(s, algorithm) {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported checksum algorithm %q: must be one of %s", s, strings.Join(checksumAlgorithms, ", "))
+}
+
+// EncodeChecksum formats the checksum calculated by h the way S3 reports it.
+func EncodeChecksum(h hash.Hash) string {
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// CompositeHash calculates the composite checksum S3 stores for an object
+// uploaded in parts of PartSize bytes: the checksum of the concatenated
+// checksums of the parts. Its Sum is that checksum without the "-N" suffix
+// of the part count, which Checksum adds.
+type CompositeHash struct {
+	algorithm string
+	partSize  int64
+	part      hash.Hash
+	written   int64 // bytes written to part
+	digests   []byte
+	parts     int // parts in digests
+}
+
+// NewCompositeHash returns a CompositeHash in algorithm, CRC64NVME if empty,
+// for parts of partSize bytes.
+func NewCompositeHash(algorithm string, partSize int64) (*CompositeHash, error) {
+	if partSize <= 0 {
+		return nil, fmt.Errorf("invalid part size %d", partSize)
+	}
+	part, err := NewChecksumHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &CompositeHash{algorithm: algorithm, partSize: partSize, part: part}, nil
+}
+
+func (h *CompositeHash) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := p[:min(int64(len(p)), h.partSize-h.written)]
+		h.part.Write(chunk)
+		h.written += int64(len(chunk))
+		p = p[len(chunk):]
+		if h.written == h.partSize {
+			h.digests = h.part.Sum(h.digests)
+			h.parts++
+			h.part.Reset()
+			h.written = 0
+		}
+	}
+	return n, nil
+}
+
+func (h *CompositeHash) Sum(b []byte) []byte {
+	digests := h.digests
+	if h.written > 0 {
+		digests = h.part.Sum(slices.Clip(digests))
+	}
+	sum, _ := NewChecksumHash(h.algorithm)
+	sum.Write(digests)
+	return sum.Sum(b)
+}
+
+func (h *CompositeHash) Reset() {
+	h.part.Reset()
+	h.written = 0
+	h.digests = nil
+	h.parts = 0
+}
+
+func (h *CompositeHash) Size() int      { return h.part.Size() }
+func (h *CompositeHash) BlockSize() int { return h.part.BlockSize() }
+
+// Checksum returns the composite checksum the way S3 reports it.
+func (h *CompositeHash) Checksum() string {
+	parts := h.parts
+	if h.written > 0 {
+		parts++
+	}
+	return EncodeChecksum(h) + "-" + strconv.Itoa(parts)
+}
+
+// sdkChecksumAlgorithm returns the SDK value of algorithm, CRC64NVME if empty.
+func sdkChecksumAlgorithm(algorithm string) types.ChecksumAlgorithm {
+	if algorithm == "" {
+		return types.ChecksumAlgorithmCrc64nvme
+	}
+	return types.ChecksumAlgorithm(algorithm)
+}
+
+// copyChecksumType returns the checksum type of the multipart copy req.
+// Copies in given parts get a composite checksum like their source. S3 only
+// calculates full object checksums in CRC algorithms.
+func copyChecksumType(req *CopyObjectRequest) types.ChecksumType {
+	if req.PartSize > 0 {
+		return types.ChecksumTypeComposite
+	}
+	switch req.ChecksumAlgorithm {
+	case ChecksumAlgorithmSHA256, ChecksumAlgorithmSHA1:
+		return types.ChecksumTypeComposite
+	}
+	return types.ChecksumTypeFullObject
+}
+
+// setPutChecksum sets the checksum field of input matching algorithm.
+func setPutChecksum(input *s3.PutObjectInput, algorithm, checksum string) {
+	switch algorithm {
+	case "", ChecksumAlgorithmCRC64NVME:
+		input.ChecksumCRC64NVME = aws.String(checksum)
+	case ChecksumAlgorithmCRC32C:
+		input.ChecksumCRC32C = aws.String(checksum)
+	case ChecksumAlgorithmCRC32:
+		input.ChecksumCRC32 = aws.String(checksum)
+	case ChecksumAlgorithmSHA256:
+		input.ChecksumSHA256 = aws.String(checksum)
+	case ChecksumAlgorithmSHA1:
+		input.ChecksumSHA1 = aws.String(checksum)
+	}
+}
+
+// storedChecksum picks the checksum in algorithm out of the checksums S3
+// returned for an upload.
+func storedChecksum(algorithm string, crc64NVME, crc32C, crc32IEEE, sha256Sum, sha1Sum *string) *string {
+	switch algorithm {
+	case ChecksumAlgorithmCRC32C:
+		return crc32C
+	case ChecksumAlgorithmCRC32:
+		return crc32IEEE
+	case ChecksumAlgorithmSHA256:
+		return sha256Sum
+	case ChecksumAlgorithmSHA1:
+		return sha1Sum
+	default:
+		return crc64NVME
+	}
+}
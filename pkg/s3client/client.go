@@ -2,7 +2,11 @@ package s3client
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,20 +15,120 @@ type ItemMetadata struct {
 	Size     int64
 	ModTime  time.Time
 	Checksum string
+	// ETag is the entity tag of the object, which changes with its content.
+	ETag string
 }
 
 type Client interface {
 	ListObjects(ctx context.Context, req *ListObjectsRequest) ([]ItemMetadata, error)
+	// ListObjectsPages calls fn with every page of objects as it is listed,
+	// in lexical order of their keys. An error returned by fn stops the
+	// listing and is returned.
+	ListObjectsPages(ctx context.Context, req *ListObjectsRequest, fn func(page []ItemMetadata) error) error
 	HeadObject(ctx context.Context, req *HeadObjectRequest) (*ObjectInfo, error)
+	GetObject(ctx context.Context, req *GetObjectRequest) (*Object, error)
 	PutObject(ctx context.Context, req *PutObjectRequest) error
+	CopyObject(ctx context.Context, req *CopyObjectRequest) error
 	DeleteObject(ctx context.Context, req *DeleteObjectRequest) error
 }
 
+// Checksum types of an object. Objects uploaded in one request and
+// multipart uploads with a full object checksum have the checksum of their
+// whole content. A composite checksum is the checksum of the checksums of
+// the parts of a multipart upload, followed by "-" and the number of parts.
+const (
+	ChecksumTypeFullObject = "FULL_OBJECT"
+	ChecksumTypeComposite  = "COMPOSITE"
+)
+
+// Checksum algorithms S3 supports, in the order they are preferred for
+// comparing objects: CRC64NVME first, then the cheapest to calculate.
+const (
+	ChecksumAlgorithmCRC64NVME = "CRC64NVME"
+	ChecksumAlgorithmCRC32C    = "CRC32C"
+	ChecksumAlgorithmCRC32     = "CRC32"
+	ChecksumAlgorithmSHA256    = "SHA256"
+	ChecksumAlgorithmSHA1      = "SHA1"
+)
+
+var checksumAlgorithms = []string{
+	ChecksumAlgorithmCRC64NVME,
+	ChecksumAlgorithmCRC32C,
+	ChecksumAlgorithmCRC32,
+	ChecksumAlgorithmSHA256,
+	ChecksumAlgorithmSHA1,
+}
+
 type ObjectInfo struct {
-	Size     int64
+	Size int64
+	// ModTime is when the object was last written.
+	ModTime time.Time
+	// ETag is the entity tag of the object, which changes with its content.
+	ETag string
+	// Checksum is the CRC64NVME checksum, empty if the object has none.
 	Checksum string
+	// Checksums holds every checksum returned for the object by algorithm,
+	// including CRC64NVME. Objects written by other tools may only have a
+	// checksum in another algorithm.
+	Checksums map[string]string
+	// ChecksumType is ChecksumTypeFullObject or ChecksumTypeComposite,
+	// or empty if the object has no checksum.
+	ChecksumType string
+	// PartCount and PartSize describe the parts of an object with a
+	// composite checksum, every part but the last being PartSize bytes
+	// long. Both are zero if the object has a full object checksum.
+	PartCount int
+	PartSize  int64
+	// Metadata is the user metadata of the object, without the
+	// x-amz-meta- prefix.
+	Metadata map[string]string
+}
+
+// ChecksumIn returns the checksum of the object in algorithm, or an empty
+// string if it has none.
+func (o *ObjectInfo) ChecksumIn(algorithm string) string {
+	if algorithm == ChecksumAlgorithmCRC64NVME && o.Checksum != "" {
+		return o.Checksum
+	}
+	return o.Checksums[algorithm]
 }
 
+// PreferredChecksum returns the checksum to compare the object by and its
+// algorithm, or empty strings if the object has no checksum.
+func (o *ObjectInfo) PreferredChecksum() (algorithm, checksum string) {
+	if o.Checksum != "" {
+		return ChecksumAlgorithmCRC64NVME, o.Checksum
+	}
+	for _, algorithm := range checksumAlgorithms {
+		if checksum := o.Checksums[algorithm]; checksum != "" {
+			return algorithm, checksum
+		}
+	}
+	return "", ""
+}
+
+// IsCompositeChecksum reports whether checksum is a composite checksum of
+// a multipart object.
+func IsCompositeChecksum(checksum string) bool {
+	_, n, ok := strings.Cut(checksum, "-")
+	if !ok {
+		return false
+	}
+	_, err := strconv.Atoi(n)
+	return err == nil
+}
+
+// Object is the content of an object returned by GetObject. Unlike
+// HeadObject, GetObject leaves PartCount and PartSize zero.
+// The caller must close Body.
+type Object struct {
+	ObjectInfo
+	Body io.ReadCloser
+}
+
+// ListObjectsRequest lists the objects whose keys start with Prefix. Paths
+// are returned relative to Prefix as a directory, so that a Prefix ending in
+// "/" only lists the objects in that directory.
 type ListObjectsRequest struct {
 	Bucket string
 	Prefix string
@@ -35,16 +139,80 @@ type HeadObjectRequest struct {
 	Key    string
 }
 
+type GetObjectRequest struct {
+	Bucket string
+	Key    string
+}
+
 type PutObjectRequest struct {
-	Bucket      string
-	Key         string
-	Body        io.Reader
-	Size        int64
-	Checksum    string
-	ContentType string
+	Bucket string
+	Key    string
+	Body   io.Reader
+	Size   int64
+	// Checksum is the expected checksum of Body in ChecksumAlgorithm. If
+	// set, it is sent with the upload so that S3 rejects different content,
+	// and the checksum S3 stores is verified against it. A mismatch fails
+	// with ErrChecksumMismatch. If empty, the checksum is calculated while
+	// Body is uploaded.
+	Checksum string
+	// ChecksumAlgorithm is the algorithm S3 stores the checksum of the
+	// object in, CRC64NVME if empty.
+	ChecksumAlgorithm string
+	ContentType       string
+	// Metadata is stored as the user metadata of the object, without the
+	// x-amz-meta- prefix.
+	Metadata map[string]string
+}
+
+// CopyObjectRequest copies SourceBucket/SourceKey to Bucket/Key on the server side.
+// Size is the size of the source object and decides whether a multipart copy is needed.
+// Copying an object onto itself keeps its content and metadata, and makes S3
+// calculate its checksum, e.g. for objects uploaded without one.
+type CopyObjectRequest struct {
+	SourceBucket string
+	SourceKey    string
+	Bucket       string
+	Key          string
+	Size         int64
+	// ChecksumAlgorithm is the algorithm S3 calculates the checksum of the
+	// copy in, CRC64NVME if empty.
+	ChecksumAlgorithm string
+	// PartSize, if set, has the object copied in parts of PartSize bytes
+	// with a composite checksum, e.g. in the parts of a source with a
+	// composite checksum, so that the copy gets the same checksum.
+	PartSize int64
 }
 
 type DeleteObjectRequest struct {
 	Bucket string
 	Key    string
 }
+
+// ErrChecksumMismatch is matched by errors.Is for every ChecksumMismatchError.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumMismatchError reports content that doesn't match its expected
+// checksum.
+type ChecksumMismatchError struct {
+	Expected string
+	// Actual is the checksum of the content. It is empty when S3 rejected
+	// the content without telling its checksum.
+	Actual string
+	// Err is the error S3 rejected the content with, if any.
+	Err error
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	if e.Actual == "" {
+		return fmt.Sprintf("checksum mismatch: content does not match expected checksum %s: %v", e.Expected, e.Err)
+	}
+	return fmt.Sprintf("checksum mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+func (e *ChecksumMismatchError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ChecksumMismatchError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
@@ -0,0 +1,67 @@
+package s3client
+
+import (
+	"hash"
+	"io"
+)
+
+// hashingReader calculates the checksum of a body while it is
+// uploaded. The SDK may read the body more than once, e.g. to calculate a
+// checksum header or to retry a request, so only bytes beyond the hashed
+// range are hashed again; a second pass over the same bytes is not.
+type hashingReader struct {
+	r      io.Reader
+	hash   hash.Hash
+	pos    int64 // offset of the next Read
+	hashed int64 // bytes [0, hashed) have been hashed
+	gap    bool  // a Read started past hashed
+}
+
+// hashingReadSeeker is a hashingReader for seekable bodies.
+type hashingReadSeeker struct {
+	*hashingReader
+}
+
+// newHashingReader wraps body to be hashed with hash. The returned reader
+// is seekable if body is.
+func newHashingReader(body io.Reader, hash hash.Hash) (io.Reader, *hashingReader) {
+	h := &hashingReader{r: body, hash: hash}
+	if _, ok := body.(io.Seeker); ok {
+		return hashingReadSeeker{h}, h
+	}
+	return h, h
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if n > 0 {
+		end := h.pos + int64(n)
+		switch {
+		case h.pos > h.hashed:
+			h.gap = true
+		case end > h.hashed:
+			h.hash.Write(p[h.hashed-h.pos : n])
+			h.hashed = end
+		}
+		h.pos = end
+	}
+	return n, err
+}
+
+func (h hashingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := h.r.(io.Seeker).Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	h.pos = pos
+	return pos, nil
+}
+
+// checksum returns the checksum of the body, which is expected to be size
+// bytes long. It returns false if exactly those bytes haven't been read.
+func (h *hashingReader) checksum(size int64) (string, bool) {
+	if h.gap || h.hashed != size {
+		return "", false
+	}
+	return EncodeChecksum(h.hash), true
+}
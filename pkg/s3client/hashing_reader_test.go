@@ -0,0 +1,103 @@
+package s3client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/crc64nvme"
+)
+
+func TestHashingReader(t *testing.T) {
+	data := []byte("Hello, World!\n")
+	want := "SoXXbx67KpE="
+
+	t.Run("read once", func(t *testing.T) {
+		body, hr := newHashingReader(strings.NewReader(string(data)), crc64nvme.New())
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := hr.checksum(int64(len(data))); !ok || got != want {
+			t.Errorf("checksum() = %q, %v, want %q", got, ok, want)
+		}
+	})
+
+	t.Run("read again after seeking back", func(t *testing.T) {
+		// Like the SDK calculating a checksum header, then sending the body
+		body, hr := newHashingReader(bytes.NewReader(data), crc64nvme.New())
+		seeker := body.(io.ReadSeeker)
+		if _, err := io.CopyN(io.Discard, seeker, 5); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, seeker); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := hr.checksum(int64(len(data))); !ok || got != want {
+			t.Errorf("checksum() = %q, %v, want %q", got, ok, want)
+		}
+	})
+
+	t.Run("skipped bytes", func(t *testing.T) {
+		body, hr := newHashingReader(bytes.NewReader(data), crc64nvme.New())
+		seeker := body.(io.ReadSeeker)
+		if _, err := seeker.Seek(5, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, seeker); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := hr.checksum(int64(len(data))); ok {
+			t.Errorf("checksum() = %q, want no checksum", got)
+		}
+	})
+
+	t.Run("not read in full", func(t *testing.T) {
+		body, hr := newHashingReader(bytes.NewReader(data), crc64nvme.New())
+		if _, err := io.CopyN(io.Discard, body, 5); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := hr.checksum(int64(len(data))); ok {
+			t.Errorf("checksum() = %q, want no checksum", got)
+		}
+	})
+
+	t.Run("not seekable", func(t *testing.T) {
+		body, _ := newHashingReader(io.MultiReader(bytes.NewReader(data)), crc64nvme.New())
+		if _, ok := body.(io.Seeker); ok {
+			t.Error("reader of a non-seekable body is seekable")
+		}
+	})
+}
+
+func TestCompositeHash(t *testing.T) {
+	data := testData(10)
+	// The CRC32 of the CRC32s of "data[0:4]", "data[4:8]" and "data[8:10]"
+	var digests []byte
+	for off := 0; off < len(data); off += 4 {
+		part := crc32.ChecksumIEEE(data[off:min(off+4, len(data))])
+		digests = binary.BigEndian.AppendUint32(digests, part)
+	}
+	want := base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(digests))) + "-3"
+
+	for _, writes := range [][]int{{10}, {1, 2, 3, 4}, {4, 4, 2}, {5, 5}} {
+		h, err := NewCompositeHash(ChecksumAlgorithmCRC32, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		off := 0
+		for _, n := range writes {
+			h.Write(data[off : off+n])
+			off += n
+		}
+		if got := h.Checksum(); got != want {
+			t.Errorf("checksum written in %v = %s, want %s", writes, got, want)
+		}
+	}
+}
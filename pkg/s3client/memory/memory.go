@@ -0,0 +1,531 @@
+// Package memory provides a thread-safe in-memory implementation of
+// s3client.Client.
+//
+// It is intended for tests and offline dry runs: objects are kept in memory,
+// CRC64NVME checksums are calculated on write the same way S3 does, and
+// failures, latency and missing checksums can be injected with Fault.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/crc64nvme"
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+// DefaultPageSize is the number of keys returned per ListObjectsV2 page by S3.
+const DefaultPageSize = 1000
+
+// ErrNoSuchKey is returned when the requested object does not exist.
+var ErrNoSuchKey = errors.New("NoSuchKey: the specified key does not exist")
+
+// Operation identifies a Client method for fault injection and call counting.
+type Operation string
+
+const (
+	OpListObjects  Operation = "ListObjects"
+	OpHeadObject   Operation = "HeadObject"
+	OpGetObject    Operation = "GetObject"
+	OpPutObject    Operation = "PutObject"
+	OpCopyObject   Operation = "CopyObject"
+	OpDeleteObject Operation = "DeleteObject"
+)
+
+// Object is an object stored in the Client.
+type Object struct {
+	Key         string
+	Data        []byte
+	Checksum    string // Base64 encoded CRC64NVME, empty if the object has no checksum
+	ContentType string
+	// ChecksumAlgorithm is the algorithm of Checksum for objects seeded with
+	// a checksum other than CRC64NVME, e.g. written by other tools.
+	ChecksumAlgorithm string
+	// ChecksumType, PartCount and PartSize describe objects seeded with a
+	// composite checksum. An empty ChecksumType means a full object checksum.
+	ChecksumType string
+	PartCount    int
+	PartSize     int64
+	LastModified time.Time
+	// Metadata is the user metadata of the object.
+	Metadata map[string]string
+}
+
+// Fault is a failure or delay injected into matching operations.
+// Empty Op, Bucket and Key match anything. For ListObjects, Key is compared
+// with the requested prefix and the fault applies to every page.
+type Fault struct {
+	Op     Operation
+	Bucket string
+	Key    string
+
+	// Err is returned instead of performing the operation.
+	Err error
+	// Latency is waited before the operation is performed.
+	Latency time.Duration
+	// OmitChecksum stores objects without a checksum on PutObject and
+	// CopyObject, and hides the checksum on HeadObject and GetObject.
+	OmitChecksum bool
+	// Checksum replaces the checksum stored by PutObject, simulating a
+	// store that corrupted the content.
+	Checksum string
+	// Times limits how many times the fault fires. Zero means always.
+	Times int
+}
+
+type fault struct {
+	Fault
+	fired int
+}
+
+// Client is an in-memory S3 implementing s3client.Client.
+// Buckets are created implicitly on first write.
+type Client struct {
+	// PageSize is the number of keys per ListObjects page. Defaults to DefaultPageSize.
+	PageSize int
+
+	mu      sync.Mutex
+	buckets map[string]map[string]Object
+	faults  []*fault
+	calls   map[Operation]int
+}
+
+var _ s3client.Client = (*Client)(nil)
+
+func New() *Client {
+	return &Client{
+		PageSize: DefaultPageSize,
+		buckets:  make(map[string]map[string]Object),
+		calls:    make(map[Operation]int),
+	}
+}
+
+// AddFault registers a fault. Faults are evaluated in the order they were added.
+func (c *Client) AddFault(f Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = append(c.faults, &fault{Fault: f})
+}
+
+// ClearFaults removes all registered faults.
+func (c *Client) ClearFaults() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = nil
+}
+
+// Calls returns how many times op has been called. Every ListObjects page counts as a call.
+func (c *Client) Calls(op Operation) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[op]
+}
+
+// PutBytes stores data as bucket/key with its CRC64NVME checksum.
+func (c *Client) PutBytes(bucket, key string, data []byte) {
+	c.SetObject(bucket, Object{
+		Key:      key,
+		Data:     data,
+		Checksum: checksum(data),
+	})
+}
+
+// SetObject stores obj as is, which allows seeding objects without a
+// checksum or with an arbitrary one. LastModified defaults to now.
+func (c *Client) SetObject(bucket string, obj Object) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj.Data = bytes.Clone(obj.Data)
+	if obj.LastModified.IsZero() {
+		obj.LastModified = time.Now()
+	}
+	c.bucket(bucket)[obj.Key] = obj
+}
+
+// Object returns a copy of the stored object.
+func (c *Client) Object(bucket, key string) (Object, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, ok := c.buckets[bucket][key]
+	if !ok {
+		return Object{}, false
+	}
+	obj.Data = bytes.Clone(obj.Data)
+	return obj, true
+}
+
+// Objects returns copies of the objects whose key starts with prefix, sorted by key.
+func (c *Client) Objects(bucket, prefix string) []Object {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var objects []Object
+	for _, key := range c.sortedKeys(bucket, prefix) {
+		obj := c.buckets[bucket][key]
+		obj.Data = bytes.Clone(obj.Data)
+		objects = append(objects, obj)
+	}
+	return objects
+}
+
+// ListPage returns copies of up to maxKeys objects whose key starts with
+// prefix and sorts after startAfter, and whether more objects follow.
+// Unlike ListObjects it does not apply faults or count calls.
+func (c *Client) ListPage(bucket, prefix, startAfter string, maxKeys int) ([]Object, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys, truncated := c.listPage(bucket, prefix, startAfter, maxKeys)
+	objects := make([]Object, 0, len(keys))
+	for _, key := range keys {
+		obj := c.buckets[bucket][key]
+		obj.Data = bytes.Clone(obj.Data)
+		objects = append(objects, obj)
+	}
+	return objects, truncated
+}
+
+func (c *Client) ListObjects(ctx context.Context, req *s3client.ListObjectsRequest) ([]s3client.ItemMetadata, error) {
+	var items []s3client.ItemMetadata
+	err := c.ListObjectsPages(ctx, req, func(page []s3client.ItemMetadata) error {
+		items = append(items, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (c *Client) ListObjectsPages(ctx context.Context, req *s3client.ListObjectsRequest, fn func(page []s3client.ItemMetadata) error) error {
+	pageSize := c.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	startAfter := ""
+	for {
+		if _, err := c.before(ctx, OpListObjects, req.Bucket, req.Prefix); err != nil {
+			return fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		c.mu.Lock()
+		keys, truncated := c.listPage(req.Bucket, req.Prefix, startAfter, pageSize)
+		items := make([]s3client.ItemMetadata, 0, len(keys))
+		for _, key := range keys {
+			obj := c.buckets[req.Bucket][key]
+			items = append(items, s3client.ItemMetadata{
+				Path:    trimKeyPrefix(obj.Key, req.Prefix),
+				Size:    int64(len(obj.Data)),
+				ModTime: obj.LastModified,
+				ETag:    etag(obj.Data),
+			})
+			startAfter = key
+		}
+		c.mu.Unlock()
+
+		if err := fn(items); err != nil {
+			return err
+		}
+		if !truncated {
+			return nil
+		}
+	}
+}
+
+func (c *Client) HeadObject(ctx context.Context, req *s3client.HeadObjectRequest) (*s3client.ObjectInfo, error) {
+	f, err := c.before(ctx, OpHeadObject, req.Bucket, req.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to head object: %w", err)
+	}
+
+	obj, ok := c.Object(req.Bucket, req.Key)
+	if !ok {
+		return nil, fmt.Errorf("failed to head object: %w", ErrNoSuchKey)
+	}
+
+	info := &s3client.ObjectInfo{
+		Size:      int64(len(obj.Data)),
+		ModTime:   obj.LastModified,
+		ETag:      etag(obj.Data),
+		PartCount: obj.PartCount,
+		PartSize:  obj.PartSize,
+		Metadata:  obj.Metadata,
+	}
+	if !f.OmitChecksum {
+		setChecksums(info, obj)
+	}
+
+	return info, nil
+}
+
+// setChecksums fills in the checksum of obj, if it has one.
+func setChecksums(info *s3client.ObjectInfo, obj Object) {
+	if obj.Checksum == "" {
+		return
+	}
+	algorithm := obj.ChecksumAlgorithm
+	if algorithm == "" {
+		algorithm = s3client.ChecksumAlgorithmCRC64NVME
+		info.Checksum = obj.Checksum
+	}
+	info.Checksums = map[string]string{algorithm: obj.Checksum}
+	info.ChecksumType = obj.ChecksumType
+	if info.ChecksumType == "" {
+		info.ChecksumType = s3client.ChecksumTypeFullObject
+	}
+}
+
+func (c *Client) GetObject(ctx context.Context, req *s3client.GetObjectRequest) (*s3client.Object, error) {
+	f, err := c.before(ctx, OpGetObject, req.Bucket, req.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+
+	obj, ok := c.Object(req.Bucket, req.Key)
+	if !ok {
+		return nil, fmt.Errorf("failed to get object: %w", ErrNoSuchKey)
+	}
+
+	result := &s3client.Object{
+		ObjectInfo: s3client.ObjectInfo{
+			Size:    int64(len(obj.Data)),
+			ModTime: obj.LastModified,
+		},
+		Body: io.NopCloser(bytes.NewReader(obj.Data)),
+	}
+	if !f.OmitChecksum {
+		setChecksums(&result.ObjectInfo, obj)
+	}
+
+	return result, nil
+}
+
+func (c *Client) PutObject(ctx context.Context, req *s3client.PutObjectRequest) error {
+	f, err := c.before(ctx, OpPutObject, req.Bucket, req.Key)
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return fmt.Errorf("failed to put object: failed to read body: %w", err)
+	}
+	if int64(len(data)) != req.Size {
+		return fmt.Errorf("failed to put object: body is %d bytes, expected %d", len(data), req.Size)
+	}
+
+	calculated, err := checksumIn(data, req.ChecksumAlgorithm)
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+
+	// S3 rejects content that doesn't match the checksum sent with it
+	if req.Checksum != "" && req.Checksum != calculated {
+		return fmt.Errorf("failed to put object: %w", &s3client.ChecksumMismatchError{
+			Expected: req.Checksum,
+			Err:      errors.New("BadDigest: the checksum you specified did not match the calculated checksum"),
+		})
+	}
+
+	obj := Object{
+		Key:         req.Key,
+		Data:        data,
+		ContentType: req.ContentType,
+		Metadata:    req.Metadata,
+	}
+	if req.ChecksumAlgorithm != s3client.ChecksumAlgorithmCRC64NVME {
+		obj.ChecksumAlgorithm = req.ChecksumAlgorithm
+	}
+	switch {
+	case f.OmitChecksum:
+	case f.Checksum != "":
+		obj.Checksum = f.Checksum
+	default:
+		obj.Checksum = calculated
+	}
+	c.SetObject(req.Bucket, obj)
+
+	if req.Checksum != "" && obj.Checksum != "" && obj.Checksum != req.Checksum {
+		return &s3client.ChecksumMismatchError{Expected: req.Checksum, Actual: obj.Checksum}
+	}
+
+	return nil
+}
+
+func (c *Client) CopyObject(ctx context.Context, req *s3client.CopyObjectRequest) error {
+	f, err := c.before(ctx, OpCopyObject, req.Bucket, req.Key)
+	if err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+
+	src, ok := c.Object(req.SourceBucket, req.SourceKey)
+	if !ok {
+		return fmt.Errorf("failed to copy object: %w", ErrNoSuchKey)
+	}
+
+	obj := Object{
+		Key:         req.Key,
+		Data:        src.Data,
+		ContentType: src.ContentType,
+		Metadata:    src.Metadata,
+	}
+	if req.ChecksumAlgorithm != s3client.ChecksumAlgorithmCRC64NVME {
+		obj.ChecksumAlgorithm = req.ChecksumAlgorithm
+	}
+	switch {
+	case f.OmitChecksum:
+	case req.PartSize > 0:
+		h, err := s3client.NewCompositeHash(req.ChecksumAlgorithm, req.PartSize)
+		if err != nil {
+			return fmt.Errorf("failed to copy object: %w", err)
+		}
+		h.Write(src.Data)
+		obj.Checksum = h.Checksum()
+		obj.ChecksumType = s3client.ChecksumTypeComposite
+		obj.PartCount = int((int64(len(src.Data)) + req.PartSize - 1) / req.PartSize)
+		obj.PartSize = req.PartSize
+	default:
+		if obj.Checksum, err = checksumIn(src.Data, req.ChecksumAlgorithm); err != nil {
+			return fmt.Errorf("failed to copy object: %w", err)
+		}
+	}
+	c.SetObject(req.Bucket, obj)
+
+	return nil
+}
+
+func (c *Client) DeleteObject(ctx context.Context, req *s3client.DeleteObjectRequest) error {
+	if _, err := c.before(ctx, OpDeleteObject, req.Bucket, req.Key); err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Like S3, deleting a missing object succeeds
+	delete(c.buckets[req.Bucket], req.Key)
+
+	return nil
+}
+
+// before counts the call and applies the matching faults. It returns the
+// combined fault so that callers can honor OmitChecksum.
+func (c *Client) before(ctx context.Context, op Operation, bucket, key string) (Fault, error) {
+	c.mu.Lock()
+	c.calls[op]++
+	var applied Fault
+	for _, f := range c.faults {
+		if !f.matches(op, bucket, key) {
+			continue
+		}
+		f.fired++
+		applied.Latency += f.Latency
+		applied.OmitChecksum = applied.OmitChecksum || f.OmitChecksum
+		if applied.Checksum == "" {
+			applied.Checksum = f.Checksum
+		}
+		if applied.Err == nil {
+			applied.Err = f.Err
+		}
+	}
+	c.mu.Unlock()
+
+	if applied.Latency > 0 {
+		timer := time.NewTimer(applied.Latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return applied, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return applied, err
+	}
+
+	return applied, applied.Err
+}
+
+func (f *fault) matches(op Operation, bucket, key string) bool {
+	if f.Times > 0 && f.fired >= f.Times {
+		return false
+	}
+	return (f.Op == "" || f.Op == op) &&
+		(f.Bucket == "" || f.Bucket == bucket) &&
+		(f.Key == "" || f.Key == key)
+}
+
+func (c *Client) bucket(name string) map[string]Object {
+	b, ok := c.buckets[name]
+	if !ok {
+		b = make(map[string]Object)
+		c.buckets[name] = b
+	}
+	return b
+}
+
+func (c *Client) sortedKeys(bucket, prefix string) []string {
+	var keys []string
+	for key := range c.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *Client) listPage(bucket, prefix, startAfter string, maxKeys int) ([]string, bool) {
+	keys := c.sortedKeys(bucket, prefix)
+	start := sort.SearchStrings(keys, startAfter)
+	if start < len(keys) && keys[start] == startAfter {
+		start++
+	}
+
+	keys = keys[start:]
+	if len(keys) > maxKeys {
+		return keys[:maxKeys], true
+	}
+	return keys, false
+}
+
+// trimKeyPrefix mirrors how s3client.AWSClient turns keys into relative paths.
+func trimKeyPrefix(key, prefix string) string {
+	if prefix == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+func checksum(data []byte) string {
+	return crc64nvme.Encode(crc64nvme.Checksum(data))
+}
+
+// etag returns the ETag S3 gives an object of data uploaded in one part.
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// checksumIn returns the checksum of data in algorithm, CRC64NVME if empty.
+func checksumIn(data []byte, algorithm string) (string, error) {
+	h, err := s3client.NewChecksumHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	h.Write(data)
+	return s3client.EncodeChecksum(h), nil
+}
@@ -0,0 +1,335 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/s3client"
+)
+
+func TestPutObjectCalculatesChecksum(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "empty object",
+			content: "",
+			want:    "AAAAAAAAAAA=",
+		},
+		{
+			name:    "hello world",
+			content: "Hello, World!\n",
+			want:    "SoXXbx67KpE=",
+		},
+		{
+			name:    "known hash",
+			content: "The quick brown fox jumps over the lazy dog\n",
+			want:    "2yX60sjqiYo=",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New()
+			err := c.PutObject(context.Background(), &s3client.PutObjectRequest{
+				Bucket:      "bucket",
+				Key:         "key",
+				Body:        strings.NewReader(tt.content),
+				Size:        int64(len(tt.content)),
+				ContentType: "text/plain",
+			})
+			if err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+
+			info, err := c.HeadObject(context.Background(), &s3client.HeadObjectRequest{Bucket: "bucket", Key: "key"})
+			if err != nil {
+				t.Fatalf("HeadObject() error = %v", err)
+			}
+			if info.Checksum != tt.want {
+				t.Errorf("HeadObject().Checksum = %v, want %v", info.Checksum, tt.want)
+			}
+			if info.Size != int64(len(tt.content)) {
+				t.Errorf("HeadObject().Size = %v, want %v", info.Size, len(tt.content))
+			}
+
+			obj, err := c.GetObject(context.Background(), &s3client.GetObjectRequest{Bucket: "bucket", Key: "key"})
+			if err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			defer obj.Body.Close()
+			data, err := io.ReadAll(obj.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.content || obj.Checksum != tt.want {
+				t.Errorf("GetObject() = %q (%s), want %q (%s)", data, obj.Checksum, tt.content, tt.want)
+			}
+		})
+	}
+}
+
+func TestPutObjectSizeMismatch(t *testing.T) {
+	c := New()
+	err := c.PutObject(context.Background(), &s3client.PutObjectRequest{
+		Bucket: "bucket",
+		Key:    "key",
+		Body:   strings.NewReader("12345"),
+		Size:   10,
+	})
+	if err == nil {
+		t.Fatal("PutObject() expected error for size mismatch")
+	}
+	if _, ok := c.Object("bucket", "key"); ok {
+		t.Error("object must not be stored when the upload fails")
+	}
+}
+
+func TestListObjects(t *testing.T) {
+	c := New()
+	c.PageSize = 2
+	for _, key := range []string{"prefix/c.txt", "prefix/a.txt", "prefix/sub/b.txt", "other/x.txt", "prefix/d.txt", "prefixed.txt"} {
+		c.PutBytes("bucket", key, []byte(key))
+	}
+
+	tests := []struct {
+		name      string
+		prefix    string
+		want      []string
+		wantPages int
+	}{
+		{
+			name:      "prefix listing across pages",
+			prefix:    "prefix",
+			want:      []string{"a.txt", "c.txt", "d.txt", "sub/b.txt", "prefixed.txt"},
+			wantPages: 3,
+		},
+		{
+			name:      "whole bucket",
+			prefix:    "",
+			want:      []string{"other/x.txt", "prefix/a.txt", "prefix/c.txt", "prefix/d.txt", "prefix/sub/b.txt", "prefixed.txt"},
+			wantPages: 3,
+		},
+		{
+			name:      "no match",
+			prefix:    "nothing",
+			want:      nil,
+			wantPages: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := c.Calls(OpListObjects)
+			items, err := c.ListObjects(context.Background(), &s3client.ListObjectsRequest{Bucket: "bucket", Prefix: tt.prefix})
+			if err != nil {
+				t.Fatalf("ListObjects() error = %v", err)
+			}
+
+			var got []string
+			for _, item := range items {
+				got = append(got, item.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListObjects() = %v, want %v", got, tt.want)
+			}
+			if pages := c.Calls(OpListObjects) - before; pages != tt.wantPages {
+				t.Errorf("ListObjects() made %d page calls, want %d", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestListPage(t *testing.T) {
+	c := New()
+	for _, key := range []string{"a", "b", "c"} {
+		c.PutBytes("bucket", key, []byte(key))
+	}
+
+	page, truncated := c.ListPage("bucket", "", "a", 1)
+	if len(page) != 1 || page[0].Key != "b" || !truncated {
+		t.Errorf("ListPage() = %+v, %v, want [b], true", page, truncated)
+	}
+
+	page, truncated = c.ListPage("bucket", "", "b", 10)
+	if len(page) != 1 || page[0].Key != "c" || truncated {
+		t.Errorf("ListPage() = %+v, %v, want [c], false", page, truncated)
+	}
+}
+
+func TestCopyAndDeleteObject(t *testing.T) {
+	c := New()
+	ctx := context.Background()
+	c.SetObject("src", Object{Key: "legacy.txt", Data: []byte("Hello, World!\n"), ContentType: "text/plain"})
+
+	err := c.CopyObject(ctx, &s3client.CopyObjectRequest{
+		SourceBucket: "src",
+		SourceKey:    "legacy.txt",
+		Bucket:       "dst",
+		Key:          "copied.txt",
+		Size:         14,
+	})
+	if err != nil {
+		t.Fatalf("CopyObject() error = %v", err)
+	}
+
+	obj, ok := c.Object("dst", "copied.txt")
+	if !ok {
+		t.Fatal("copied object not found")
+	}
+	if obj.Checksum != "SoXXbx67KpE=" || obj.ContentType != "text/plain" {
+		t.Errorf("copied object = %+v, want checksum calculated and content type kept", obj)
+	}
+
+	if err := c.DeleteObject(ctx, &s3client.DeleteObjectRequest{Bucket: "dst", Key: "copied.txt"}); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	if _, ok := c.Object("dst", "copied.txt"); ok {
+		t.Error("object still exists after DeleteObject()")
+	}
+
+	// Deleting a missing object succeeds like S3
+	if err := c.DeleteObject(ctx, &s3client.DeleteObjectRequest{Bucket: "dst", Key: "copied.txt"}); err != nil {
+		t.Errorf("DeleteObject() of missing object error = %v", err)
+	}
+
+	_, err = c.HeadObject(ctx, &s3client.HeadObjectRequest{Bucket: "dst", Key: "copied.txt"})
+	if !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("HeadObject() error = %v, want ErrNoSuchKey", err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	errSlowDown := errors.New("SlowDown: please reduce your request rate")
+
+	tests := []struct {
+		name    string
+		fault   Fault
+		key     string
+		wantErr []bool
+	}{
+		{
+			name:    "error on every call",
+			fault:   Fault{Op: OpHeadObject, Err: errSlowDown},
+			key:     "a.txt",
+			wantErr: []bool{true, true, true},
+		},
+		{
+			name:    "error only for the first call",
+			fault:   Fault{Op: OpHeadObject, Err: errSlowDown, Times: 1},
+			key:     "a.txt",
+			wantErr: []bool{true, false, false},
+		},
+		{
+			name:    "error for another key",
+			fault:   Fault{Op: OpHeadObject, Key: "b.txt", Err: errSlowDown},
+			key:     "a.txt",
+			wantErr: []bool{false, false},
+		},
+		{
+			name:    "error for another operation",
+			fault:   Fault{Op: OpPutObject, Err: errSlowDown},
+			key:     "a.txt",
+			wantErr: []bool{false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New()
+			c.PutBytes("bucket", "a.txt", []byte("a"))
+			c.AddFault(tt.fault)
+
+			for i, wantErr := range tt.wantErr {
+				_, err := c.HeadObject(context.Background(), &s3client.HeadObjectRequest{Bucket: "bucket", Key: tt.key})
+				if (err != nil) != wantErr {
+					t.Errorf("call %d: HeadObject() error = %v, wantErr %v", i, err, wantErr)
+				}
+				if err != nil && !errors.Is(err, errSlowDown) {
+					t.Errorf("call %d: HeadObject() error = %v, want %v", i, err, errSlowDown)
+				}
+			}
+		})
+	}
+}
+
+func TestFaultOmitChecksum(t *testing.T) {
+	c := New()
+	ctx := context.Background()
+	c.AddFault(Fault{Op: OpPutObject, Key: "no-checksum.txt", OmitChecksum: true})
+
+	for _, key := range []string{"no-checksum.txt", "checksum.txt"} {
+		err := c.PutObject(ctx, &s3client.PutObjectRequest{Bucket: "bucket", Key: key, Body: strings.NewReader("data"), Size: 4})
+		if err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+	}
+
+	if obj, _ := c.Object("bucket", "no-checksum.txt"); obj.Checksum != "" {
+		t.Errorf("checksum = %q, want empty", obj.Checksum)
+	}
+	if obj, _ := c.Object("bucket", "checksum.txt"); obj.Checksum == "" {
+		t.Error("checksum is empty, want calculated")
+	}
+
+	c.AddFault(Fault{Op: OpHeadObject, OmitChecksum: true})
+	info, err := c.HeadObject(ctx, &s3client.HeadObjectRequest{Bucket: "bucket", Key: "checksum.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Checksum != "" {
+		t.Errorf("HeadObject().Checksum = %q, want hidden", info.Checksum)
+	}
+}
+
+func TestFaultLatencyRespectsContext(t *testing.T) {
+	c := New()
+	c.PutBytes("bucket", "a.txt", []byte("a"))
+	c.AddFault(Fault{Latency: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := c.HeadObject(ctx, &s3client.HeadObjectRequest{Bucket: "bucket", Key: "a.txt"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("HeadObject() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	c := New()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("file-%02d.txt", i)
+			data := key
+			if err := c.PutObject(ctx, &s3client.PutObjectRequest{Bucket: "bucket", Key: key, Body: strings.NewReader(data), Size: int64(len(data))}); err != nil {
+				t.Error(err)
+			}
+			if _, err := c.ListObjects(ctx, &s3client.ListObjectsRequest{Bucket: "bucket"}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := len(c.Objects("bucket", "")); got != 50 {
+		t.Errorf("stored %d objects, want 50", got)
+	}
+	if got := c.Calls(OpPutObject); got != 50 {
+		t.Errorf("Calls(OpPutObject) = %d, want 50", got)
+	}
+}
@@ -0,0 +1,1114 @@
+// Package s3test provides an httptest server that speaks the subset of the
+// S3 REST API used by s3client.AWSClient, so that the real SDK request paths
+// can be tested without network access.
+//
+// Supported operations are ListObjectsV2, HeadObject, GetObject, PutObject,
+// CopyObject, DeleteObject, GetObjectAttributes, GetObjectAcl,
+// GetObjectTagging and the multipart upload APIs including UploadPartCopy. Objects get a CRC64NVME checksum the same way S3 does,
+// checksums sent by the client (as headers or aws-chunked trailers) are
+// verified, and HeadObject/GetObject return the checksum when
+// x-amz-checksum-mode is ENABLED.
+//
+// Only path-style requests are understood. The SDK uses path-style
+// addressing automatically for IP endpoints such as the httptest URL.
+package s3test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuya-takeyama/strict-s3-sync/pkg/crc64nvme"
+)
+
+const (
+	// DefaultPageSize is the default max-keys of ListObjectsV2.
+	DefaultPageSize = 1000
+	// MinPartSize is the minimum size of every part but the last.
+	MinPartSize = 5 * 1024 * 1024
+
+	checksumHeader = "X-Amz-Checksum-Crc64nvme"
+	timeFormat     = "2006-01-02T15:04:05.000Z"
+)
+
+// Object is an object stored in the Server.
+type Object struct {
+	Key          string
+	Data         []byte
+	Checksum     string // Base64 encoded CRC64NVME, unless ChecksumAlgorithm is set
+	ChecksumType string // FULL_OBJECT or COMPOSITE
+	ETag         string
+	ContentType  string
+	Metadata     map[string]string
+	LastModified time.Time
+	PartCount    int   // Zero for objects not created by a multipart upload
+	PartSize     int64 // Size of every part but the last of a multipart upload
+	// ChecksumAlgorithm is the algorithm of Checksum, e.g. CRC32C, for
+	// objects stored with a checksum other than CRC64NVME.
+	ChecksumAlgorithm string
+	// Tags are the tags of the object. A copy gets the tags of its source
+	// unless x-amz-tagging-directive is REPLACE.
+	Tags map[string]string
+	Attributes
+}
+
+// Attributes are the attributes of an object that a copy only gets if they
+// are sent with it.
+type Attributes struct {
+	StorageClass            string // Empty for STANDARD
+	ServerSideEncryption    string
+	SSEKMSKeyID             string
+	WebsiteRedirectLocation string
+	// Grants maps the x-amz-grant-* headers the object was stored with,
+	// e.g. X-Amz-Grant-Read, to their grantees. Without grants, only the
+	// owner has access.
+	Grants map[string]string
+}
+
+// OwnerID is the canonical user ID of the owner of every object.
+const OwnerID = "s3test-owner"
+
+// grantPermissions maps the x-amz-grant-* headers to the permissions they grant.
+var grantPermissions = map[string]string{
+	"X-Amz-Grant-Full-Control": "FULL_CONTROL",
+	"X-Amz-Grant-Read":         "READ",
+	"X-Amz-Grant-Read-Acp":     "READ_ACP",
+	"X-Amz-Grant-Write-Acp":    "WRITE_ACP",
+}
+
+// Request is a request received by the Server.
+type Request struct {
+	Method string
+	Bucket string
+	Key    string
+	Query  url.Values
+	Header http.Header
+}
+
+// Server is an S3-compatible HTTP server backed by memory.
+type Server struct {
+	*httptest.Server
+
+	// PageSize is the max-keys used when the request doesn't specify one.
+	PageSize int
+	// OmitChecksums makes the server behave like an S3-compatible store
+	// without CRC64NVME support: HeadObject, GetObject and PutObject
+	// responses carry no checksum.
+	OmitChecksums bool
+	// CorruptUploads flips a byte of every uploaded object once the
+	// checksums sent with it have been verified, so that the checksum the
+	// server stores and returns doesn't match what was sent.
+	CorruptUploads bool
+	// DenyObjectAttributes makes GetObjectAttributes fail with AccessDenied,
+	// like S3 without the s3:GetObjectAttributes permission.
+	DenyObjectAttributes bool
+
+	mu       sync.Mutex
+	buckets  map[string]map[string]*Object
+	uploads  map[string]*multipartUpload
+	uploadID int
+	requests []Request
+}
+
+type multipartUpload struct {
+	bucket       string
+	key          string
+	contentType  string
+	metadata     map[string]string
+	tags         map[string]string
+	attributes   Attributes
+	checksumType string
+	parts        map[int]*part
+}
+
+type part struct {
+	data     []byte
+	etag     string
+	checksum string
+}
+
+// NewServer starts an HTTP server. Call Close when done.
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewTLSServer starts an HTTPS server. Over TLS the SDK sends checksums as
+// aws-chunked trailers instead of headers. Use Client() as the HTTP client.
+func NewTLSServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+func newServer() *Server {
+	return &Server{
+		PageSize: DefaultPageSize,
+		buckets:  make(map[string]map[string]*Object),
+		uploads:  make(map[string]*multipartUpload),
+	}
+}
+
+// PutObject stores data as bucket/key with its CRC64NVME checksum.
+func (s *Server) PutObject(bucket, key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(bucket, &Object{Key: key, Data: bytes.Clone(data)})
+}
+
+// PutCompositeObject stores data as bucket/key as if it was uploaded in
+// parts of partSize bytes with a COMPOSITE checksum.
+func (s *Server) PutCompositeObject(bucket, key string, data []byte, partSize int) {
+	var crcs []uint64
+	for off := 0; off < len(data); off += partSize {
+		crcs = append(crcs, crc64nvme.Checksum(data[off:min(off+partSize, len(data))]))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(bucket, &Object{
+		Key:          key,
+		Data:         bytes.Clone(data),
+		Checksum:     crc64nvme.Composite(crcs),
+		ChecksumType: "COMPOSITE",
+		PartCount:    len(crcs),
+		PartSize:     int64(partSize),
+	})
+}
+
+// PutObjectWithChecksum stores data as bucket/key with checksum as its only
+// checksum, like objects uploaded by clients that chose another algorithm.
+func (s *Server) PutObjectWithChecksum(bucket, key string, data []byte, algorithm, checksum string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(bucket, &Object{
+		Key:               key,
+		Data:              bytes.Clone(data),
+		Checksum:          checksum,
+		ChecksumType:      "FULL_OBJECT",
+		ChecksumAlgorithm: algorithm,
+	})
+}
+
+// Object returns a copy of the stored object.
+func (s *Server) Object(bucket, key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return Object{}, false
+	}
+	clone := *obj
+	clone.Data = bytes.Clone(obj.Data)
+	return clone, true
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Uploads returns the number of multipart uploads that are neither completed nor aborted.
+func (s *Server) Uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+// corrupt flips the first byte of data if CorruptUploads is set.
+func (s *Server) corrupt(data []byte) {
+	if s.CorruptUploads && len(data) > 0 {
+		data[0] ^= 0xff
+	}
+}
+
+// checksumHeader returns the header the checksum of obj is returned in.
+func (obj *Object) checksumHeader() string {
+	if obj.ChecksumAlgorithm == "" {
+		return checksumHeader
+	}
+	return "X-Amz-Checksum-" + obj.ChecksumAlgorithm
+}
+
+// store saves obj, filling in the checksum, ETag and modification time.
+// The caller must hold s.mu.
+func (s *Server) store(bucket string, obj *Object) {
+	if obj.Checksum == "" {
+		obj.Checksum = checksum(obj.Data)
+		obj.ChecksumType = "FULL_OBJECT"
+	}
+	if obj.ETag == "" {
+		obj.ETag = etag(obj.Data)
+	}
+	obj.LastModified = time.Now().UTC().Truncate(time.Second)
+
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]*Object)
+	}
+	s.buckets[bucket][obj.Key] = obj
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Bucket: bucket,
+		Key:    key,
+		Query:  query,
+		Header: r.Header.Clone(),
+	})
+	s.mu.Unlock()
+
+	if bucket == "" {
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "ListBuckets is not supported")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.listObjectsV2(w, bucket, query)
+	case key == "":
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" on a bucket is not supported")
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completeMultipartUpload(w, r, bucket, key, query.Get("uploadId"))
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		s.uploadPart(w, r, query.Get("uploadId"), query.Get("partNumber"))
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet && query.Has("attributes"):
+		s.getObjectAttributes(w, r, bucket, key)
+	case r.Method == http.MethodGet && query.Has("acl"):
+		s.getObjectACL(w, bucket, key)
+	case r.Method == http.MethodGet && query.Has("tagging"):
+		s.getObjectTagging(w, bucket, key)
+	case r.Method == http.MethodHead, r.Method == http.MethodGet:
+		s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.abortMultipartUpload(w, query.Get("uploadId"))
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, bucket, key)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not allowed")
+	}
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	Contents              []listedObject `xml:"Contents"`
+}
+
+type listedObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+func (s *Server) listObjectsV2(w http.ResponseWriter, bucket string, query url.Values) {
+	if query.Get("list-type") != "2" {
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "only ListObjectsV2 is supported")
+		return
+	}
+
+	prefix := query.Get("prefix")
+	maxKeys := s.PageSize
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		maxKeys = n
+	}
+
+	// The continuation token is the last key of the previous page
+	after := query.Get("start-after")
+	token := query.Get("continuation-token")
+	if token != "" {
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid continuation token")
+			return
+		}
+		after = string(decoded)
+	}
+
+	s.mu.Lock()
+	var keys []string
+	for key := range s.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{
+		Name:              bucket,
+		Prefix:            prefix,
+		MaxKeys:           maxKeys,
+		ContinuationToken: token,
+		StartAfter:        query.Get("start-after"),
+	}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(keys[len(keys)-1]))
+	}
+	for _, key := range keys {
+		obj := s.buckets[bucket][key]
+		result.Contents = append(result.Contents, listedObject{
+			Key:          obj.Key,
+			LastModified: obj.LastModified.Format(timeFormat),
+			ETag:         obj.ETag,
+			Size:         int64(len(obj.Data)),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	s.mu.Unlock()
+
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	obj, ok := s.Object(bucket, key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Length", strconv.Itoa(len(obj.Data)))
+	h.Set("ETag", obj.ETag)
+	h.Set("Last-Modified", obj.LastModified.Format(http.TimeFormat))
+	if obj.ContentType != "" {
+		h.Set("Content-Type", obj.ContentType)
+	}
+	for k, v := range obj.Metadata {
+		h.Set("X-Amz-Meta-"+k, v)
+	}
+	if obj.PartCount > 0 {
+		h.Set("X-Amz-Mp-Parts-Count", strconv.Itoa(obj.PartCount))
+	}
+	if len(obj.Tags) > 0 {
+		h.Set("X-Amz-Tagging-Count", strconv.Itoa(len(obj.Tags)))
+	}
+	for name, value := range map[string]string{
+		"X-Amz-Storage-Class":                         obj.StorageClass,
+		"X-Amz-Server-Side-Encryption":                obj.ServerSideEncryption,
+		"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": obj.SSEKMSKeyID,
+		"X-Amz-Website-Redirect-Location":             obj.WebsiteRedirectLocation,
+	} {
+		if value != "" {
+			h.Set(name, value)
+		}
+	}
+	if strings.EqualFold(r.Header.Get("X-Amz-Checksum-Mode"), "ENABLED") && !s.OmitChecksums {
+		h.Set(obj.checksumHeader(), obj.Checksum)
+		h.Set("X-Amz-Checksum-Type", obj.ChecksumType)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(obj.Data)
+	}
+}
+
+type getObjectAttributesResult struct {
+	XMLName     xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ GetObjectAttributesResponse"`
+	ETag        string       `xml:"ETag,omitempty"`
+	ObjectSize  int64        `xml:"ObjectSize"`
+	ObjectParts *objectParts `xml:"ObjectParts,omitempty"`
+}
+
+type objectParts struct {
+	TotalPartsCount      int          `xml:"PartsCount"`
+	PartNumberMarker     int          `xml:"PartNumberMarker"`
+	NextPartNumberMarker int          `xml:"NextPartNumberMarker"`
+	MaxParts             int          `xml:"MaxParts"`
+	IsTruncated          bool         `xml:"IsTruncated"`
+	Parts                []objectPart `xml:"Part"`
+}
+
+type objectPart struct {
+	PartNumber int   `xml:"PartNumber"`
+	Size       int64 `xml:"Size"`
+}
+
+// getObjectAttributes reports the ETag, size and parts of an object. Parts
+// are only reported for multipart uploads with a COMPOSITE checksum, like S3 does.
+func (s *Server) getObjectAttributes(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if s.DenyObjectAttributes {
+		writeError(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+	obj, ok := s.Object(bucket, key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	attributes := strings.Split(r.Header.Get("X-Amz-Object-Attributes"), ",")
+	result := getObjectAttributesResult{ObjectSize: int64(len(obj.Data))}
+	for _, attr := range attributes {
+		switch strings.TrimSpace(attr) {
+		case "ETag":
+			result.ETag = strings.Trim(obj.ETag, `"`)
+		case "ObjectParts":
+			if obj.ChecksumType != "COMPOSITE" || obj.PartCount == 0 {
+				continue
+			}
+			maxParts := obj.PartCount
+			if v, err := strconv.Atoi(r.Header.Get("X-Amz-Max-Parts")); err == nil && v < maxParts {
+				maxParts = v
+			}
+			parts := &objectParts{TotalPartsCount: obj.PartCount, MaxParts: maxParts, IsTruncated: maxParts < obj.PartCount}
+			for n := 1; n <= maxParts; n++ {
+				off := int64(n-1) * obj.PartSize
+				parts.Parts = append(parts.Parts, objectPart{PartNumber: n, Size: min(obj.PartSize, int64(len(obj.Data))-off)})
+				parts.NextPartNumberMarker = n
+			}
+			result.ObjectParts = parts
+		}
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+type accessControlPolicy struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ AccessControlPolicy"`
+	OwnerID string   `xml:"Owner>ID"`
+	Grants  []grant  `xml:"AccessControlList>Grant"`
+}
+
+type grant struct {
+	ID           string `xml:"Grantee>ID,omitempty"`
+	URI          string `xml:"Grantee>URI,omitempty"`
+	EmailAddress string `xml:"Grantee>EmailAddress,omitempty"`
+	Permission   string `xml:"Permission"`
+}
+
+// getObjectACL returns the grants an object was stored with, or full
+// control for the owner if there are none.
+func (s *Server) getObjectACL(w http.ResponseWriter, bucket, key string) {
+	obj, ok := s.Object(bucket, key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	policy := accessControlPolicy{OwnerID: OwnerID}
+	if len(obj.Grants) == 0 {
+		policy.Grants = []grant{{ID: OwnerID, Permission: "FULL_CONTROL"}}
+	}
+	headers := make([]string, 0, len(obj.Grants))
+	for header := range obj.Grants {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+	for _, header := range headers {
+		for _, grantee := range strings.Split(obj.Grants[header], ",") {
+			typ, value, _ := strings.Cut(strings.TrimSpace(grantee), "=")
+			value = strings.Trim(value, `"`)
+			g := grant{Permission: grantPermissions[header]}
+			switch typ {
+			case "id":
+				g.ID = value
+			case "uri":
+				g.URI = value
+			case "emailAddress":
+				g.EmailAddress = value
+			}
+			policy.Grants = append(policy.Grants, g)
+		}
+	}
+
+	writeXML(w, http.StatusOK, policy)
+}
+
+type tagging struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ Tagging"`
+	Tags    []tag    `xml:"TagSet>Tag"`
+}
+
+type tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+func (s *Server) getObjectTagging(w http.ResponseWriter, bucket, key string) {
+	obj, ok := s.Object(bucket, key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	result := tagging{Tags: []tag{}}
+	for k, v := range obj.Tags {
+		result.Tags = append(result.Tags, tag{Key: k, Value: v})
+	}
+	sort.Slice(result.Tags, func(i, j int) bool { return result.Tags[i].Key < result.Tags[j].Key })
+
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	data, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	s.corrupt(data)
+
+	obj := &Object{
+		Key:         key,
+		Data:        data,
+		ContentType: r.Header.Get("Content-Type"),
+		Metadata:    userMetadata(r.Header),
+		Tags:        tags(r.Header),
+		Attributes:  attributes(r.Header),
+	}
+	if algorithm := checksumAlgorithm(r); algorithm != "CRC64NVME" {
+		obj.Checksum = checksumIn(data, algorithm)
+		obj.ChecksumType = "FULL_OBJECT"
+		obj.ChecksumAlgorithm = algorithm
+	}
+
+	s.mu.Lock()
+	s.store(bucket, obj)
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", obj.ETag)
+	if !s.OmitChecksums {
+		w.Header().Set(obj.checksumHeader(), obj.Checksum)
+		w.Header().Set("X-Amz-Checksum-Type", obj.ChecksumType)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type copyObjectResult struct {
+	XMLName           xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+	ETag              string   `xml:"ETag"`
+	LastModified      string   `xml:"LastModified"`
+	ChecksumCRC64NVME string   `xml:"ChecksumCRC64NVME,omitempty"`
+	ChecksumType      string   `xml:"ChecksumType,omitempty"`
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	src, ok := s.copySource(w, r)
+	if !ok {
+		return
+	}
+
+	replace := r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE"
+	if source, _ := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/")); source == bucket+"/"+key && !replace {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes.")
+		return
+	}
+
+	obj := &Object{
+		Key:         key,
+		Data:        src.Data,
+		ContentType: src.ContentType,
+		Metadata:    src.Metadata,
+		Tags:        src.Tags,
+		Attributes:  attributes(r.Header),
+	}
+	if replace {
+		obj.ContentType = r.Header.Get("Content-Type")
+		obj.Metadata = userMetadata(r.Header)
+	}
+	if r.Header.Get("X-Amz-Tagging-Directive") == "REPLACE" {
+		obj.Tags = tags(r.Header)
+	}
+	if algorithm := strings.ToUpper(r.Header.Get("X-Amz-Checksum-Algorithm")); algorithm != "" && algorithm != "CRC64NVME" {
+		obj.Checksum = checksumIn(obj.Data, algorithm)
+		obj.ChecksumType = "FULL_OBJECT"
+		obj.ChecksumAlgorithm = algorithm
+	}
+
+	s.mu.Lock()
+	s.store(bucket, obj)
+	s.mu.Unlock()
+
+	writeXML(w, http.StatusOK, copyObjectResult{
+		ETag:              obj.ETag,
+		LastModified:      obj.LastModified.Format(timeFormat),
+		ChecksumCRC64NVME: obj.Checksum,
+		ChecksumType:      obj.ChecksumType,
+	})
+}
+
+// copySource resolves x-amz-copy-source and applies x-amz-copy-source-range.
+func (s *Server) copySource(w http.ResponseWriter, r *http.Request) (Object, bool) {
+	source, _, _ := strings.Cut(r.Header.Get("X-Amz-Copy-Source"), "?")
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid x-amz-copy-source")
+		return Object{}, false
+	}
+	srcBucket, srcKey, _ := strings.Cut(source, "/")
+
+	obj, ok := s.Object(srcBucket, srcKey)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return Object{}, false
+	}
+
+	if rng := r.Header.Get("X-Amz-Copy-Source-Range"); rng != "" {
+		var start, end int
+		if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil || start > end || end >= len(obj.Data) {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid x-amz-copy-source-range "+rng)
+			return Object{}, false
+		}
+		obj.Data = obj.Data[start : end+1]
+	}
+
+	return obj, true
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, bucket, key string) {
+	s.mu.Lock()
+	delete(s.buckets[bucket], key)
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	checksumType := r.Header.Get("X-Amz-Checksum-Type")
+	if checksumType == "" {
+		// CRC64NVME only supports full object checksums
+		checksumType = "FULL_OBJECT"
+	}
+
+	s.mu.Lock()
+	s.uploadID++
+	uploadID := fmt.Sprintf("upload-%d", s.uploadID)
+	s.uploads[uploadID] = &multipartUpload{
+		bucket:       bucket,
+		key:          key,
+		contentType:  r.Header.Get("Content-Type"),
+		metadata:     userMetadata(r.Header),
+		tags:         tags(r.Header),
+		attributes:   attributes(r.Header),
+		checksumType: checksumType,
+		parts:        make(map[int]*part),
+	}
+	s.mu.Unlock()
+
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: uploadID,
+	})
+}
+
+type copyPartResult struct {
+	XMLName           xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyPartResult"`
+	ETag              string   `xml:"ETag"`
+	LastModified      string   `xml:"LastModified"`
+	ChecksumCRC64NVME string   `xml:"ChecksumCRC64NVME,omitempty"`
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, uploadID, partNumber string) {
+	number, err := strconv.Atoi(partNumber)
+	if err != nil || number < 1 || number > 10000 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid partNumber "+partNumber)
+		return
+	}
+
+	copied := r.Header.Get("X-Amz-Copy-Source") != ""
+	var data []byte
+	if copied {
+		src, ok := s.copySource(w, r)
+		if !ok {
+			return
+		}
+		data = src.Data
+	} else {
+		var ok bool
+		if data, ok = readBody(w, r); !ok {
+			return
+		}
+	}
+
+	p := &part{data: data, etag: etag(data), checksum: checksum(data)}
+
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	if ok {
+		upload.parts[number] = p
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+
+	if copied {
+		writeXML(w, http.StatusOK, copyPartResult{
+			ETag:              p.etag,
+			LastModified:      time.Now().UTC().Format(timeFormat),
+			ChecksumCRC64NVME: p.checksum,
+		})
+		return
+	}
+
+	w.Header().Set("ETag", p.etag)
+	w.Header().Set(checksumHeader, p.checksum)
+	w.WriteHeader(http.StatusOK)
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber        int    `xml:"PartNumber"`
+		ETag              string `xml:"ETag"`
+		ChecksumCRC64NVME string `xml:"ChecksumCRC64NVME"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName           xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location          string   `xml:"Location"`
+	Bucket            string   `xml:"Bucket"`
+	Key               string   `xml:"Key"`
+	ETag              string   `xml:"ETag"`
+	ChecksumCRC64NVME string   `xml:"ChecksumCRC64NVME,omitempty"`
+	ChecksumType      string   `xml:"ChecksumType,omitempty"`
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.bucket != bucket || upload.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	if len(req.Parts) == 0 {
+		writeError(w, http.StatusBadRequest, "MalformedXML", "at least one part must be specified")
+		return
+	}
+
+	var data bytes.Buffer
+	var etags []byte
+	var crcs []uint64
+	for i, listed := range req.Parts {
+		if i > 0 && listed.PartNumber <= req.Parts[i-1].PartNumber {
+			writeError(w, http.StatusBadRequest, "InvalidPartOrder", "parts must be listed in ascending order")
+			return
+		}
+		p, ok := upload.parts[listed.PartNumber]
+		if !ok || p.etag != listed.ETag {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d could not be found or its ETag does not match", listed.PartNumber))
+			return
+		}
+		if listed.ChecksumCRC64NVME != "" && listed.ChecksumCRC64NVME != p.checksum {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("checksum of part %d does not match", listed.PartNumber))
+			return
+		}
+		if i < len(req.Parts)-1 && len(p.data) < MinPartSize {
+			writeError(w, http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("part %d is smaller than the minimum allowed size", listed.PartNumber))
+			return
+		}
+		data.Write(p.data)
+		crcs = append(crcs, crc64nvme.Checksum(p.data))
+		sum, _ := hex.DecodeString(strings.Trim(p.etag, `"`))
+		etags = append(etags, sum...)
+	}
+
+	s.corrupt(data.Bytes())
+
+	obj := &Object{
+		Key:          key,
+		Data:         data.Bytes(),
+		Checksum:     checksum(data.Bytes()),
+		ChecksumType: upload.checksumType,
+		ContentType:  upload.contentType,
+		Metadata:     upload.metadata,
+		Tags:         upload.tags,
+		Attributes:   upload.attributes,
+		PartCount:    len(req.Parts),
+		PartSize:     int64(len(upload.parts[req.Parts[0].PartNumber].data)),
+	}
+	if obj.ChecksumType == "COMPOSITE" {
+		obj.Checksum = crc64nvme.Composite(crcs)
+	}
+	if expected := r.Header.Get(checksumHeader); expected != "" && expected != obj.Checksum {
+		writeError(w, http.StatusBadRequest, "BadDigest", "The CRC64NVME you specified did not match the calculated checksum.")
+		return
+	}
+	sum := md5.Sum(etags)
+	obj.ETag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(req.Parts))
+
+	s.store(bucket, obj)
+	delete(s.uploads, uploadID)
+
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Location:          "/" + bucket + "/" + key,
+		Bucket:            bucket,
+		Key:               key,
+		ETag:              obj.ETag,
+		ChecksumCRC64NVME: obj.Checksum,
+		ChecksumType:      obj.ChecksumType,
+	})
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, uploadID string) {
+	s.mu.Lock()
+	_, ok := s.uploads[uploadID]
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readBody reads the request payload, decoding aws-chunked bodies, and
+// verifies the checksum sent as a header or trailer.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	var (
+		data     []byte
+		trailers http.Header
+		err      error
+	)
+	if strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") ||
+		strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		data, trailers, err = decodeAWSChunked(r.Body)
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return nil, false
+	}
+
+	if v := r.Header.Get("X-Amz-Decoded-Content-Length"); v != "" && v != strconv.Itoa(len(data)) {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", "decoded content length does not match")
+		return nil, false
+	}
+
+	algorithm := checksumAlgorithm(r)
+	header := "X-Amz-Checksum-" + algorithm
+	expected := r.Header.Get(header)
+	if expected == "" {
+		expected = trailers.Get(header)
+	}
+	if expected != "" && expected != checksumIn(data, algorithm) {
+		writeError(w, http.StatusBadRequest, "BadDigest", "The "+algorithm+" you specified did not match the calculated checksum.")
+		return nil, false
+	}
+
+	return data, true
+}
+
+// decodeAWSChunked decodes an aws-chunked payload:
+//
+//	<hex size>[;chunk-signature=...]\r\n<data>\r\n ... 0\r\n<trailers>\r\n
+func decodeAWSChunked(body io.Reader) ([]byte, http.Header, error) {
+	br := bufio.NewReader(body)
+	var data bytes.Buffer
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read chunk header: %w", err)
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid chunk size %q", sizeHex)
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&data, br, size); err != nil {
+			return nil, nil, fmt.Errorf("failed to read chunk: %w", err)
+		}
+		if crlf, err := br.ReadString('\n'); err != nil || crlf != "\r\n" {
+			return nil, nil, fmt.Errorf("chunk is not terminated by CRLF")
+		}
+	}
+
+	trailers := http.Header{}
+	for {
+		line, err := br.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid trailer %q", line)
+		}
+		trailers.Set(name, strings.TrimSpace(value))
+		if err != nil {
+			break
+		}
+	}
+
+	return data.Bytes(), trailers, nil
+}
+
+// userMetadata collects the x-amz-meta- headers without their prefix.
+func userMetadata(h http.Header) map[string]string {
+	var metadata map[string]string
+	for name, values := range h {
+		if suffix, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok && len(values) > 0 {
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[suffix] = values[0]
+		}
+	}
+	return metadata
+}
+
+// tags returns the tags sent in x-amz-tagging.
+func tags(h http.Header) map[string]string {
+	values, err := url.ParseQuery(h.Get("X-Amz-Tagging"))
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(values))
+	for k, v := range values {
+		result[k] = v[0]
+	}
+	return result
+}
+
+// attributes returns the attributes sent with an upload or copy.
+func attributes(h http.Header) Attributes {
+	attrs := Attributes{
+		StorageClass:            h.Get("X-Amz-Storage-Class"),
+		ServerSideEncryption:    h.Get("X-Amz-Server-Side-Encryption"),
+		SSEKMSKeyID:             h.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"),
+		WebsiteRedirectLocation: h.Get("X-Amz-Website-Redirect-Location"),
+	}
+	if attrs.StorageClass == "STANDARD" {
+		attrs.StorageClass = ""
+	}
+	for header := range grantPermissions {
+		if grantees := h.Get(header); grantees != "" {
+			if attrs.Grants == nil {
+				attrs.Grants = make(map[string]string)
+			}
+			attrs.Grants[header] = grantees
+		}
+	}
+	return attrs
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeXML(w, status, errorResponse{Code: code, Message: message, RequestID: "s3test"})
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(body)))
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, xml.Header)
+	_, _ = w.Write(body)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func checksum(data []byte) string {
+	return crc64nvme.Encode(crc64nvme.Checksum(data))
+}
+
+// checksumAlgorithm returns the checksum algorithm of an upload request.
+func checksumAlgorithm(r *http.Request) string {
+	if algorithm := r.Header.Get("X-Amz-Sdk-Checksum-Algorithm"); algorithm != "" {
+		return strings.ToUpper(algorithm)
+	}
+	return "CRC64NVME"
+}
+
+// checksumIn returns the base64 encoded checksum of data in algorithm.
+func checksumIn(data []byte, algorithm string) string {
+	var h hash.Hash
+	switch algorithm {
+	case "CRC32C":
+		h = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "CRC32":
+		h = crc32.NewIEEE()
+	case "SHA256":
+		h = sha256.New()
+	case "SHA1":
+		h = sha1.New()
+	default:
+		return checksum(data)
+	}
+	h.Write(data)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
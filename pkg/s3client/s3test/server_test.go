@@ -0,0 +1,76 @@
+package s3test
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeAWSChunked(t *testing.T) {
+	body := "5;chunk-signature=abc\r\nHello\r\n8\r\n, World!\r\n0\r\nx-amz-checksum-crc64nvme:Ru6mZy+8b5g=\r\n\r\n"
+
+	data, trailers, err := decodeAWSChunked(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("decodeAWSChunked() error = %v", err)
+	}
+	if string(data) != "Hello, World!" {
+		t.Errorf("data = %q, want %q", data, "Hello, World!")
+	}
+	want := http.Header{"X-Amz-Checksum-Crc64nvme": {"Ru6mZy+8b5g="}}
+	if !reflect.DeepEqual(trailers, want) {
+		t.Errorf("trailers = %v, want %v", trailers, want)
+	}
+
+	if _, _, err := decodeAWSChunked(strings.NewReader("5\r\nHel")); err == nil {
+		t.Error("decodeAWSChunked() expected error for truncated chunk")
+	}
+}
+
+func TestPutObjectVerifiesChecksum(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		checksum   string
+		wantStatus int
+	}{
+		{
+			name:       "matching checksum",
+			checksum:   checksum([]byte("Hello, World!\n")),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "mismatching checksum",
+			checksum:   checksum([]byte("Hello, World?\n")),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no checksum",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, srv.URL+"/bucket/hello.txt", strings.NewReader("Hello, World!\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.checksum != "" {
+				req.Header.Set("x-amz-checksum-crc64nvme", tt.checksum)
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}